@@ -0,0 +1,69 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// addRootCmd represents the add-root command
+var addRootCmd = &cobra.Command{
+	Use:   "add-root <name> <dir>",
+	Short: "Track files in an additional directory.",
+	Long: `Add-root adds a directory, e.g. on another drive, to the repository
+	under the given name. Files in it are tracked together with files in the
+	base directory, with their paths prefixed by the name, e.g.
+	'drive2:photos/a.jpg'. Run 'update' to scan the new directory. All roots
+	must be available when updating, otherwise update fails rather than
+	recording their files as deleted.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		if err = local.AddRoot(args[0], args[1]); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		if !dryRun {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+		fmt.Printf("added root %s: %s\n", args[0], local.GetRoots()[args[0]])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addRootCmd)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// calendarAge matches ages given in calendar units, which time.ParseDuration
+// does not support: years, months, weeks and days.
+var calendarAge = regexp.MustCompile(`^(\d+)(y|mo|w|d)$`)
+
+// parseCutoff returns the time the given age before now. Age is either a
+// number of years, months, weeks or days, e.g. '2y', '6mo', '3w' or '90d', or
+// a duration accepted by time.ParseDuration, e.g. '36h'. Calendar units
+// follow the calendar, so '1mo' before March 31 is March 3 or 2. Negative ages
+// are not allowed.
+func parseCutoff(age string, now time.Time) (time.Time, error) {
+	if m := calendarAge.FindStringSubmatch(age); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid age '%s': %v", age, err)
+		}
+		switch m[2] {
+		case "y":
+			return now.AddDate(-n, 0, 0), nil
+		case "mo":
+			return now.AddDate(0, -n, 0), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		default:
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(age)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid age '%s'; expected e.g. '2y', '6mo', '90d' or '36h'", age)
+	}
+	if duration < 0 {
+		return time.Time{}, fmt.Errorf("invalid age '%s'; age can not be negative", age)
+	}
+	return now.Add(-duration), nil
+}
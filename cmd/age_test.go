@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCutoff(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		age      string
+		expected time.Time
+	}{
+		{"0d", now},
+		{"0s", now},
+		{"90d", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{"6mo", time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)}, // September 31
+		{"1mo", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)},  // February 31 of a leap year
+		{"2y", time.Date(2022, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{"36h", time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)},
+		{"1h30m", time.Date(2024, 3, 31, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cutoff, err := parseCutoff(test.age, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.age, err)
+			continue
+		}
+		if !cutoff.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.age, test.expected, cutoff)
+		}
+	}
+
+	for _, age := range []string{"", "d", "-5d", "-36h", "5x", "5m0", "1.5d", "99999999999999999999y"} {
+		if _, err := parseCutoff(age, now); err == nil {
+			t.Errorf("%s: expected error", age)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// checksumsCmd represents the checksums command
+var checksumsCmd = &cobra.Command{
+	Use:   "checksums <path>",
+	Short: "Show all checksums the file had over time.",
+	Long: `Checksums lists every distinct checksum recorded in the history of
+	the file, in order, together with the time it was recorded. Points where
+	the file was deleted are shown as 'deleted'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		path := repoPath(local, args[0])
+		file := local.GetFileByPath(path)
+		if file == nil {
+			log.Fatalf("ERROR: '%s' is not tracked\n", path)
+		}
+
+		lastChecksum := ""
+		for _, event := range file.History {
+			if event.Checksum == "" {
+				if lastChecksum != "" {
+					fmt.Printf("%s  %-*s  %s\n", event.Time.Format(time.RFC3339), hashWidth(), "deleted", event.Path)
+				}
+			} else if event.Checksum != lastChecksum {
+				fmt.Printf("%s  %-*s  %s\n", event.Time.Format(time.RFC3339), hashWidth(), displayHash(event.Checksum), event.Path)
+			}
+			lastChecksum = event.Checksum
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checksumsCmd)
+}
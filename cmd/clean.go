@@ -0,0 +1,130 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// cleanCmd represents the clean command
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove temporary files left behind by interrupted operations.",
+	Long: `Clean removes temporary files left behind in the repository by an
+	interrupted save, and in the base directory by an interrupted import, i.e.
+	files ending with '.boffin-tmp' or '.boffin-old'. Such files are never
+	tracked by 'update'. Backups of replaced files are moved back instead of
+	removed when they may be the only copy of the recorded file, i.e. when the
+	original file is missing, or when only the backup matches the recorded
+	checksum. Do not run clean while another save or import is running, as
+	its temporary files would be removed as well.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		failed := false
+		for _, path := range lib.FindTempFiles(local) {
+			if strings.HasSuffix(path, backupSuffix) {
+				restore, err := shouldRestoreBackup(local, path)
+				if err != nil {
+					log.Printf("ERROR: %v\n", err)
+					failed = true
+					continue
+				}
+				if restore {
+					orig := strings.TrimSuffix(path, backupSuffix)
+					fmt.Printf("mv %s %s\n", path, orig)
+					if dryRun {
+						continue
+					}
+					if err = os.Rename(path, orig); err != nil {
+						log.Printf("ERROR: %v\n", err)
+						failed = true
+					}
+					continue
+				}
+			}
+			fmt.Printf("rm %s\n", path)
+			if dryRun {
+				continue
+			}
+			if err = os.Remove(path); err != nil {
+				log.Printf("ERROR: %v\n", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// backupSuffix is appended by import to local files it replaces or deletes,
+// until the import is complete.
+const backupSuffix = ".boffin-old"
+
+// shouldRestoreBackup returns true if the backup left behind by an interrupted
+// import may be the only copy of the recorded file, and should be moved back
+// instead of removed. That is the case when the original file is missing, or
+// when the backup matches the recorded checksum while the original does not.
+func shouldRestoreBackup(repo lib.Boffin, backup string) (bool, error) {
+	orig := strings.TrimSuffix(backup, backupSuffix)
+	if _, err := os.Lstat(orig); os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	file := repo.GetFileByPath(repoPath(repo, orig))
+	if file == nil || file.IsDeleted() {
+		return false, nil
+	}
+	matches := func(path string) (bool, error) {
+		checksum, err := lib.CalculateChecksumWith(path, file.HashAlgorithm())
+		if err != nil {
+			return false, err
+		}
+		return checksum == file.Checksum(), nil
+	}
+	if ok, err := matches(orig); err != nil || ok {
+		return false, err
+	}
+	return matches(backup)
+}
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestShouldRestoreBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		orig     string
+		backup   string
+		expected bool
+	}{
+		{"original missing", "", "other", true},
+		{"original recorded", "recorded", "other", false},
+		{"backup recorded", "other", "recorded", true},
+		{"neither recorded", "other", "another", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepo(t, map[string]string{"a.ext": "recorded"})
+			path := repo.ResolvePath("a.ext")
+			writeTestFile(t, path+backupSuffix, test.backup)
+			if test.orig == "" {
+				if err := os.Remove(path); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				writeTestFile(t, path, test.orig)
+			}
+
+			restore, err := shouldRestoreBackup(repo, path+backupSuffix)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if restore != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, restore)
+			}
+		})
+	}
+}
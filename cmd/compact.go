@@ -0,0 +1,64 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Fold the journal of changes into the repository file.",
+	Long: `Saving the repository appends only the changes to a journal
+	(events.log), which is replayed every time the repository is loaded.
+	Compact writes all files into the repository file (files.json) and removes
+	the journal, which keeps loading fast once the journal grows large.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		if dryRun {
+			return
+		}
+		if err = local.Compact(); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		fmt.Printf("compacted %d files\n", len(local.GetFiles()))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+}
@@ -19,8 +19,12 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"time"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
@@ -36,121 +40,486 @@ var (
 	diffHideRemoteOld      = false
 	diffHideLocalDeleted   = false
 	diffHideRemoteDeleted  = false
+	diffHideBothDeleted    = false
+	diffHideReAdded        = false
 	diffHideLocalChanged   = false
 	diffHideRemoteChanged  = false
+	diffHideDiverged       = false
 	diffHideConflict       = false
+
+	diffStripLocalPrefix  = ""
+	diffStripRemotePrefix = ""
+	diffIgnoreCase        = false
+	diffGrouped           = false
+	diffJSON              = false
+	diffSummary           = false
+
+	diffMinChangeBytes   int64   = 0
+	diffMinChangePercent float64 = 0
+
+	diffSince = ""
+	diffUntil = ""
 )
 
+// parseTimeBound parses a point in time given either as age, same as
+// prune --older-than, or as RFC3339 time or date. Empty value returns zero
+// time.
+func parseTimeBound(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	cutoff, err := parseCutoff(value, time.Now())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected age (e.g. '6mo', '7d' or '36h'), date or RFC3339 time")
+	}
+	return cutoff, nil
+}
+
+// isSignificantChange returns false if the size difference between the two
+// versions of the file does not exceed the configured thresholds.
+func isSignificantChange(localFile, remoteFile *lib.FileInfo) bool {
+	delta := localFile.Size() - remoteFile.Size()
+	if delta < 0 {
+		delta = -delta
+	}
+
+	if diffMinChangeBytes > 0 && delta <= diffMinChangeBytes {
+		return false
+	}
+	if diffMinChangePercent > 0 {
+		base := localFile.Size()
+		if remoteFile.Size() > base {
+			base = remoteFile.Size()
+		}
+		if base > 0 && float64(delta)*100/float64(base) <= diffMinChangePercent {
+			return false
+		}
+	}
+	return true
+}
+
+// categories of diff results, in the order they are shown when grouped
+const (
+	diffGroupConflict = iota
+	diffGroupDiverged
+	diffGroupLocalChanged
+	diffGroupRemoteChanged
+	diffGroupLocalDeleted
+	diffGroupRemoteDeleted
+	diffGroupBothDeleted
+	diffGroupReAdded
+	diffGroupMoved
+	diffGroupMetadataChange
+	diffGroupLocalOnly
+	diffGroupRemoteOnly
+	diffGroupUnchanged
+)
+
+// result types in the order they are shown by --summary
+var diffSummaryTypes = []string{
+	"conflict-path", "conflict-hash", "conflict-case", "diverged", "local-changed", "remote-changed",
+	"local-deleted", "remote-deleted", "both-deleted", "re-added", "moved",
+	"metadata-changed", "local-only", "local-old", "remote-only", "remote-old",
+	"unchanged",
+}
+
+// diffOutOfSyncExitCode is the exit code of diff --summary if the repos are
+// out of sync; distinct from 1, which log.Fatalf uses for errors
+const diffOutOfSyncExitCode = 2
+
+// result types which do not make the repos out of sync; deleted files are
+// in sync as long as neither repo has a live version of them
+var diffInSyncTypes = map[string]bool{
+	"unchanged":    true,
+	"both-deleted": true,
+	"local-old":    true,
+	"remote-old":   true,
+}
+
+type diffLine struct {
+	group int
+	path  string
+	text  string
+}
+
+// diffResult is a single diff result in the JSON output. Type is named after
+// the DiffAction method that reported it.
+type diffResult struct {
+	Type   string   `json:"type"`
+	Local  []string `json:"local,omitempty"`
+	Remote []string `json:"remote,omitempty"`
+	Moved  bool     `json:"moved,omitempty"`
+}
+
 type diffAction struct {
+	// when grouped, lines are collected and printed by flush, otherwise they
+	// are printed immediately
+	grouped bool
+	lines   []diffLine
+
+	// when json is set, results are collected instead and printed by flush
+	// as a single JSON array
+	json    bool
+	results []*diffResult
+
+	// when summary is set, results are only counted by type and the counts
+	// are printed by flush
+	summary bool
+	counts  map[string]int
+
+	// only results with a file whose time is within the window are shown;
+	// zero time leaves that side of the window open
+	since time.Time
+	until time.Time
+}
+
+// inWindow returns true if any of the files has time within the window. For
+// deleted files, the time they were deleted is used.
+func (a *diffAction) inWindow(files ...*lib.FileInfo) bool {
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		t := file.Time()
+		if file.IsDeleted() {
+			t = file.DeletedTime()
+		}
+		if (a.since.IsZero() || !t.Before(a.since)) && (a.until.IsZero() || !t.After(a.until)) {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the result for JSON output or the summary. Returns false if
+// producing neither, in which case the result should be printed instead.
+func (a *diffAction) add(kind string, localFiles, remoteFiles []*lib.FileInfo, moved bool) bool {
+	if a.summary {
+		if a.counts == nil {
+			a.counts = map[string]int{}
+		}
+		a.counts[kind]++
+		return true
+	}
+	if !a.json {
+		return false
+	}
+	result := &diffResult{
+		Type:  kind,
+		Moved: moved,
+	}
+	for _, file := range localFiles {
+		result.Local = append(result.Local, file.Path())
+	}
+	for _, file := range remoteFiles {
+		result.Remote = append(result.Remote, file.Path())
+	}
+	a.results = append(a.results, result)
+	return true
+}
+
+func (a *diffAction) print(group int, path string, format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if a.grouped {
+		a.lines = append(a.lines, diffLine{
+			group: group,
+			path:  path,
+			text:  text,
+		})
+	} else {
+		fmt.Print(text)
+	}
+}
+
+// inSync returns true if no counted result makes the repos out of sync.
+func (a *diffAction) inSync() bool {
+	for kind, count := range a.counts {
+		if count > 0 && !diffInSyncTypes[kind] {
+			return false
+		}
+	}
+	return true
+}
+
+// flush prints all collected lines grouped by category and sorted by path,
+// all collected results as JSON, or the summary.
+func (a *diffAction) flush() error {
+	if a.summary {
+		for _, kind := range diffSummaryTypes {
+			if a.counts[kind] > 0 {
+				fmt.Printf("%s: %d\n", kind, a.counts[kind])
+			}
+		}
+		if a.inSync() {
+			fmt.Println("in sync")
+		} else {
+			fmt.Println("out of sync")
+		}
+		return nil
+	}
+	if a.json {
+		results := a.results
+		if results == nil {
+			results = []*diffResult{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	sort.SliceStable(a.lines, func(i, j int) bool {
+		if a.lines[i].group != a.lines[j].group {
+			return a.lines[i].group < a.lines[j].group
+		}
+		return a.lines[i].path < a.lines[j].path
+	})
+
+	for i, line := range a.lines {
+		if i > 0 && line.group != a.lines[i-1].group {
+			fmt.Println()
+		}
+		fmt.Print(line.text)
+	}
+	a.lines = nil
+	return nil
 }
 
 func (a *diffAction) Unchanged(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideUnchanged {
-		fmt.Printf("==:%s\n", localFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideUnchanged && !a.add("unchanged", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupUnchanged, localFile.Path(), "==:%s\n", displayPath(localFile.Path()))
 	}
 }
 
 func (a *diffAction) MetaDataChanged(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideMetadataChange {
-		fmt.Printf("MD:%s\n", localFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideMetadataChange && !a.add("metadata-changed", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupMetadataChange, localFile.Path(), "MD:%s\n", displayPath(localFile.Path()))
 	}
 }
 
 func (a *diffAction) Moved(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideMoved {
-		fmt.Printf("=>:%s => %s\n", localFile.Path(), remoteFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideMoved && !a.add("moved", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupMoved, localFile.Path(), "=>:%s => %s\n", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
 	}
 }
 
 func (a *diffAction) LocalOnly(localFile *lib.FileInfo) {
-	if !diffHideLocalOnly {
-		fmt.Printf("L+:%s\n", localFile.Path())
+	if !a.inWindow(localFile) {
+		return
+	}
+	if !diffHideLocalOnly && !a.add("local-only", []*lib.FileInfo{localFile}, nil, false) {
+		a.print(diffGroupLocalOnly, localFile.Path(), "L+:%s\n", displayPath(localFile.Path()))
 	}
 }
 
 func (a *diffAction) LocalOld(localFile *lib.FileInfo) {
+	if !a.inWindow(localFile) {
+		return
+	}
+	if !diffHideLocalOld {
+		a.add("local-old", []*lib.FileInfo{localFile}, nil, false)
+	}
 	// if !diffHideLocalOld {
 	// 	fmt.Printf("L+:%s\n", localFile.Path())
 	// }
 }
 
 func (a *diffAction) RemoteOnly(remoteFile *lib.FileInfo) {
-	if !diffHideRemoteOnly {
-		fmt.Printf("R+:%s\n", remoteFile.Path())
+	if !a.inWindow(remoteFile) {
+		return
+	}
+	if !diffHideRemoteOnly && !a.add("remote-only", nil, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupRemoteOnly, remoteFile.Path(), "R+:%s\n", displayPath(remoteFile.Path()))
 	}
 }
 
 func (a *diffAction) RemoteOld(remoteFile *lib.FileInfo) {
+	if !a.inWindow(remoteFile) {
+		return
+	}
+	if !diffHideRemoteOld {
+		a.add("remote-old", nil, []*lib.FileInfo{remoteFile}, false)
+	}
 	// if !diffHideRemoteOld {
 	// 	fmt.Printf("R+:%s\n", remoteFile.Path())
 	// }
 }
 
 func (a *diffAction) LocalDeleted(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideLocalDeleted {
-		fmt.Printf("L-:%s\n", localFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideLocalDeleted && !a.add("local-deleted", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupLocalDeleted, localFile.Path(), "L-:%s\n", displayPath(localFile.Path()))
 	}
 }
 
 func (a *diffAction) RemoteDeleted(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideRemoteDeleted {
-		fmt.Printf("R-:%s\n", remoteFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideRemoteDeleted && !a.add("remote-deleted", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupRemoteDeleted, remoteFile.Path(), "R-:%s\n", displayPath(remoteFile.Path()))
 	}
 }
 
-func (a *diffAction) LocalChanged(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideLocalChanged {
-		fmt.Printf(">>:%s\n", localFile.Path())
+func (a *diffAction) BothDeleted(localFile, remoteFile *lib.FileInfo) {
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideBothDeleted && !a.add("both-deleted", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupBothDeleted, localFile.Path(), "--:%s (local: %s, remote: %s)\n", displayPath(localFile.Path()),
+			localFile.DeletedTime().Format(time.RFC3339), remoteFile.DeletedTime().Format(time.RFC3339))
+	}
+}
+
+func (a *diffAction) ReAdded(localFile, remoteFile *lib.FileInfo) {
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideReAdded && !a.add("re-added", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupReAdded, remoteFile.Path(), "R*:%s\n", displayPath(remoteFile.Path()))
+	}
+}
+
+func (a *diffAction) LocalChanged(localFile, remoteFile *lib.FileInfo, moved bool) {
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideLocalChanged && isSignificantChange(localFile, remoteFile) &&
+		!a.add("local-changed", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, moved) {
+		if moved {
+			a.print(diffGroupLocalChanged, localFile.Path(), ">>:%s => %s\n", displayPath(remoteFile.Path()), displayPath(localFile.Path()))
+		} else {
+			a.print(diffGroupLocalChanged, localFile.Path(), ">>:%s\n", displayPath(localFile.Path()))
+		}
+	}
+}
+
+func (a *diffAction) RemoteChanged(localFile, remoteFile *lib.FileInfo, moved bool) {
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideRemoteChanged && isSignificantChange(localFile, remoteFile) &&
+		!a.add("remote-changed", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, moved) {
+		if moved {
+			a.print(diffGroupRemoteChanged, remoteFile.Path(), "<<:%s => %s\n", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
+		} else {
+			a.print(diffGroupRemoteChanged, remoteFile.Path(), "<<:%s\n", displayPath(remoteFile.Path()))
+		}
 	}
 }
 
-func (a *diffAction) RemoteChanged(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideRemoteChanged {
-		fmt.Printf("<<:%s\n", remoteFile.Path())
+func (a *diffAction) DivergedFromAncestor(localFile, remoteFile *lib.FileInfo) {
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideDiverged && !a.add("diverged", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupDiverged, localFile.Path(), "<>:%s ! %s\n", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
 	}
 }
 
 func (a *diffAction) ConflictPath(localFile, remoteFile *lib.FileInfo) {
-	if !diffHideConflict {
-		fmt.Printf("!!:%s ! %s\n", localFile.Path(), remoteFile.Path())
+	if !a.inWindow(localFile, remoteFile) {
+		return
+	}
+	if !diffHideConflict && !a.add("conflict-path", []*lib.FileInfo{localFile}, []*lib.FileInfo{remoteFile}, false) {
+		a.print(diffGroupConflict, localFile.Path(), "!!:%s ! %s\n", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
 	}
 }
 
 func (a *diffAction) ConflictHash(localFiles, remoteFiles []*lib.FileInfo) {
-	// if len(localFiles) == 1 && len(remoteFiles) == 1 {
-	// 	localFile := localFiles[0]
-	// 	remoteFile := remoteFiles[0]
-	// 	fmt.Printf("=>:%s => %s\n", localFile.Path(), remoteFile.Path())
-	// 	localFile.History = append(localFile.History, &lib.FileEvent{
-	// 		Path:     remoteFile.Path(),
-	// 		Time:     remoteFile.Time(),
-	// 		Size:     remoteFile.Size(),
-	// 		Checksum: remoteFile.Checksum(),
-	// 	})
-	// 	return
-	// }
-	//
+	a.conflict("conflict-hash", localFiles, remoteFiles)
+}
+
+func (a *diffAction) ConflictCase(localFiles, remoteFiles []*lib.FileInfo) {
+	a.conflict("conflict-case", localFiles, remoteFiles)
+}
+
+func (a *diffAction) conflict(kind string, localFiles, remoteFiles []*lib.FileInfo) {
+	if !a.inWindow(append(append([]*lib.FileInfo{}, localFiles...), remoteFiles...)...) {
+		return
+	}
+	if a.add(kind, localFiles, remoteFiles, false) {
+		return
+	}
+
+	// all files of the conflict are printed together, so that they stay
+	// together when grouped
+	text := ""
+	path := ""
 	for _, file := range localFiles {
-		fmt.Printf("!!:%s\n", file.Path())
+		text += fmt.Sprintf("!!:%s\n", displayPath(file.Path()))
+		if path == "" {
+			path = file.Path()
+		}
 	}
 	for _, file := range remoteFiles {
-		fmt.Printf("!!:%s\n", file.Path())
+		text += fmt.Sprintf("!!:%s\n", displayPath(file.Path()))
+		if path == "" {
+			path = file.Path()
+		}
 	}
+	a.print(diffGroupConflict, path, "%s", text)
 }
 
 // diffCmd represents the diff command
 var diffCmd = &cobra.Command{
-	Use:   "diff <remote-repo>",
+	Use:   "diff [<local-repo>] <remote-repo>",
 	Short: "Show differences between local and remote repo.",
 	Long: `Diff will use meta-data from the repository and compare their contents.
 	It will show added, removed and changed files. If the file by the same name
 	exists in both repositories, but they do not share the same history, a
-	conflict will be reported.`,
-	Args: cobra.ExactArgs(1),
+	conflict will be reported.
+
+	The local repository is the one in the current directory, unless two
+	repositories are given, in which case the first one is treated as local.
+	Paths containing new lines or other special characters are shown quoted,
+	same as Go string literals.
+
+	With --summary, only the number of results of each type is shown, followed
+	by 'in sync' or 'out of sync'. Exit code is then 2 if the repositories are
+	out of sync, i.e. if there are any results other than unchanged files,
+	files deleted in both repositories, or deleted files known to only one of
+	them. A file deleted in one repository, but not in the other, makes them
+	out of sync. Errors exit with code 1. Hidden results are not counted.`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseTimeBound(diffSince)
+		if err != nil {
+			log.Fatalf("ERROR: invalid --since '%s': %v\n", diffSince, err)
+		}
+		until, err := parseTimeBound(diffUntil)
+		if err != nil {
+			log.Fatalf("ERROR: invalid --until '%s': %v\n", diffUntil, err)
+		}
+
+		if len(args) == 2 {
+			if dbDir != "" {
+				log.Fatalf("ERROR: --db-dir can not be used when two repositories are given\n")
+			}
+			dbDir, err = lib.FindBoffinDirWithName(args[0], dbName)
+			if err != nil {
+				log.Fatalf("ERROR: local repository '%s': %v\n", args[0], err)
+			}
+			args = args[1:]
+		}
 		if dbDir == "" {
-			var err error
-			dbDir, err = lib.FindBoffinDir(dbDir)
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
 			if err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
@@ -161,18 +530,40 @@ var diffCmd = &cobra.Command{
 			log.Fatalf("ERROR: %v\n", err)
 		}
 
-		dbDir, err = lib.FindBoffinDir(args[0])
+		dbDir, err = lib.FindBoffinDirWithName(args[0], dbName)
 		if err != nil {
-			log.Fatalf("ERROR: %v\n", err)
+			log.Fatalf("ERROR: remote repository '%s': %v\n", args[0], err)
 		}
 		remote, err := lib.LoadBoffin(dbDir)
 		if err != nil {
 			log.Fatalf("ERROR: %v\n", err)
 		}
 
-		if err = lib.Diff(local, remote, &diffAction{}); err != nil {
+		opts := &lib.DiffOptions{
+			LocalPrefix:     diffStripLocalPrefix,
+			RemotePrefix:    diffStripRemotePrefix,
+			CaseInsensitive: diffIgnoreCase,
+		}
+		if diffSummary && diffJSON {
+			log.Fatalf("ERROR: --summary can not be used with --json\n")
+		}
+
+		action := &diffAction{
+			grouped: diffGrouped,
+			json:    diffJSON,
+			summary: diffSummary,
+			since:   since,
+			until:   until,
+		}
+		if err = local.DiffAgainstWithOptions(remote, action, opts); err != nil {
 			log.Fatalf("ERROR: %v\n", err)
 		}
+		if err = action.flush(); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if diffSummary && !action.inSync() {
+			os.Exit(diffOutOfSyncExitCode)
+		}
 	},
 }
 
@@ -198,7 +589,20 @@ func init() {
 	diffCmd.Flags().BoolVar(&diffHideRemoteOld, "hide-remote-old", false, "hide files whole remote version is old")
 	diffCmd.Flags().BoolVar(&diffHideLocalDeleted, "hide-local-deleted", false, "hide files that were locally deleted, but still exist in remote repo")
 	diffCmd.Flags().BoolVar(&diffHideRemoteDeleted, "hide-remote-deleted", false, "hide files that were remotely deleted, but still exist in local repo")
+	diffCmd.Flags().BoolVar(&diffHideBothDeleted, "hide-both-deleted", false, "hide files that were deleted in both local and remote repo")
+	diffCmd.Flags().BoolVar(&diffHideReAdded, "hide-re-added", false, "hide files that were locally deleted, but added again in remote repo afterwards")
 	diffCmd.Flags().BoolVar(&diffHideLocalChanged, "hide-local-changed", false, "hide changed files which local version is newest")
 	diffCmd.Flags().BoolVar(&diffHideRemoteChanged, "hide-remote-changed", false, "hide changed files which remote version is newest")
+	diffCmd.Flags().BoolVar(&diffHideDiverged, "hide-diverged", false, "hide files which changed in both local and remote repo since their last common version")
 	diffCmd.Flags().BoolVar(&diffHideConflict, "hide-conflict", false, "hide files which have conflicting changes in both local and remote repo")
+	diffCmd.Flags().Int64Var(&diffMinChangeBytes, "min-change-bytes", 0, "hide changed files whose size changed by this many bytes or less")
+	diffCmd.Flags().Float64Var(&diffMinChangePercent, "min-change-percent", 0, "hide changed files whose size changed by this percentage or less")
+	diffCmd.Flags().StringVar(&diffSince, "since", "", "show only results with a file changed at or after this time; age (e.g. '7d' or '36h'), date (e.g. '2024-06-01') or RFC3339 time")
+	diffCmd.Flags().StringVar(&diffUntil, "until", "", "show only results with a file changed at or before this time; age, date or RFC3339 time")
+	diffCmd.Flags().BoolVar(&diffGrouped, "grouped", false, "show results grouped by category and sorted by path")
+	diffCmd.Flags().BoolVar(&diffSummary, "summary", false, "show only the number of results of each type and whether the repos are in sync; exit code is 2 if they are not")
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "print results as a JSON array of objects with type, local and remote paths")
+	diffCmd.Flags().StringVar(&diffStripLocalPrefix, "strip-local-prefix", "", "ignore this directory prefix of local paths when matching files by path")
+	diffCmd.Flags().StringVar(&diffStripRemotePrefix, "strip-remote-prefix", "", "ignore this directory prefix of remote paths when matching files by path")
+	diffCmd.Flags().BoolVar(&diffIgnoreCase, "ignore-case", false, "ignore case when matching files by path; files whose paths differ only in case within one repo are reported as conflicts")
 }
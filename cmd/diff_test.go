@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+func TestDiffInWindow(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	deleted := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	live := &lib.FileInfo{History: []*lib.FileEvent{
+		&lib.FileEvent{Path: "live.ext", Size: 10, Time: created, Checksum: "hash-1"},
+	}}
+	removed := &lib.FileInfo{History: []*lib.FileEvent{
+		&lib.FileEvent{Path: "deleted.ext", Size: 10, Time: created, Checksum: "hash-2"},
+		&lib.FileEvent{Path: "deleted.ext", Time: deleted},
+	}}
+
+	tests := []struct {
+		name     string
+		file     *lib.FileInfo
+		since    time.Time
+		until    time.Time
+		expected bool
+	}{
+		{"live in window", live, created, time.Time{}, true},
+		{"live before window", live, deleted, time.Time{}, false},
+		{"deleted in window", removed, deleted, time.Time{}, true},
+		{"deleted after window", removed, time.Time{}, created, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &diffAction{since: test.since, until: test.until}
+			if actual := a.inWindow(test.file); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
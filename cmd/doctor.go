@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var doctorFix bool
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:     "doctor",
+	Aliases: []string{"fsck"},
+	Short:   "Check repository for consistency problems.",
+	Long: `Doctor validates the repository meta-data, e.g. that file histories
+	are well formed and that no two files share the same path, and looks for
+	temporary files left behind by interrupted operations. All problems found
+	are printed, and exit code is non-zero if there were any.
+
+	With --fix, histories are repaired where that is possible without losing
+	any recorded content: events missing a path get it from the events around
+	them, and deletions that do not follow any content are dropped, together
+	with files which are left with no content at all. Remaining problems are
+	printed as without --fix.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		if doctorFix {
+			fixes := lib.RepairRepo(local)
+			for _, fix := range fixes {
+				fmt.Println(fix)
+			}
+			if len(fixes) > 0 && !dryRun {
+				if err = local.Save(); err != nil {
+					log.Fatalf("ERROR: %v\n", err)
+				}
+			}
+		}
+
+		issues := lib.CheckRepo(local)
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "repair file histories where no recorded content would be lost")
+}
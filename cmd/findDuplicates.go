@@ -21,13 +21,17 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"sort"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
 )
 
 var deleteDuplicates bool
+var duplicatesRemote string
+var duplicatesIncludeHistory bool
+var duplicatesKeep string
+var hardlinkDuplicates bool
 
 // findDuplicatesCmd represents the findDuplicates command
 var findDuplicatesCmd = &cobra.Command{
@@ -37,7 +41,7 @@ var findDuplicatesCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		if dbDir == "" {
 			var err error
-			dbDir, err = lib.FindBoffinDir(dbDir)
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
 			if err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
@@ -47,30 +51,180 @@ var findDuplicatesCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("ERROR: %v", err)
 		}
+		if deleteDuplicates && local.IsAppendOnly() {
+			log.Fatalf("ERROR: repository is append-only; --delete is not allowed")
+		}
+		if deleteDuplicates && hardlinkDuplicates {
+			log.Fatalf("ERROR: --delete can not be used with --hardlink")
+		}
+		keepPolicy, err := lib.ParseKeepPolicy(duplicatesKeep)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
 
-		for hash, files := range lib.FilesToHashMap(local.GetFiles()) {
-			if len(files) > 1 {
-				fmt.Printf("%s:\n", hash)
-				keep := true
-				for _, file := range files {
-					if deleteDuplicates && !keep {
-						fmt.Printf(" -%s\n", file.Path())
-						if !dryRun {
-							path := filepath.Join(local.GetBaseDir(), file.Path())
-							if err := os.Remove(path); err != nil {
-								log.Printf("%v", err)
-							}
+		if duplicatesRemote != "" {
+			if deleteDuplicates || hardlinkDuplicates {
+				log.Fatalf("ERROR: --delete and --hardlink can not be used with --remote")
+			}
+			if duplicatesIncludeHistory {
+				log.Fatalf("ERROR: --include-history can not be used with --remote")
+			}
+
+			remoteDbDir, err := lib.FindBoffinDirWithName(duplicatesRemote, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+			remote, err := lib.LoadBoffin(remoteDbDir)
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+
+			findRemoteDuplicates(local, remote)
+			return
+		}
+
+		byHash := lib.FilesToHashMap(local.GetFiles())
+		hashes := make([]string, 0, len(byHash))
+		for hash, files := range byHash {
+			// contents of metadata-only files are unknown, so they must not
+			// be deleted or hardlinked as duplicates
+			if len(files) > 1 && files[0].HashAlgorithm() != lib.HashMetadataOnly {
+				hashes = append(hashes, hash)
+			}
+		}
+		sort.Strings(hashes)
+
+		deleted := 0
+		for _, hash := range hashes {
+			keep, others := lib.SelectDuplicates(byHash[hash], keepPolicy)
+			fmt.Printf("%s:\n", displayHash(hash))
+			fmt.Printf("  %s\n", displayPath(keep.Path()))
+			for _, file := range others {
+				if hardlinkDuplicates {
+					fmt.Printf(" =%s\n", displayPath(file.Path()))
+					if !dryRun {
+						keepPath := local.ResolvePath(keep.Path())
+						path := local.ResolvePath(file.Path())
+						if err := hardlinkFile(keepPath, path, file); err != nil {
+							log.Printf("warning: leaving '%s' as is: %v", file.Path(), err)
 						}
-					} else {
-						fmt.Printf("  %s\n", file.Path())
-						keep = false
 					}
+					continue
+				}
+				if !deleteDuplicates {
+					fmt.Printf("  %s\n", displayPath(file.Path()))
+					continue
+				}
+				fmt.Printf(" -%s\n", displayPath(file.Path()))
+				if !dryRun {
+					path := local.ResolvePath(file.Path())
+					if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+						log.Printf("%v", err)
+						continue
+					}
+					file.MarkDeleted()
+					deleted++
 				}
 			}
 		}
+		if deleted > 0 {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+		}
+
+		if duplicatesIncludeHistory {
+			findHistoricDuplicates(local)
+		}
 	},
 }
 
+// hardlinkFile replaces path with a hard link to target. Right before path is
+// replaced, both the link and path are checked to have the content recorded
+// for file, so that no changes made since the last update are lost. Path is
+// left untouched on any failure.
+func hardlinkFile(target, path string, file *lib.FileInfo) error {
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(targetInfo, info) {
+		return nil
+	}
+
+	tmp := path + ".boffin-link"
+	if err = os.Link(target, tmp); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp) // cleanup; will work only if path could not be replaced
+	}()
+
+	checksum, err := lib.CalculateChecksumWith(tmp, file.HashAlgorithm())
+	if err != nil {
+		return err
+	}
+	if checksum != file.Checksum() {
+		return fmt.Errorf("'%s' does not match the recorded checksum", target)
+	}
+	checksum, err = lib.CalculateChecksumWith(path, file.HashAlgorithm())
+	if err != nil {
+		return err
+	}
+	if checksum != file.Checksum() {
+		return fmt.Errorf("'%s' changed since the last update", path)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// findHistoricDuplicates prints live files whose content matches a past
+// version of other files. Matching files are marked with H.
+func findHistoricDuplicates(local lib.Boffin) {
+	duplicates := lib.FindHistoricDuplicates(local.GetFiles())
+	if len(duplicates) == 0 {
+		return
+	}
+
+	fmt.Printf("matching past versions:\n")
+	for _, duplicate := range duplicates {
+		fmt.Printf("%s:\n", displayHash(duplicate.File.Checksum()))
+		fmt.Printf("  %s\n", displayPath(duplicate.File.Path()))
+		for _, file := range duplicate.Matches {
+			fmt.Printf(" H%s\n", displayPath(file.Path()))
+		}
+	}
+}
+
+// findRemoteDuplicates prints all content that exists in both local and remote
+// repo, grouped by checksum.
+func findRemoteDuplicates(local, remote lib.Boffin) {
+	localByHash := lib.FilesToHashMap(local.GetFiles())
+	remoteByHash := lib.FilesToHashMap(remote.GetFiles())
+
+	hashes := make([]string, 0, len(localByHash))
+	for hash := range localByHash {
+		if _, ok := remoteByHash[hash]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	for _, hash := range hashes {
+		fmt.Printf("%s:\n", displayHash(hash))
+		for _, file := range localByHash[hash] {
+			fmt.Printf(" L%s\n", displayPath(file.Path()))
+		}
+		for _, file := range remoteByHash[hash] {
+			fmt.Printf(" R%s\n", displayPath(file.Path()))
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(findDuplicatesCmd)
 
@@ -79,6 +233,10 @@ func init() {
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	findDuplicatesCmd.PersistentFlags().BoolVar(&deleteDuplicates, "delete", false, "delete all but one of the duplicates")
+	findDuplicatesCmd.PersistentFlags().StringVar(&duplicatesRemote, "remote", "", "instead of local duplicates, show content that also exists in the remote repo")
+	findDuplicatesCmd.PersistentFlags().BoolVar(&hardlinkDuplicates, "hardlink", false, "replace all but one of the duplicates with hard links to it")
+	findDuplicatesCmd.PersistentFlags().StringVar(&duplicatesKeep, "keep", string(lib.KeepFirstAlphabetical), "which duplicate to keep, one of oldest, newest, shortest-path or first-alphabetical")
+	findDuplicatesCmd.PersistentFlags().BoolVar(&duplicatesIncludeHistory, "include-history", false, "also show files whose content matches a past version of other files; these are never deleted")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
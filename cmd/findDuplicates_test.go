@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHardlinkFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		duplicate string
+		linked    bool
+	}{
+		{"duplicate", "same", "same", true},
+		{"target changed", "changed", "same", false},
+		{"duplicate changed", "same", "changed", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepo(t, map[string]string{"a.ext": "same", "b.ext": "same"})
+			target, path := repo.ResolvePath("a.ext"), repo.ResolvePath("b.ext")
+			writeTestFile(t, target, test.target)
+			writeTestFile(t, path, test.duplicate)
+
+			err := hardlinkFile(target, path, repo.GetFileByPath("b.ext"))
+			if test.linked && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !test.linked && err == nil {
+				t.Fatalf("expected error")
+			}
+
+			targetInfo, err := os.Stat(target)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if linked := os.SameFile(targetInfo, info); linked != test.linked {
+				t.Errorf("expected linked to be %v, got %v", test.linked, linked)
+			}
+			if actual := readTestFile(t, path); !test.linked && actual != test.duplicate {
+				t.Errorf("duplicate changed to '%s'", actual)
+			}
+			if _, err := os.Lstat(path + ".boffin-link"); !os.IsNotExist(err) {
+				t.Errorf("temporary link left behind")
+			}
+		})
+	}
+}
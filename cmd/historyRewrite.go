@@ -0,0 +1,69 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// historyRewriteCmd represents the history-rewrite command
+var historyRewriteCmd = &cobra.Command{
+	Use:   "history-rewrite",
+	Short: "Collapse events that only changed metadata.",
+	Long: `History-rewrite collapses consecutive events of a file which have the
+	same content and path, e.g. left behind by a tool repeatedly touching the
+	files, into one event with the time the content first appeared. Content
+	changes, renames and deletions are kept, and the current version of every
+	file stays as it was. The repository file is rewritten as a whole, same as
+	with 'compact'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if local.IsAppendOnly() {
+			log.Fatalf("ERROR: repository is append-only; history can not be rewritten\n")
+		}
+
+		removed := lib.CollapseHistory(local)
+		if !dryRun && removed > 0 {
+			if err = local.Compact(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+		fmt.Printf("removed %d events\n", removed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyRewriteCmd)
+}
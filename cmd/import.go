@@ -18,11 +18,14 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
@@ -30,6 +33,30 @@ import (
 
 var doMove bool
 var doDelete bool
+var importStripLocalPrefix string
+var importStripRemotePrefix string
+var importMessage string
+var importMergeHistory bool
+var importThenUpdate bool
+var importSubdir string
+var importOnConflict string
+var importIgnoreCase bool
+var importPlanOnly bool
+var importJSON bool
+var importQuarantine bool
+var importNewerOnly bool
+
+// quarantineSubdir is the subdirectory of the import directory where remote
+// versions of conflicting files are copied with --quarantine.
+const quarantineSubdir = "_conflicts"
+
+// strategies for resolving conflicts during import
+const (
+	conflictSkip       = "skip"
+	conflictKeepLocal  = "keep-local"
+	conflictKeepRemote = "keep-remote"
+	conflictKeepBoth   = "keep-both"
+)
 
 // importCmd represents the import command
 var importCmd = &cobra.Command{
@@ -38,12 +65,17 @@ var importCmd = &cobra.Command{
 	Long: `Import will use meta-data from the local and remote repository similarly
 	to 'diff' and compare their contents. Any files that have been added or
 	modified in the remote repository will be imported into local repository.
-	Options can be used to control which changes will be imported.`,
+	Options can be used to control which changes will be imported. All files
+	are copied first and only put in place once every copy succeeded; if any
+	step fails, the local repository and its files are left unchanged.
+
+	The remote repository can also be given as ssh://[user@]host/base-dir, in
+	which case its meta-data and files are read using the ssh command.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if dbDir == "" {
 			var err error
-			dbDir, err = lib.FindBoffinDir(dbDir)
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
 			if err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
@@ -53,40 +85,140 @@ var importCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("ERROR: %v\n", err)
 		}
-
-		dbDir, err = lib.FindBoffinDir(args[0])
-		if err != nil {
-			log.Fatalf("ERROR: %v\n", err)
+		if doDelete && local.IsAppendOnly() {
+			log.Fatalf("ERROR: repository is append-only; --delete is not allowed\n")
 		}
-		remote, err := lib.LoadBoffin(dbDir)
-		if err != nil {
-			log.Fatalf("ERROR: %v\n", err)
+		switch importOnConflict {
+		case conflictSkip, conflictKeepLocal, conflictKeepRemote, conflictKeepBoth:
+		default:
+			log.Fatalf("ERROR: invalid --on-conflict '%s'; must be one of skip, keep-local, keep-remote or keep-both\n", importOnConflict)
+		}
+		if importQuarantine && importOnConflict != conflictSkip {
+			log.Fatalf("ERROR: --quarantine can not be used together with --on-conflict\n")
+		}
+		if importSubdir != "" {
+			importDir := filepath.Join(local.GetImportDir(), importSubdir)
+			if rel, err := filepath.Rel(local.GetBaseDir(), importDir); err != nil || isOutside(rel) {
+				log.Fatalf("ERROR: import directory '%s' is outside of the repository\n", importDir)
+			}
 		}
 
 		action := &importAction{
 			local:  local,
-			remote: remote,
+			note:   importMessage,
+			logger: lib.DefaultLogger,
 		}
+		action.tx.logger = lib.DefaultLogger
+		if importPlanOnly || importJSON {
+			// keep standard output for the plan or the summary
+			action.logger = lib.NewLogger(os.Stderr)
+			action.tx.logger = lib.NewLogger(nil)
+		}
+		if err = action.loadRemote(args[0]); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		remote := action.remote
 
-		if err = lib.Diff(local, remote, action); err != nil {
+		opts := &lib.DiffOptions{
+			LocalPrefix:     importStripLocalPrefix,
+			RemotePrefix:    importStripRemotePrefix,
+			CaseInsensitive: importIgnoreCase,
+		}
+		var diffAction lib.DiffAction = action
+		if importNewerOnly {
+			diffAction = lib.NewerOnly(action)
+		}
+		if err = local.DiffAgainstWithOptions(remote, diffAction, opts); err != nil {
 			log.Fatalf("ERROR: %v\n", err)
 		}
+		if importPlanOnly {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err = encoder.Encode(action.tx.plan()); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+			return
+		}
+		if !dryRun {
+			err = action.tx.apply()
+			printImportSummary(action.tx.summary())
+			if err != nil {
+				log.Fatalf("ERROR: import failed, no changes were made: %v\n", err)
+			}
+		}
+
+		var unreadable *lib.UnreadableError
+		if importThenUpdate {
+			if dryRun {
+				action.logger.Infof("skipping update in dry run, as no files were imported")
+			} else if err = lib.Update(local, lib.CheckIfMetaChanged); err != nil && !errors.As(err, &unreadable) {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
 		if !dryRun {
 			if err = local.Save(); err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
 		}
-
-		if action.exit != 0 {
-			os.Exit(action.exit)
+		if unreadable != nil {
+			log.Fatalf("ERROR: %v\n", unreadable)
 		}
 	},
 }
 
 type importAction struct {
-	exit   int
-	local  lib.Boffin
-	remote lib.Boffin
+	local     lib.Boffin
+	remote    lib.Boffin
+	remoteURL string // set if the remote repo is not on this machine
+	fetcher   lib.Fetcher
+	note      string
+	tx        importTransaction
+	logger    lib.Logger // reports conflicts
+}
+
+// loadRemote loads the remote repo, given either as a path or as an ssh://
+// URL, and prepares fetching its files.
+func (a *importAction) loadRemote(remote string) error {
+	if lib.IsSSHURL(remote) {
+		// repo files are needed only while loading
+		tmpDir, err := os.MkdirTemp("", "boffin-remote-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.RemoveAll(tmpDir)
+		}()
+		if a.remote, a.fetcher, err = lib.LoadSSHBoffin(remote, dbName, tmpDir); err != nil {
+			return err
+		}
+		a.remoteURL = remote
+		return nil
+	}
+
+	remoteDbDir, err := lib.FindBoffinDirWithName(remote, dbName)
+	if err != nil {
+		return err
+	}
+	if a.remote, err = lib.LoadBoffin(remoteDbDir); err != nil {
+		return err
+	}
+	a.fetcher = lib.NewRepoFetcher(a.remote)
+	return nil
+}
+
+// remoteSrc returns the path of the remote file as shown to the user.
+func (a *importAction) remoteSrc(remoteFile *lib.FileInfo) string {
+	if a.remoteURL != "" {
+		return strings.TrimSuffix(a.remoteURL, "/") + "/" + filepath.ToSlash(remoteFile.Path())
+	}
+	return a.remote.ResolvePath(remoteFile.Path())
+}
+
+// fetch returns function opening the remote file.
+func (a *importAction) fetch(remoteFile *lib.FileInfo) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return a.fetcher.Open(remoteFile.Path())
+	}
 }
 
 func (a *importAction) Unchanged(localFile, remoteFile *lib.FileInfo) {
@@ -99,21 +231,23 @@ func (a *importAction) MetaDataChanged(localFile, remoteFile *lib.FileInfo) {
 
 func (a *importAction) Moved(localFile, remoteFile *lib.FileInfo) {
 	if doMove {
-		src := filepath.Join(a.local.GetBaseDir(), localFile.Path())
-		dest := filepath.Join(a.local.GetBaseDir(), remoteFile.Path())
-
-		fmt.Printf("mv %s %s\n", src, dest)
-		if err := moveFile(src, dest); err != nil {
-			log.Printf("%v", err)
-			a.exit = 1
-		} else {
-			localFile.History = append(localFile.History, &lib.FileEvent{
-				Path:     remoteFile.Path(),
-				Time:     localFile.Time(),
-				Size:     localFile.Size(),
-				Checksum: localFile.Checksum(),
-			})
-		}
+		a.tx.add(&importOp{
+			kind: opMove,
+			src:  a.local.ResolvePath(localFile.Path()),
+			dest: a.local.ResolvePath(remoteFile.Path()),
+			record: func() {
+				localFile.History = append(localFile.History, &lib.FileEvent{
+					Path:      remoteFile.Path(),
+					Time:      localFile.Time(),
+					Size:      localFile.Size(),
+					Checksum:  localFile.Checksum(),
+					Algorithm: localFile.History[len(localFile.History)-1].Algorithm,
+					Mode:      localFile.Mode(),
+					Note:      a.note,
+					Origin:    a.remote.GetOrigin(),
+				})
+			},
+		})
 	}
 }
 
@@ -128,21 +262,42 @@ func (a *importAction) LocalOld(localFile *lib.FileInfo) {
 func (a *importAction) RemoteOnly(remoteFile *lib.FileInfo) {
 	// fmt.Printf("R+:%s\n", remoteFile.Path())
 
-	src := filepath.Join(a.remote.GetBaseDir(), remoteFile.Path())
-	dest := filepath.Join(a.local.GetImportDir(), remoteFile.Path())
+	dest, localPath := a.importPath(remoteFile)
+	a.tx.add(&importOp{
+		kind:      opAdd,
+		src:       a.remoteSrc(remoteFile),
+		open:      a.fetch(remoteFile),
+		modTime:   remoteFile.Time(),
+		dest:      dest,
+		mode:      remoteFile.Mode(),
+		checksum:  remoteFile.Checksum(),
+		algorithm: remoteFile.HashAlgorithm(),
+		record: func() {
+			remoteFile.History = append(remoteFile.History, &lib.FileEvent{
+				Path:      localPath,
+				Time:      remoteFile.Time(),
+				Size:      remoteFile.Size(),
+				Checksum:  remoteFile.Checksum(),
+				Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+				Mode:      remoteFile.Mode(),
+				Note:      a.note,
+				Origin:    a.remote.GetOrigin(),
+			})
+			a.local.AddFile(remoteFile)
+		},
+	})
+}
 
-	if err := addFile(src, dest); err != nil {
-		log.Printf("%v", err)
-		a.exit = 1
-	} else {
-		remoteFile.History = append(remoteFile.History, &lib.FileEvent{
-			Path:     filepath.Join(a.local.GetRelImportDir(), remoteFile.Path()),
-			Time:     remoteFile.Time(),
-			Size:     remoteFile.Size(),
-			Checksum: remoteFile.Checksum(),
-		})
-		a.local.AddFile(remoteFile)
+// importPath returns where remote file is imported to, as an absolute path
+// and as recorded in the local repo.
+func (a *importAction) importPath(remoteFile *lib.FileInfo) (dest, localPath string) {
+	dest = filepath.Join(a.local.GetImportDir(), importSubdir, remoteFile.Path())
+	// recorded path must be relative to the base dir for update to find it
+	localPath, err := filepath.Rel(a.local.GetBaseDir(), dest)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
 	}
+	return dest, localPath
 }
 
 func (a *importAction) RemoteOld(remoteFile *lib.FileInfo) {
@@ -155,97 +310,290 @@ func (a *importAction) LocalDeleted(localFile, remoteFile *lib.FileInfo) {
 
 func (a *importAction) RemoteDeleted(localFile, remoteFile *lib.FileInfo) {
 	if doDelete {
-		localPath := filepath.Join(a.local.GetBaseDir(), localFile.Path())
-
-		fmt.Printf("rm %s\n", localPath)
-		if !dryRun {
-			if err := os.Remove(localPath); err != nil {
-				log.Printf("%v", err)
-				a.exit = 1
-			} else {
+		a.tx.add(&importOp{
+			kind: opDelete,
+			dest: a.local.ResolvePath(localFile.Path()),
+			record: func() {
 				localFile.MarkDeleted()
-			}
-		}
+				localFile.History[len(localFile.History)-1].Note = a.note
+				localFile.History[len(localFile.History)-1].Origin = a.remote.GetOrigin()
+			},
+		})
 	}
 }
 
-func (a *importAction) LocalChanged(localFile, remoteFile *lib.FileInfo) {
+func (a *importAction) BothDeleted(localFile, remoteFile *lib.FileInfo) {
+	// do nothing
+}
+
+func (a *importAction) ReAdded(localFile, remoteFile *lib.FileInfo) {
+	// the remote file was added again after the local one was deleted, so
+	// the deletion does not apply to it; revive the local file rather than
+	// adding a second one sharing its history
+	dest, localPath := a.importPath(remoteFile)
+	a.tx.add(&importOp{
+		kind:      opAdd,
+		src:       a.remoteSrc(remoteFile),
+		open:      a.fetch(remoteFile),
+		modTime:   remoteFile.Time(),
+		dest:      dest,
+		mode:      remoteFile.Mode(),
+		checksum:  remoteFile.Checksum(),
+		algorithm: remoteFile.HashAlgorithm(),
+		record: func() {
+			localFile.History = append(localFile.History, &lib.FileEvent{
+				Path:      localPath,
+				Time:      remoteFile.Time(),
+				Size:      remoteFile.Size(),
+				Checksum:  remoteFile.Checksum(),
+				Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+				Mode:      remoteFile.Mode(),
+				Note:      a.note,
+				Origin:    a.remote.GetOrigin(),
+			})
+		},
+	})
+}
+
+func (a *importAction) LocalChanged(localFile, remoteFile *lib.FileInfo, moved bool) {
 	// fmt.Printf(">>:%s\n", localFile.Path())
 }
 
-func (a *importAction) RemoteChanged(localFile, remoteFile *lib.FileInfo) {
+func (a *importAction) RemoteChanged(localFile, remoteFile *lib.FileInfo, moved bool) {
 	// fmt.Printf("<<:%s\n", remoteFile.Path())
 
-	src := filepath.Join(a.remote.GetBaseDir(), remoteFile.Path())
-	dest := filepath.Join(a.local.GetBaseDir(), localFile.Path())
+	a.replace(localFile, remoteFile, a.note, importMergeHistory)
+}
 
-	if err := replaceFile(src, dest); err != nil {
-		log.Printf("%v", err)
-		a.exit = 1
-	} else {
-		localFile.History = append(localFile.History, &lib.FileEvent{
-			Path:     localFile.Path(),
-			Time:     remoteFile.Time(),
-			Size:     remoteFile.Size(),
-			Checksum: remoteFile.Checksum(),
-		})
+func (a *importAction) ConflictPath(localFile, remoteFile *lib.FileInfo) {
+	a.logger.Infof("!!:%s ! %s", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
+
+	if importQuarantine {
+		a.quarantine(remoteFile)
+		return
 	}
+	a.resolveConflict(localFile, remoteFile, importOnConflict)
 }
 
-func (a *importAction) ConflictPath(localFile, remoteFile *lib.FileInfo) {
-	// fmt.Printf("!!:%s ! %s\n", localFile.Path(), remoteFile.Path())
+func (a *importAction) DivergedFromAncestor(localFile, remoteFile *lib.FileInfo) {
+	a.logger.Infof("<>:%s ! %s", displayPath(localFile.Path()), displayPath(remoteFile.Path()))
+
+	// both sides changed since the common version, so neither one may replace
+	// the other; the local version is kept whatever the strategy
+	if importQuarantine {
+		a.quarantine(remoteFile)
+		return
+	}
+	a.resolveConflict(localFile, remoteFile, importOnConflict)
+}
+
+func (a *importAction) ConflictCase(localFiles, remoteFiles []*lib.FileInfo) {
+	// files differing only in case can not be told apart by path, and their
+	// contents may differ, so neither side is ever imported or replaced
+	for _, file := range localFiles {
+		a.logger.Warnf("!!:%s: path differs only in case", displayPath(file.Path()))
+	}
+	for _, file := range remoteFiles {
+		a.logger.Warnf("!!:%s: path differs only in case", displayPath(file.Path()))
+	}
 }
 
 func (a *importAction) ConflictHash(localFiles, remoteFiles []*lib.FileInfo) {
-	// if len(localFiles) == 1 && len(remoteFiles) == 1 {
-	// 	localFile := localFiles[0]
-	// 	remoteFile := remoteFiles[0]
-	// 	fmt.Printf("=>:%s => %s\n", localFile.Path(), remoteFile.Path())
-	// 	localFile.History = append(localFile.History, &lib.FileEvent{
-	// 		Path:     remoteFile.Path(),
-	// 		Time:     remoteFile.Time(),
-	// 		Size:     remoteFile.Size(),
-	// 		Checksum: remoteFile.Checksum(),
-	// 	})
-	// 	return
-	// }
-	//
 	for _, file := range localFiles {
-		fmt.Printf("!!:%s\n", file.Path())
+		a.logger.Infof("!!:%s", displayPath(file.Path()))
 	}
 	for _, file := range remoteFiles {
-		fmt.Printf("!!:%s\n", file.Path())
+		a.logger.Infof("!!:%s", displayPath(file.Path()))
+	}
+
+	if importQuarantine {
+		for _, file := range remoteFiles {
+			if !file.IsDeleted() {
+				a.quarantine(file)
+			}
+		}
+		return
+	}
+
+	if importOnConflict == conflictKeepRemote || importOnConflict == conflictKeepBoth {
+		a.importMissingCopies(localFiles, remoteFiles, a.conflictNote(importOnConflict))
 	}
 }
 
-func addFile(src, dest string) error {
-	if fi, err := os.Stat(dest); err == nil {
-		if fi.IsDir() {
-			return fmt.Errorf("destination is a directory for addFile operation: %s", dest)
+// importMissingCopies resolves a hash conflict in favour of the remote side.
+// All files have the same content, so keeping the remote version means
+// bringing in copies that are not already present locally.
+func (a *importAction) importMissingCopies(localFiles, remoteFiles []*lib.FileInfo, note string) {
+	localPaths := map[string]bool{}
+	for _, file := range localFiles {
+		localPaths[file.Path()] = true
+	}
+	for _, file := range remoteFiles {
+		if !file.IsDeleted() && !localPaths[file.Path()] {
+			a.importAlongside(file, note)
 		}
-		return fmt.Errorf("destination file exists for addFile operation: %s", dest)
-	} else if os.IsNotExist(err) {
-		fmt.Printf("cp %s %s\n", src, dest)
-		// this path is what is expected
-		return _copyFile(src, dest)
-	} else {
-		return fmt.Errorf("unexpected error when checking '%s' during addFile operation: %s", dest, err)
 	}
 }
 
-func replaceFile(src, dest string) error {
-	if fi, err := os.Stat(dest); err == nil {
-		if fi.IsDir() {
-			return fmt.Errorf("destination is a directory: %s", dest)
+// replace plans replacing contents of the local file with the remote version.
+func (a *importAction) replace(localFile, remoteFile *lib.FileInfo, note string, mergeHistory bool) {
+	a.tx.add(&importOp{
+		kind:      opReplace,
+		src:       a.remoteSrc(remoteFile),
+		open:      a.fetch(remoteFile),
+		modTime:   remoteFile.Time(),
+		dest:      a.local.ResolvePath(localFile.Path()),
+		mode:      remoteFile.Mode(),
+		checksum:  remoteFile.Checksum(),
+		algorithm: remoteFile.HashAlgorithm(),
+		record: func() {
+			localPath := localFile.Path()
+			if mergeHistory {
+				// the current remote version is appended below, at the local
+				// path, so it is not merged twice
+				history := remoteFile.History[:len(remoteFile.History)-1]
+				localFile.History = lib.MergeHistory(localFile.History, history)
+			}
+			localFile.History = append(localFile.History, &lib.FileEvent{
+				Path:      localPath,
+				Time:      remoteFile.Time(),
+				Size:      remoteFile.Size(),
+				Checksum:  remoteFile.Checksum(),
+				Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+				Mode:      remoteFile.Mode(),
+				Note:      note,
+				Origin:    a.remote.GetOrigin(),
+			})
+		},
+	})
+}
+
+// resolveConflict plans resolving the conflict between the local and the
+// remote file with the given strategy. Local contents are never overwritten:
+// keep-remote imports the remote version next to the local file under a
+// suffixed name, and keep-both into the import dir, same as new files.
+// Keep-local records the remote history as superseded by the local version,
+// so that the conflict is not reported again.
+func (a *importAction) resolveConflict(localFile, remoteFile *lib.FileInfo, strategy string) {
+	switch strategy {
+	case conflictKeepLocal:
+		a.tx.record(func() {
+			// the current local version stays last, so it remains current
+			current := localFile.History[len(localFile.History)-1]
+			history := lib.MergeHistory(localFile.History[:len(localFile.History)-1], remoteFile.History)
+			localFile.History = append(history, current)
+		})
+	case conflictKeepRemote:
+		dest := a.suffixedPath(a.local.ResolvePath(localFile.Path()))
+		a.importCopy(remoteFile, dest, a.conflictNote(strategy))
+	case conflictKeepBoth:
+		a.importAlongside(remoteFile, a.conflictNote(strategy))
+	}
+}
+
+// conflictNote returns the note recorded with files changed when resolving
+// a conflict with the given strategy.
+func (a *importAction) conflictNote(strategy string) string {
+	note := fmt.Sprintf("conflict resolved with %s", strategy)
+	if a.note != "" {
+		note = a.note + "; " + note
+	}
+	return note
+}
+
+// importAlongside copies the remote file into the import dir, same as new
+// files, but under a suffixed name if a local file already uses the path.
+func (a *importAction) importAlongside(remoteFile *lib.FileInfo, note string) {
+	dest := a.suffixedPath(filepath.Join(a.local.GetImportDir(), importSubdir, remoteFile.Path()))
+	a.importCopy(remoteFile, dest, note)
+}
+
+// quarantine copies the remote version of a conflicting file into the
+// quarantine subdir of the import dir, keeping its relative path, so that the
+// conflict can be resolved later.
+func (a *importAction) quarantine(remoteFile *lib.FileInfo) {
+	dest := a.suffixedPath(filepath.Join(a.local.GetImportDir(), quarantineSubdir, remoteFile.Path()))
+	note := "conflict quarantined"
+	if a.note != "" {
+		note = a.note + "; " + note
+	}
+	a.importCopy(remoteFile, dest, note)
+}
+
+// importCopy plans copying the remote file to dest and recording it as a new
+// local file, with the remote history followed by the copy.
+func (a *importAction) importCopy(remoteFile *lib.FileInfo, dest, note string) {
+	localPath, err := filepath.Rel(a.local.GetBaseDir(), dest)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	a.tx.add(&importOp{
+		kind:      opAdd,
+		src:       a.remoteSrc(remoteFile),
+		open:      a.fetch(remoteFile),
+		modTime:   remoteFile.Time(),
+		dest:      dest,
+		mode:      remoteFile.Mode(),
+		checksum:  remoteFile.Checksum(),
+		algorithm: remoteFile.HashAlgorithm(),
+		record: func() {
+			a.local.AddFile(&lib.FileInfo{
+				History: append(append([]*lib.FileEvent{}, remoteFile.History...), &lib.FileEvent{
+					Path:      localPath,
+					Time:      remoteFile.Time(),
+					Size:      remoteFile.Size(),
+					Checksum:  remoteFile.Checksum(),
+					Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+					Mode:      remoteFile.Mode(),
+					Note:      note,
+					Origin:    a.remote.GetOrigin(),
+				}),
+			})
+		},
+	})
+}
+
+// suffixedPath returns the path if no file exists or is planned there,
+// otherwise the path with '.remote' suffix added before the extension,
+// numbered if needed.
+func (a *importAction) suffixedPath(path string) string {
+	free := func(path string) bool {
+		_, err := os.Lstat(path)
+		return os.IsNotExist(err) && !a.tx.planned(path)
+	}
+	if free(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		suffixed := base + ".remote" + ext
+		if i > 1 {
+			suffixed = fmt.Sprintf("%s.remote-%d%s", base, i, ext)
 		}
-		fmt.Printf("cp -f %s %s\n", src, dest)
-		// this path is what is expected
-		return _copyFile(src, dest)
-	} else if os.IsNotExist(err) {
-		return fmt.Errorf("destination file missing for replaceFile operation: %s", dest)
-	} else {
-		return fmt.Errorf("unexpected error when checking '%s' during replaceFile operation: %s", dest, err)
+		if free(suffixed) {
+			return suffixed
+		}
+	}
+}
+
+// printImportSummary prints totals of the import, as json if --json is set.
+func printImportSummary(summary importSummary) {
+	if importJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summary); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		return
 	}
+	fmt.Printf("added: %d\n", summary.Added)
+	fmt.Printf("replaced: %d\n", summary.Replaced)
+	fmt.Printf("moved: %d\n", summary.Moved)
+	fmt.Printf("deleted: %d\n", summary.Deleted)
+	fmt.Printf("bytes: %d\n", summary.Bytes)
+	fmt.Printf("failed: %d\n", summary.Failed)
 }
 
 // Copy the src file to dest. Any existing file will be overwritten and will not
@@ -310,7 +658,7 @@ func _copyFile(src, dest string) error {
 	}
 
 	// put temporary file into final desination
-	backupDest := dest + ".boffin-old"
+	backupDest := dest + backupSuffix
 	var backupErr error
 	if backupErr = os.Rename(dest, backupDest); backupErr != nil {
 		if !os.IsNotExist(backupErr) {
@@ -336,34 +684,6 @@ func _copyFile(src, dest string) error {
 	return nil
 }
 
-// Move/rename the src file to dest. Fail if destination already exists.
-func moveFile(src, dest string) error {
-	if fi, err := os.Stat(dest); err == nil {
-		if fi.IsDir() {
-			return fmt.Errorf("destination is a directory for moveFile operation: %s", dest)
-		}
-		return fmt.Errorf("destination file exists for moveFile operation: %s", dest)
-	} else if os.IsNotExist(err) {
-		// this path is what is expected
-	} else {
-		return fmt.Errorf("unexpected error when checking '%s' during addFile operation: %s", dest, err)
-	}
-
-	if dryRun {
-		return nil
-	}
-
-	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
-		return err
-	}
-
-	if err := os.Rename(src, dest); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func init() {
 	rootCmd.AddCommand(importCmd)
 
@@ -374,6 +694,18 @@ func init() {
 	// importCmd.PersistentFlags().String("foo", "", "A help for foo")
 	importCmd.PersistentFlags().BoolVar(&doMove, "move", false, "move and rename any files moved or renamed remotely")
 	importCmd.PersistentFlags().BoolVar(&doDelete, "delete", false, "delete files that were deleted remotely")
+	importCmd.PersistentFlags().BoolVar(&importThenUpdate, "then-update", false, "update the local repository after import, before it is saved")
+	importCmd.PersistentFlags().BoolVar(&importMergeHistory, "merge-history", false, "keep full history of remotely changed files, not only their latest version")
+	importCmd.PersistentFlags().StringVarP(&importMessage, "message", "m", "", "note recorded with all changes made by this import")
+	importCmd.PersistentFlags().StringVar(&importStripLocalPrefix, "strip-local-prefix", "", "ignore this directory prefix of local paths when matching files by path")
+	importCmd.PersistentFlags().BoolVar(&importPlanOnly, "plan-only", false, "print planned operations as json and exit without changing any files or the repository")
+	importCmd.PersistentFlags().BoolVar(&importJSON, "json", false, "print the summary of the import as json")
+	importCmd.PersistentFlags().BoolVar(&importIgnoreCase, "ignore-case", false, "ignore case when matching files by path, e.g. when importing from a case-insensitive file system")
+	importCmd.PersistentFlags().StringVar(&importStripRemotePrefix, "strip-remote-prefix", "", "ignore this directory prefix of remote paths when matching files by path")
+	importCmd.PersistentFlags().StringVar(&importOnConflict, "on-conflict", conflictSkip, "how to resolve conflicts: skip, keep-local (record the remote version as superseded), keep-remote (import remote version next to the local file under a suffixed name) or keep-both (import remote version into the import directory, under a suffixed name if needed)")
+	importCmd.PersistentFlags().BoolVar(&importNewerOnly, "newer-only", false, "import changed and conflicting files only if the remote version is newer than the local one; older remote versions are skipped")
+	importCmd.PersistentFlags().BoolVar(&importQuarantine, "quarantine", false, "copy remote versions of conflicting files into '"+quarantineSubdir+"' subdirectory of the import directory, to be resolved later")
+	importCmd.PersistentFlags().StringVar(&importSubdir, "import-subdir", "", "subdirectory of the import directory to copy new files into, e.g. '2024-06'")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
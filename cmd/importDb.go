@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var importDbForce bool
+
+// importDbCmd represents the import-db command
+var importDbCmd = &cobra.Command{
+	Use:   "import-db <export-file> <base-dir>",
+	Short: "Create repository from exported meta-data.",
+	Long: `Create repository for files in base-dir from meta-data written by
+	'export'. Use '-' to read from standard input. This only restores the
+	meta-data, no files are copied; use 'import' for that. An existing
+	repository is never overwritten unless --force is given.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		baseDir := args[1]
+
+		if dbDir == "" {
+			dbDir = lib.ConstuctDbPathWithName(baseDir, dbName)
+		}
+
+		var input io.Reader = os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+			defer func() {
+				_ = file.Close()
+			}()
+			input = file
+		}
+
+		if dryRun {
+			return
+		}
+		local, err := lib.ImportDb(input, dbDir, baseDir, importDbForce)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		fmt.Printf("imported %d files\n", len(local.GetFiles()))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importDbCmd)
+
+	importDbCmd.Flags().BoolVar(&importDbForce, "force", false, "replace existing repository")
+}
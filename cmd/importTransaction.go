@@ -0,0 +1,484 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+type importOpKind int
+
+const (
+	opAdd importOpKind = iota
+	opReplace
+	opMove
+	opDelete
+)
+
+// importOp is a single file operation planned by import, together with the
+// change to the history recorded once all operations succeed.
+type importOp struct {
+	kind    importOpKind
+	src     string
+	dest    string
+	open    func() (io.ReadCloser, error) // contents of src, for copies
+	mode    os.FileMode                   // mode of the copied file; if zero, same as src
+	modTime time.Time                     // used if src is not a local file
+	// expected contents of the copy, as recorded in the remote repo; copies
+	// are not verified if empty
+	checksum  string
+	algorithm lib.HashAlgorithm
+	record    func()
+
+	staged    string // copy of src, or moved src, next to dest, waiting to be renamed into place
+	backup    string // original dest, kept until the whole import succeeds
+	committed bool
+	copied    int64 // bytes copied from src
+	failed    bool
+}
+
+// importPlanEntry is the operation as printed by import --plan-only.
+type importPlanEntry struct {
+	Op   string `json:"op"`
+	Src  string `json:"src,omitempty"`
+	Dest string `json:"dest"`
+}
+
+func (op *importOp) planEntry() importPlanEntry {
+	entry := importPlanEntry{Src: op.src, Dest: op.dest}
+	switch op.kind {
+	case opAdd:
+		entry.Op = "copy"
+	case opReplace:
+		entry.Op = "replace"
+	case opMove:
+		entry.Op = "move"
+	default:
+		entry.Op = "delete"
+	}
+	return entry
+}
+
+func (op *importOp) String() string {
+	src, dest := displayPath(op.src), displayPath(op.dest)
+	switch op.kind {
+	case opAdd:
+		return fmt.Sprintf("cp %s %s", src, dest)
+	case opReplace:
+		return fmt.Sprintf("cp -f %s %s", src, dest)
+	case opMove:
+		return fmt.Sprintf("mv %s %s", src, dest)
+	default:
+		return fmt.Sprintf("rm %s", dest)
+	}
+}
+
+// importTransaction collects all operations of an import so that they can be
+// applied together. Either all files are changed and the history recorded, or
+// the files are left as they were.
+type importTransaction struct {
+	ops     []*importOp
+	records []func()   // history changes which need no file operation
+	dirs    []string   // directories created by the transaction
+	logger  lib.Logger // reports operations as they are planned
+	applied bool
+}
+
+// importSummary is printed at the end of import. Files are counted only if the
+// import succeeded, while bytes count everything copied, even if it was later
+// rolled back.
+type importSummary struct {
+	Added    int   `json:"added"`
+	Replaced int   `json:"replaced"`
+	Moved    int   `json:"moved"`
+	Deleted  int   `json:"deleted"`
+	Bytes    int64 `json:"bytes"`
+	Failed   int   `json:"failed"`
+}
+
+func (t *importTransaction) add(op *importOp) {
+	t.logger.Infof("%s", op)
+	t.ops = append(t.ops, op)
+}
+
+// record plans a change of the history which needs no file operation, e.g. a
+// decision how to resolve a conflict. It is made only if the import succeeds.
+func (t *importTransaction) record(record func()) {
+	t.records = append(t.records, record)
+}
+
+// plan returns all planned operations, in the order they will be applied.
+func (t *importTransaction) plan() []importPlanEntry {
+	plan := []importPlanEntry{}
+	for _, op := range t.ops {
+		plan = append(plan, op.planEntry())
+	}
+	return plan
+}
+
+// summary returns totals of the operations applied so far.
+func (t *importTransaction) summary() importSummary {
+	summary := importSummary{}
+	for _, op := range t.ops {
+		summary.Bytes += op.copied
+		if op.failed {
+			summary.Failed++
+		}
+		if !t.applied {
+			continue
+		}
+		switch op.kind {
+		case opAdd:
+			summary.Added++
+		case opReplace:
+			summary.Replaced++
+		case opMove:
+			summary.Moved++
+		case opDelete:
+			summary.Deleted++
+		}
+	}
+	return summary
+}
+
+// planned returns true if some operation will create a file at the path.
+func (t *importTransaction) planned(path string) bool {
+	for _, op := range t.ops {
+		if op.kind != opDelete && op.dest == path {
+			return true
+		}
+	}
+	return false
+}
+
+// apply first copies all new contents next to their destination, and moves
+// files to be moved next to theirs, then renames everything into place. Moving
+// through temporary names allows files to swap paths. Only when all files are
+// in place is the history recorded. On any error all changes are rolled back.
+func (t *importTransaction) apply() error {
+	for _, op := range t.ops {
+		if err := t.stage(op); err != nil {
+			op.failed = true
+			t.rollback()
+			return err
+		}
+	}
+	// files are moved only once all copies were staged, so that copies see
+	// the files as they were
+	for _, op := range t.ops {
+		if op.kind != opMove {
+			continue
+		}
+		if err := t.stageMove(op); err != nil {
+			op.failed = true
+			t.rollback()
+			return err
+		}
+	}
+	for _, op := range t.ops {
+		if err := t.commit(op); err != nil {
+			op.failed = true
+			t.rollback()
+			return err
+		}
+	}
+
+	for _, op := range t.ops {
+		if op.backup != "" {
+			if err := os.Remove(op.backup); err != nil {
+				t.logger.Warnf("%v", err)
+			}
+		}
+		op.record()
+	}
+	for _, record := range t.records {
+		record()
+	}
+	t.applied = true
+	return nil
+}
+
+// stage checks that the operation can be done and copies the new contents
+// to a temporary file.
+func (t *importTransaction) stage(op *importOp) error {
+	fi, err := os.Lstat(op.dest)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unexpected error when checking '%s': %v", op.dest, err)
+	}
+	exists := err == nil
+	if exists && fi.IsDir() {
+		return fmt.Errorf("destination is a directory: %s", op.dest)
+	}
+	// a backup left behind by an interrupted import may be the only copy of
+	// the file, and must not be overwritten
+	if op.kind == opReplace || op.kind == opDelete {
+		backup := op.dest + backupSuffix
+		if _, err := os.Lstat(backup); err == nil {
+			return fmt.Errorf("backup file exists: %s; run clean first", backup)
+		}
+	}
+
+	switch op.kind {
+	case opAdd:
+		if exists {
+			return fmt.Errorf("destination file exists: %s", op.dest)
+		}
+		return t.stageCopy(op)
+	case opReplace:
+		if !exists {
+			return fmt.Errorf("destination file missing: %s", op.dest)
+		}
+		return t.stageCopy(op)
+	case opMove:
+		if exists && !t.movedAway(op.dest) {
+			return fmt.Errorf("destination file exists: %s", op.dest)
+		}
+		if _, err := os.Lstat(op.src); err != nil {
+			return err
+		}
+	case opDelete:
+		if !exists {
+			return fmt.Errorf("file to delete is missing: %s", op.dest)
+		}
+	}
+	return nil
+}
+
+// movedAway returns true if the file at the path is moved elsewhere by the
+// transaction, so that the path can be used by another file.
+func (t *importTransaction) movedAway(path string) bool {
+	for _, op := range t.ops {
+		if op.kind == opMove && op.src == path {
+			return true
+		}
+	}
+	return false
+}
+
+// stageMove moves the source file next to the destination.
+func (t *importTransaction) stageMove(op *importOp) error {
+	if err := t.mkdirAll(filepath.Dir(op.dest)); err != nil {
+		return err
+	}
+	staged := op.dest + ".boffin-tmp"
+	if _, err := os.Lstat(staged); err == nil {
+		return fmt.Errorf("temporary file exists: %s", staged)
+	}
+	if err := os.Rename(op.src, staged); err != nil {
+		return err
+	}
+	op.staged = staged
+	return nil
+}
+
+// stageCopy copies the source file next to the destination, preserving its
+// modification time and the recorded mode, or the mode of the source file if
+// none was recorded. For files that are not local, the recorded modification
+// time is used.
+func (t *importTransaction) stageCopy(op *importOp) error {
+	in, err := op.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if in != nil {
+			_ = in.Close()
+		}
+	}()
+	mode, modTime := op.mode, op.modTime
+	if file, ok := in.(*os.File); ok {
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if mode == 0 {
+			mode = stat.Mode()
+		}
+		modTime = stat.ModTime()
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+
+	if err := t.mkdirAll(filepath.Dir(op.dest)); err != nil {
+		return err
+	}
+	staged := op.dest + ".boffin-tmp"
+	out, err := os.OpenFile(staged, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	op.staged = staged
+
+	op.copied, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	// remote copies report failures only once they are done
+	err = in.Close()
+	in = nil
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Chmod(mode); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = verifyCopy(op); err != nil {
+		return err
+	}
+	return os.Chtimes(staged, modTime, modTime)
+}
+
+// verifyCopy checks that the staged copy has the contents recorded in the
+// remote repo, which would not be the case if the remote files changed since
+// the remote repo was last updated.
+func verifyCopy(op *importOp) error {
+	// metadata-only checksums say nothing about the contents, and depend on
+	// a modification time the destination may not keep exactly
+	if op.checksum == "" || op.algorithm == lib.HashMetadataOnly {
+		return nil
+	}
+	checksum, err := lib.CalculateChecksumWith(op.staged, op.algorithm)
+	if err != nil {
+		return err
+	}
+	if checksum != op.checksum {
+		return fmt.Errorf("contents of '%s' do not match the remote repository; update the remote repository and try again", op.src)
+	}
+	return nil
+}
+
+// commit puts the file in place, keeping a backup of any file it replaces.
+func (t *importTransaction) commit(op *importOp) error {
+	switch op.kind {
+	case opAdd:
+		if err := os.Rename(op.staged, op.dest); err != nil {
+			return err
+		}
+		op.staged = ""
+	case opReplace:
+		backup := op.dest + backupSuffix
+		if err := os.Rename(op.dest, backup); err != nil {
+			return err
+		}
+		op.backup = backup
+		if err := os.Rename(op.staged, op.dest); err != nil {
+			// nothing was put in place yet, so only restore the original
+			if err := os.Rename(backup, op.dest); err != nil {
+				t.logger.Errorf("%v", err)
+			}
+			op.backup = ""
+			return err
+		}
+		op.staged = ""
+	case opMove:
+		if _, err := os.Lstat(op.dest); err == nil {
+			return fmt.Errorf("destination file exists: %s", op.dest)
+		}
+		if err := os.Rename(op.staged, op.dest); err != nil {
+			return err
+		}
+		op.staged = ""
+	case opDelete:
+		backup := op.dest + backupSuffix
+		if err := os.Rename(op.dest, backup); err != nil {
+			return err
+		}
+		op.backup = backup
+	}
+	op.committed = true
+	return nil
+}
+
+// rollback undoes committed operations in reverse order and removes any
+// staged files and created directories. Moved files are first all taken out
+// of their destinations, as they could be in the place of another moved file.
+func (t *importTransaction) rollback() {
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		op := t.ops[i]
+		if op.committed {
+			var err error
+			switch op.kind {
+			case opAdd:
+				err = os.Remove(op.dest)
+			case opReplace, opDelete:
+				err = os.Rename(op.backup, op.dest)
+			case opMove:
+				staged := op.dest + ".boffin-tmp"
+				if err = os.Rename(op.dest, staged); err == nil {
+					op.staged = staged
+				}
+			}
+			if err != nil {
+				t.logger.Errorf("rollback: %v", err)
+			}
+		}
+		if op.staged != "" && op.kind != opMove {
+			if err := os.Remove(op.staged); err != nil {
+				t.logger.Errorf("rollback: %v", err)
+			}
+		}
+	}
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		op := t.ops[i]
+		if op.kind == opMove && op.staged != "" {
+			if err := os.Rename(op.staged, op.src); err != nil {
+				t.logger.Errorf("rollback: %v", err)
+			}
+		}
+	}
+	for i := len(t.dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(t.dirs[i]); err != nil {
+			t.logger.Errorf("rollback: %v", err)
+		}
+	}
+}
+
+// mkdirAll creates the directory and any missing parents, remembering which
+// ones were created so that rollback can remove them.
+func (t *importTransaction) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0777); err != nil {
+			return err
+		}
+		t.dirs = append(t.dirs, missing[i])
+	}
+	return nil
+}
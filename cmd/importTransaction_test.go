@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+// failingReader returns an error after the first read, as a copy interrupted
+// half way would.
+type failingReader struct {
+	read bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("read failed")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+func (r *failingReader) Close() error {
+	return nil
+}
+
+// copyOp returns an operation copying the contents, or failing half way if
+// contents is nil, to dest.
+func copyOp(kind importOpKind, dest string, contents *string, recorded *bool) *importOp {
+	return &importOp{
+		kind: kind,
+		src:  "remote",
+		dest: dest,
+		open: func() (io.ReadCloser, error) {
+			if contents == nil {
+				return &failingReader{}, nil
+			}
+			return io.NopCloser(strings.NewReader(*contents)), nil
+		},
+		record: func() { *recorded = true },
+	}
+}
+
+// expectFiles checks that the dir contains exactly the given files, relative
+// path to contents.
+func expectFiles(t *testing.T, dir string, expected map[string]string) {
+	t.Helper()
+	actual := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		actual[filepath.ToSlash(rel)] = readTestFile(t, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for path, contents := range expected {
+		if actual[path] != contents {
+			t.Errorf("%s: expected '%s', got '%s'", path, contents, actual[path])
+		}
+		delete(actual, path)
+	}
+	for path := range actual {
+		t.Errorf("%s: unexpected file", path)
+	}
+}
+
+func TestImportTransactionFailedCopy(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.ext"), "original")
+
+	contents := "new"
+	recorded := false
+	tx := &importTransaction{logger: lib.NewLogger(nil)}
+	tx.add(copyOp(opReplace, filepath.Join(dir, "a.ext"), &contents, &recorded))
+	tx.add(copyOp(opAdd, filepath.Join(dir, "new", "b.ext"), &contents, &recorded))
+	tx.add(copyOp(opAdd, filepath.Join(dir, "new", "c.ext"), nil, &recorded))
+	tx.record(func() { recorded = true })
+
+	if err := tx.apply(); err == nil || !strings.Contains(err.Error(), "read failed") {
+		t.Fatalf("expected copy to fail, got: %v", err)
+	}
+	if recorded {
+		t.Errorf("history recorded for failed import")
+	}
+	// staged copies and created dirs are removed
+	expectFiles(t, dir, map[string]string{"a.ext": "original"})
+	if _, err := os.Lstat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("created dir not removed: %v", err)
+	}
+	if summary := tx.summary(); summary.Failed != 1 || summary.Added != 0 || summary.Replaced != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestImportTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	for path, contents := range map[string]string{"a.ext": "original", "b.ext": "deleted", "c.ext": "moved"} {
+		writeTestFile(t, filepath.Join(dir, path), contents)
+	}
+
+	contents := "new"
+	recorded := false
+	tx := &importTransaction{logger: lib.NewLogger(nil)}
+	tx.add(copyOp(opReplace, filepath.Join(dir, "a.ext"), &contents, &recorded))
+	tx.add(copyOp(opAdd, filepath.Join(dir, "new.ext"), &contents, &recorded))
+	tx.add(&importOp{kind: opDelete, dest: filepath.Join(dir, "b.ext"), record: func() { recorded = true }})
+	tx.add(&importOp{kind: opMove, src: filepath.Join(dir, "c.ext"), dest: filepath.Join(dir, "d.ext"), record: func() { recorded = true }})
+	// the moved file is already gone when its deletion is committed, so the
+	// transaction fails after all other operations were committed
+	tx.add(&importOp{kind: opDelete, dest: filepath.Join(dir, "c.ext"), record: func() { recorded = true }})
+
+	if err := tx.apply(); err == nil {
+		t.Fatalf("expected commit to fail")
+	}
+	for _, op := range tx.ops[:4] {
+		if !op.committed {
+			t.Errorf("%s: expected to be committed before the failure", op)
+		}
+	}
+	if recorded {
+		t.Errorf("history recorded for failed import")
+	}
+	expectFiles(t, dir, map[string]string{"a.ext": "original", "b.ext": "deleted", "c.ext": "moved"})
+}
+
+func TestImportTransactionLeftovers(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		kind     importOpKind
+		leftover string
+	}{
+		{"temporary file of copy", opAdd, "b.ext.boffin-tmp"},
+		{"temporary file of replace", opReplace, "a.ext.boffin-tmp"},
+		{"backup of replace", opReplace, "a.ext.boffin-old"},
+		{"backup of delete", opDelete, "a.ext.boffin-old"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			expected := map[string]string{"a.ext": "original", test.leftover: "leftover"}
+			for path, contents := range expected {
+				writeTestFile(t, filepath.Join(dir, path), contents)
+			}
+
+			dest := filepath.Join(dir, "a.ext")
+			if test.kind == opAdd {
+				dest = filepath.Join(dir, "b.ext")
+			}
+			contents := "new"
+			recorded := false
+			tx := &importTransaction{logger: lib.NewLogger(nil)}
+			tx.add(copyOp(test.kind, dest, &contents, &recorded))
+
+			if err := tx.apply(); err == nil || !strings.Contains(err.Error(), test.leftover) {
+				t.Fatalf("expected error naming %s, got: %v", test.leftover, err)
+			}
+			if recorded {
+				t.Errorf("history recorded for failed import")
+			}
+			// leftovers may be the only copy of a file, so they are kept
+			expectFiles(t, dir, expected)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+// newTestRepo creates a repository with the given files, relative path to
+// contents, and records them.
+func newTestRepo(t *testing.T, files map[string]string) lib.Boffin {
+	t.Helper()
+	dir := t.TempDir()
+	for path, contents := range files {
+		writeTestFile(t, filepath.Join(dir, path), contents)
+	}
+	repo, err := lib.InitDbDir(lib.ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = lib.UpdateWithOptions(repo, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = repo.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// reload, as the commands do, so that all dirs are resolved
+	if repo, err = lib.LoadBoffin(repo.GetDbDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return repo
+}
+
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(contents)
+}
+
+// runImport imports the remote repo into the local one, the same way as the
+// import command does with default options.
+func runImport(t *testing.T, local, remote lib.Boffin) (*importAction, error) {
+	t.Helper()
+	action := &importAction{
+		local:   local,
+		remote:  remote,
+		fetcher: lib.NewRepoFetcher(remote),
+		logger:  lib.NewLogger(nil),
+	}
+	action.tx.logger = lib.NewLogger(nil)
+	if err := local.DiffAgainst(remote, action); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return action, action.tx.apply()
+}
+
+func TestImportMoveSwap(t *testing.T) {
+	defer func(move bool) { doMove = move }(doMove)
+	doMove = true
+
+	local := newTestRepo(t, map[string]string{"a.ext": "first", "b.ext": "second"})
+	remote := newTestRepo(t, map[string]string{"a.ext": "second", "b.ext": "first"})
+
+	if _, err := runImport(t, local, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for path, expected := range map[string]string{"a.ext": "second", "b.ext": "first"} {
+		if actual := readTestFile(t, local.ResolvePath(path)); actual != expected {
+			t.Errorf("%s: expected '%s', got '%s'", path, expected, actual)
+		}
+		file := local.GetFileByPath(path)
+		if file == nil || len(file.History) != 2 {
+			t.Errorf("%s: expected to be recorded as moved, got %v", path, file)
+		}
+	}
+	for _, path := range []string{"a.ext.boffin-tmp", "b.ext.boffin-tmp"} {
+		if _, err := os.Lstat(local.ResolvePath(path)); err == nil {
+			t.Errorf("%s: temporary file left behind", path)
+		}
+	}
+}
+
+func TestImportReAdded(t *testing.T) {
+	local := newTestRepo(t, map[string]string{"a.ext": "contents"})
+	if err := os.Remove(local.ResolvePath("a.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lib.UpdateWithOptions(local, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the remote file is added after the local one was deleted
+	remoteDir := t.TempDir()
+	writeTestFile(t, filepath.Join(remoteDir, "a.ext"), "contents")
+	added := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(remoteDir, "a.ext"), added, added); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote, err := lib.InitDbDir(lib.ConstuctDbPath(remoteDir), remoteDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = lib.UpdateWithOptions(remote, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err = runImport(t, local, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = local.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	local, err = lib.LoadBoffin(local.GetDbDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := local.GetFiles()
+	if len(files) != 1 {
+		t.Fatalf("expected the deleted file to be revived, got %d files", len(files))
+	}
+	if files[0].IsDeleted() || len(files[0].History) != 3 {
+		t.Errorf("expected the deleted file to be revived, got %v", files[0].History)
+	}
+	if actual := readTestFile(t, local.ResolvePath(files[0].Path())); actual != "contents" {
+		t.Errorf("expected 'contents', got '%s'", actual)
+	}
+
+	report, err := lib.CollectDiff(local, remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.ConflictHash) != 0 || len(report.ReAdded) != 0 {
+		t.Errorf("expected the re-added file to be imported, got %+v", report)
+	}
+}
+
+func TestImportMergeHistory(t *testing.T) {
+	defer func(merge bool) { importMergeHistory = merge }(importMergeHistory)
+	importMergeHistory = true
+
+	local := newTestRepo(t, map[string]string{"a.ext": "first"})
+	remote := newTestRepo(t, map[string]string{"a.ext": "first"})
+	writeTestFile(t, remote.ResolvePath("a.ext"), "second")
+	changed := time.Now().Add(time.Hour)
+	if err := os.Chtimes(remote.ResolvePath("a.ext"), changed, changed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lib.UpdateWithOptions(remote, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := runImport(t, local, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := readTestFile(t, local.ResolvePath("a.ext")); actual != "second" {
+		t.Errorf("expected 'second', got '%s'", actual)
+	}
+
+	localFile, remoteFile := local.GetFileByPath("a.ext"), remote.GetFileByPath("a.ext")
+	if localFile.Checksum() != remoteFile.Checksum() || localFile.Path() != "a.ext" {
+		t.Errorf("unexpected current event %v", localFile.CurrentEvent())
+	}
+	count := 0
+	for _, event := range localFile.History {
+		if event.Checksum == remoteFile.Checksum() {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected the current version to be recorded once, got %d", count)
+	}
+	for _, event := range localFile.History {
+		for _, remoteEvent := range remoteFile.History {
+			if event == remoteEvent {
+				t.Errorf("event %v is shared with the remote repo", event)
+			}
+		}
+	}
+}
+
+func TestImportConflictPath(t *testing.T) {
+	defer func(strategy string) { importOnConflict = strategy }(importOnConflict)
+
+	for _, strategy := range []string{conflictKeepLocal, conflictKeepRemote} {
+		t.Run(strategy, func(t *testing.T) {
+			importOnConflict = strategy
+			local := newTestRepo(t, map[string]string{"a.ext": "local"})
+			remote := newTestRepo(t, map[string]string{"a.ext": "remote"})
+
+			if _, err := runImport(t, local, remote); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := readTestFile(t, local.ResolvePath("a.ext")); actual != "local" {
+				t.Errorf("expected local file to be kept, got '%s'", actual)
+			}
+			if strategy == conflictKeepRemote {
+				if actual := readTestFile(t, local.ResolvePath("a.remote.ext")); actual != "remote" {
+					t.Errorf("expected remote file to be imported, got '%s'", actual)
+				}
+			}
+
+			report, err := lib.CollectDiff(local, remote)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(report.ConflictPath) != 0 {
+				t.Errorf("expected the conflict to be resolved, got %d conflicts", len(report.ConflictPath))
+			}
+		})
+	}
+}
+
+func TestImportDiverged(t *testing.T) {
+	defer func(strategy string) { importOnConflict = strategy }(importOnConflict)
+
+	for _, strategy := range []string{conflictSkip, conflictKeepLocal, conflictKeepRemote, conflictKeepBoth} {
+		t.Run(strategy, func(t *testing.T) {
+			importOnConflict = strategy
+			local := newTestRepo(t, map[string]string{"a.ext": "base"})
+			remote := newTestRepo(t, map[string]string{"a.ext": "base"})
+			changed := time.Now().Add(time.Hour)
+			for repo, contents := range map[lib.Boffin]string{local: "local", remote: "remote"} {
+				writeTestFile(t, repo.ResolvePath("a.ext"), contents)
+				if err := os.Chtimes(repo.ResolvePath("a.ext"), changed, changed); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := lib.UpdateWithOptions(repo, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			report, err := lib.CollectDiff(local, remote)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(report.Diverged) != 1 {
+				t.Fatalf("expected the file to diverge, got %+v", report)
+			}
+
+			if _, err = runImport(t, local, remote); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := readTestFile(t, local.ResolvePath("a.ext")); actual != "local" {
+				t.Errorf("expected local file to be kept, got '%s'", actual)
+			}
+			checksum, err := lib.CalculateChecksum(local.ResolvePath("a.ext"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if file := local.GetFileByPath("a.ext"); file.Checksum() != checksum {
+				t.Errorf("expected local version to stay current, got %v", file.CurrentEvent())
+			}
+		})
+	}
+}
+
+func TestImportLoadRemote(t *testing.T) {
+	defer func(dir string) { dbDir = dir }(dbDir)
+	dbDir = "local"
+
+	remote := newTestRepo(t, map[string]string{"a.ext": "contents"})
+	action := &importAction{}
+	if err := action.loadRemote(remote.GetBaseDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if action.remote == nil || action.remote.GetFileByPath("a.ext") == nil {
+		t.Errorf("remote repo not loaded")
+	}
+	if action.fetcher == nil || action.remoteURL != "" {
+		t.Errorf("expected local fetcher, got %v and URL '%s'", action.fetcher, action.remoteURL)
+	}
+	if dbDir != "local" {
+		t.Errorf("local db dir changed to '%s'", dbDir)
+	}
+
+	if err := (&importAction{}).loadRemote(t.TempDir()); err == nil {
+		t.Errorf("expected error for a directory without a repo")
+	}
+}
@@ -19,30 +19,78 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
 )
 
+var initAppendOnly bool
+var initTimezone string
+var initUpdate bool
+var initBackups int
+var initOrigin string
+var initCompress bool
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init <base-dir>",
 	Short: "Create new repository.",
 	Long: `Create new and empty repository. Unless there are no files in the
-	directory, it should be almost always followed by 'update'.`,
+	directory, it should be almost always followed by 'update', or use --update
+	to scan the files right away.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		baseDir := args[0]
 
 		if dbDir == "" {
-			dbDir = lib.ConstuctDbPath(baseDir)
+			dbDir = lib.ConstuctDbPathWithName(baseDir, dbName)
 		}
 
-		_, err := lib.InitDbDir(dbDir, baseDir)
+		boffin, err := lib.InitDbDir(dbDir, baseDir)
 		if err != nil {
 			log.Fatalf("ERROR: %v\n", err)
 		}
+
+		if initAppendOnly || initTimezone != "" || initBackups != lib.DefaultBackups || initOrigin != "" || initCompress {
+			boffin.SetAppendOnly(initAppendOnly)
+			boffin.SetOrigin(initOrigin)
+			boffin.SetCompressed(initCompress)
+			if initBackups != lib.DefaultBackups {
+				boffin.SetBackups(initBackups)
+			}
+			if err = boffin.SetTimezone(initTimezone); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+			if err = boffin.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		if initUpdate {
+			opts := &lib.UpdateOptions{
+				Filter:         contentsFilter(),
+				HashAlgorithm:  lib.HashAlgorithm(hashAlgorithm),
+				FollowSymlinks: followSymlinks,
+				MinSize:        minSize,
+			}
+			err = lib.UpdateWithOptions(boffin, opts)
+			var unreadable *lib.UnreadableError
+			if err != nil && !errors.As(err, &unreadable) {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+			if !dryRun {
+				if err = boffin.Save(); err != nil {
+					log.Fatalf("ERROR: %v\n", err)
+				}
+			}
+			fmt.Printf("indexed %d files\n", len(boffin.GetFiles()))
+			if unreadable != nil {
+				log.Fatalf("ERROR: %v\n", unreadable)
+			}
+		}
 	},
 }
 
@@ -58,4 +106,14 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// initCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	initCmd.Flags().BoolVar(&initUpdate, "update", false, "scan files in the base directory right after creating the repository")
+	initCmd.Flags().BoolVar(&checkContents, "check-contents", false, "force content check even if file metadata matches; used with --update")
+	initCmd.Flags().StringVar(&hashAlgorithm, "hash", string(lib.DefaultHashAlgorithm), "hash algorithm used for new files, one of sha256, sha512, sha1 or md5; used with --update")
+	initCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "scan symlinked directories and record symlinked files by their target's contents; used with --update")
+	initCmd.Flags().Int64Var(&minSize, "min-size", 0, "do not add files smaller than this many bytes; used with --update")
+	initCmd.Flags().StringVar(&initTimezone, "timezone", "", "timezone used to format times in the repository file, e.g. 'Europe/Belgrade' (default is UTC)")
+	initCmd.Flags().StringVar(&initOrigin, "origin", "", "name of the device or collection, e.g. 'phone', recorded with files imported from this repository")
+	initCmd.Flags().BoolVar(&initCompress, "compress", false, "save the repository file gzip compressed, which makes large repositories faster to load")
+	initCmd.Flags().IntVar(&initBackups, "backups", lib.DefaultBackups, "number of previous repository files kept as backups; 0 disables backups")
+	initCmd.Flags().BoolVar(&initAppendOnly, "append-only", false, "never mark files as deleted or delete any files in this repository")
 }
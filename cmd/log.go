@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// logCmd represents the log command
+var logCmd = &cobra.Command{
+	Use:   "log <path>",
+	Short: "Show the full history of a file.",
+	Long: `Log prints every recorded event of the file in chronological order,
+	with its time, size, checksum and path, showing renames and content changes
+	over time. If the file was deleted, the deletion is shown as well. Events
+	recorded by import show the origin of the repository they came from.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		path := repoPath(local, args[0])
+		file := local.GetFileByPath(path)
+		if file == nil {
+			for _, other := range local.GetFiles() {
+				if filepath.Base(other.Path()) == filepath.Base(path) {
+					log.Printf("did you mean '%s'?", other.Path())
+				}
+			}
+			log.Fatalf("ERROR: '%s' is not tracked\n", path)
+		}
+
+		// history is normally in order, but do not rely on it for display
+		history := append([]*lib.FileEvent{}, file.History...)
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Time.Before(history[j].Time)
+		})
+
+		for _, event := range history {
+			if event.Checksum == "" {
+				fmt.Printf("%s  %10s  %-*s  %s", event.Time.Format(time.RFC3339), "", hashWidth(), "deleted", event.Path)
+			} else {
+				fmt.Printf("%s  %10d  %-*s  %s", event.Time.Format(time.RFC3339), event.Size, hashWidth(), displayHash(event.Checksum), event.Path)
+			}
+			if event.Note != "" {
+				fmt.Printf("  (%s)", event.Note)
+			}
+			if event.Origin != "" {
+				fmt.Printf("  [from %s]", event.Origin)
+			}
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+}
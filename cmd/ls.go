@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var lsRecursive bool
+var lsDeleted bool
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls [<path>]",
+	Short: "List tracked files.",
+	Long: `List files tracked in the repository under the path, or in the current
+	directory, similar to 'ls'. For every file its last change time, size, short
+	checksum and path are shown; subdirectories are shown with a trailing '/'.
+	Use -R to list all files in subdirectories as well, and --deleted to include
+	files that were deleted, which are marked as 'deleted' instead of their
+	checksum. Only the repository is read, so files do not need to be
+	accessible, e.g. when they are on a disconnected drive.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		path := "."
+		if len(args) == 1 {
+			path = args[0]
+		}
+		path = repoPath(local, path)
+
+		prefix := ""
+		if path != "." {
+			prefix = path + string(filepath.Separator)
+		}
+		files := []*lib.FileInfo{}
+		dirs := map[string]bool{}
+		for _, file := range local.GetFiles() {
+			if file.IsDeleted() && !lsDeleted {
+				continue
+			}
+			if file.Path() == path {
+				// listing a single file
+				files = append(files, file)
+				continue
+			}
+			if !strings.HasPrefix(file.Path(), prefix) {
+				continue
+			}
+			rest := file.Path()[len(prefix):]
+			if i := strings.Index(rest, string(filepath.Separator)); i >= 0 && !lsRecursive {
+				dirs[prefix+rest[:i]] = true
+				continue
+			}
+			files = append(files, file)
+		}
+		if len(files) == 0 && len(dirs) == 0 {
+			log.Fatalf("ERROR: no tracked files in '%s'\n", path)
+		}
+
+		type entry struct {
+			path string
+			file *lib.FileInfo
+		}
+		entries := make([]entry, 0, len(files)+len(dirs))
+		for _, file := range files {
+			entries = append(entries, entry{path: file.Path(), file: file})
+		}
+		for dir := range dirs {
+			entries = append(entries, entry{path: dir})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].path < entries[j].path
+		})
+
+		width := defaultShortHash
+		if shortHash > 0 {
+			width = shortHash
+		}
+		for _, entry := range entries {
+			file := entry.file
+			switch {
+			case file == nil:
+				fmt.Printf("%25s  %10s  %-*s  %s%c\n", "", "", width, "", displayPath(entry.path), filepath.Separator)
+			case file.IsDeleted():
+				fmt.Printf("%-25s  %10s  %-*s  %s\n", file.DeletedTime().Format(time.RFC3339), "", width, "deleted", displayPath(entry.path))
+			default:
+				fmt.Printf("%-25s  %10d  %-*s  %s\n", file.Time().Format(time.RFC3339), file.Size(), width, shortenHash(file.Checksum(), width), displayPath(entry.path))
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().BoolVarP(&lsRecursive, "recursive", "R", false, "list files in subdirectories as well")
+	lsCmd.Flags().BoolVar(&lsDeleted, "deleted", false, "include files that were deleted")
+}
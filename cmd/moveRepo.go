@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// moveRepoCmd represents the move-repo command
+var moveRepoCmd = &cobra.Command{
+	Use:   "move-repo <other-repo>",
+	Short: "Merge history of another repository into this one.",
+	Long: `Move-repo folds the metadata of the other repository into this one.
+	Files which share history are merged into one file with the combined
+	history, and files not known to this repository are added. Files which
+	can not be matched unambiguously, e.g. because they changed in both
+	repositories, are reported as conflicts and left out.
+
+	Unlike 'import', no files are copied; afterwards the repository tracks the
+	newest known version of every file, and 'update' records how the files
+	on disk differ from it. The other repository is not changed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		remoteDir, err := lib.FindBoffinDirWithName(args[0], dbName)
+		if err != nil {
+			log.Fatalf("ERROR: other repository '%s': %v\n", args[0], err)
+		}
+		remote, err := lib.LoadBoffin(remoteDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		report, err := lib.MergeRepos(local, remote)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		for _, conflict := range report.Conflicts {
+			for _, file := range conflict.Local {
+				fmt.Printf("!!:%s\n", file.Path())
+			}
+			for _, file := range conflict.Remote {
+				fmt.Printf("!!:%s\n", file.Path())
+			}
+		}
+
+		// merged histories are rewritten, so the repository file is
+		// rewritten as a whole, same as with 'compact'
+		if !dryRun && report.Merged+report.Added > 0 {
+			if err = local.Compact(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+		fmt.Printf("merged: %d\n", report.Merged)
+		fmt.Printf("added: %d\n", report.Added)
+		fmt.Printf("conflicts: %d\n", len(report.Conflicts))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(moveRepoCmd)
+}
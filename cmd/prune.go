@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var pruneOlderThan string
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Permanently remove history of files deleted long ago.",
+	Long: `Prune removes files that were deleted before the given age from the
+	repository, together with their whole history. Deleted files that share
+	content with a file still in the repository are kept, so that the file can
+	still be matched with copies in other repositories.
+
+	Once pruned, files deleted here will show up as new when compared with a
+	repository that still has them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cutoff, err := parseCutoff(pruneOlderThan, time.Now())
+		if err != nil {
+			log.Fatalf("ERROR: invalid --older-than: %v\n", err)
+		}
+
+		if dbDir == "" {
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if local.IsAppendOnly() {
+			log.Fatalf("ERROR: repository is append-only; history can not be pruned\n")
+		}
+
+		removed := local.PruneDeleted(cutoff)
+		if !dryRun && removed > 0 {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+		fmt.Printf("pruned %d files\n", removed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "90d", "prune files deleted longer ago than this, in years, months, weeks or days (e.g. '2y', '6mo', '90d') or as a duration (e.g. '36h')")
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var relocateForce bool
+
+// relocateCmd represents the relocate command
+var relocateCmd = &cobra.Command{
+	Use:   "relocate <new-base-dir>",
+	Short: "Point repository to a new base directory.",
+	Long: `Use relocate after files were moved to a different location, e.g. a
+	new mount point. The base directory is saved relative to the repository if
+	possible, same as with 'init'. Import directory inside the old base
+	directory is moved along with it. All tracked files must exist in the new
+	location, unless --force is given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		if err = local.SetBaseDir(args[0]); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		missing := 0
+		for _, file := range local.GetFiles() {
+			if file.IsDeleted() {
+				continue
+			}
+			if _, err := os.Lstat(local.ResolvePath(file.Path())); err != nil {
+				fmt.Printf("missing: %s\n", file.Path())
+				missing++
+			}
+		}
+		if missing > 0 && !relocateForce {
+			log.Fatalf("ERROR: %d tracked files are missing in '%s'; use --force to relocate anyway\n", missing, local.GetBaseDir())
+		}
+
+		if !dryRun {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+		fmt.Printf("relocated to %s\n", local.GetBaseDir())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(relocateCmd)
+
+	relocateCmd.Flags().BoolVar(&relocateForce, "force", false, "relocate even if tracked files are missing in the new base directory")
+}
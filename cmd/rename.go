@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var renameMessage string
+
+// renameCmd represents the rename command
+var renameCmd = &cobra.Command{
+	Use:   "rename <old-path> <new-path>",
+	Short: "Record that a file was moved or renamed.",
+	Long: `Rename records that the file tracked at the old path was moved to the
+	new path by other means, e.g. by another tool, so that its history continues
+	instead of being recorded as deleted and added on the next update. The file
+	is not touched; the content at the new path must match the current checksum
+	of the file.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		oldPath := repoPath(local, args[0])
+		newPath := repoPath(local, args[1])
+		file := local.GetFileByPath(oldPath)
+		if file == nil || file.IsDeleted() {
+			log.Fatalf("ERROR: '%s' is not tracked\n", oldPath)
+		}
+		if other := local.GetFileByPath(newPath); other != nil && !other.IsDeleted() {
+			log.Fatalf("ERROR: '%s' is already tracked as another file\n", newPath)
+		}
+
+		dest := local.ResolvePath(newPath)
+		info, err := os.Stat(dest)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if info.IsDir() {
+			log.Fatalf("ERROR: '%s' is a directory\n", dest)
+		}
+		checksum, err := lib.CalculateChecksumWith(dest, file.HashAlgorithm())
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		if checksum != file.Checksum() {
+			log.Fatalf("ERROR: content of '%s' does not match '%s'\n", newPath, oldPath)
+		}
+		if _, err = os.Stat(local.ResolvePath(oldPath)); err == nil {
+			log.Printf("warning: '%s' still exists; it will be recorded as a new file on next update", oldPath)
+		}
+
+		fmt.Printf("mv %s %s\n", oldPath, newPath)
+		file.History = append(file.History, &lib.FileEvent{
+			Path:      newPath,
+			Time:      info.ModTime(),
+			Size:      info.Size(),
+			Checksum:  checksum,
+			Algorithm: file.History[len(file.History)-1].Algorithm,
+			Mode:      info.Mode().Perm(),
+			Note:      renameMessage,
+		})
+
+		if !dryRun {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+
+	renameCmd.Flags().StringVarP(&renameMessage, "message", "m", "", "note recorded with the rename")
+}
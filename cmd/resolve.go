@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var resolveMessage string
+
+// answer to a resolve prompt that stops asking about further conflicts
+const resolveQuit = "quit"
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve <remote-repo>",
+	Short: "Interactively resolve conflicts with the remote repository.",
+	Long: `Resolve compares the local and the remote repository, same as 'diff',
+	and asks how to resolve each conflict: keep the local version (the remote
+	version is recorded as superseded, so the conflict is not reported again),
+	keep the remote version (import it next to the local file under a suffixed
+	name), keep both (import the remote version into the import directory) or
+	skip the conflict for now, same as 'import --on-conflict'. Answering
+	quit stops asking; conflicts resolved so far are still applied.
+
+	Files are copied the same way as by 'import', so either all changes are
+	made, or none. Resolve needs a terminal; use 'import --on-conflict' to
+	resolve conflicts non-interactively.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !isTerminal(os.Stdin) {
+			log.Fatalf("ERROR: standard input is not a terminal; use 'import --on-conflict' to resolve conflicts non-interactively\n")
+		}
+
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		action := &importAction{
+			local:  local,
+			note:   resolveMessage,
+			logger: lib.DefaultLogger,
+		}
+		action.tx.logger = lib.DefaultLogger
+		if err = action.loadRemote(args[0]); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		diff, err := lib.CollectDiff(local, action.remote)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		// diverged files are resolved the same way as conflicts, see import
+		pairs := append(append([]*lib.DiffPair{}, diff.ConflictPath...), diff.Diverged...)
+		total := len(pairs) + len(diff.ConflictHash)
+		if total == 0 {
+			fmt.Println("no conflicts")
+			return
+		}
+
+		in := bufio.NewReader(os.Stdin)
+		n := 0
+		ask := func() string {
+			strategy, err := askResolution(in, os.Stdout)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+			return strategy
+		}
+		quit := false
+		for _, pair := range pairs {
+			n++
+			fmt.Printf("\nconflict %d of %d:\n", n, total)
+			printResolveFile("local: ", pair.Local)
+			printResolveFile("remote:", pair.Remote)
+			strategy := ask()
+			if strategy == resolveQuit {
+				quit = true
+				break
+			}
+			action.resolveConflict(pair.Local, pair.Remote, strategy)
+		}
+		for _, conflict := range diff.ConflictHash {
+			if quit {
+				break
+			}
+			n++
+			fmt.Printf("\nconflict %d of %d, same contents:\n", n, total)
+			for _, file := range conflict.Local {
+				printResolveFile("local: ", file)
+			}
+			for _, file := range conflict.Remote {
+				printResolveFile("remote:", file)
+			}
+			strategy := ask()
+			if strategy == resolveQuit {
+				quit = true
+			} else if strategy == conflictKeepRemote || strategy == conflictKeepBoth {
+				action.importMissingCopies(conflict.Local, conflict.Remote, action.conflictNote(strategy))
+			}
+		}
+
+		if dryRun {
+			return
+		}
+		err = action.tx.apply()
+		printImportSummary(action.tx.summary())
+		if err != nil {
+			log.Fatalf("ERROR: resolve failed, no changes were made: %v\n", err)
+		}
+		if err = local.Save(); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	},
+}
+
+// printResolveFile prints the current version of a conflicting file.
+func printResolveFile(side string, file *lib.FileInfo) {
+	if file.IsDeleted() {
+		fmt.Printf("  %s %s (deleted %s)\n", side, file.Path(), file.DeletedTime().Format(time.RFC3339))
+		return
+	}
+	fmt.Printf("  %s %s size=%d time=%s checksum=%s\n", side, file.Path(), file.Size(),
+		file.Time().Format(time.RFC3339), displayHash(file.Checksum()))
+}
+
+// askResolution asks how to resolve a conflict until a valid answer is given
+// and returns the chosen strategy, conflictSkip or resolveQuit.
+func askResolution(in *bufio.Reader, out io.Writer) (string, error) {
+	for {
+		fmt.Fprint(out, "keep [l]ocal, keep [r]emote, keep [b]oth, [s]kip or [q]uit? ")
+		answer, err := in.ReadString('\n')
+		if err == io.EOF && answer == "" {
+			return resolveQuit, nil
+		} else if err != nil && err != io.EOF {
+			return "", err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "l", "local":
+			return conflictKeepLocal, nil
+		case "r", "remote":
+			return conflictKeepRemote, nil
+		case "b", "both":
+			return conflictKeepBoth, nil
+		case "s", "skip", "":
+			return conflictSkip, nil
+		case "q", "quit":
+			return resolveQuit, nil
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+
+	resolveCmd.Flags().StringVarP(&resolveMessage, "message", "m", "", "note recorded with all changes made when resolving conflicts")
+}
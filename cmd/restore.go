@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+var restoreForce bool
+var restoreMessage string
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <remote-repo> <path>",
+	Short: "Restore deleted file from the remote repository.",
+	Long: `Restore looks for a file in the remote repository with the same content
+	as any version of the deleted local file, preferring the most recent one,
+	copies it back to its last known local path and marks it as no longer
+	deleted. Existing file at the path is not overwritten unless --force is
+	given.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		dbDir, err = lib.FindBoffinDirWithName(args[0], dbName)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		remote, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		path := repoPath(local, args[1])
+		var deleted, current *lib.FileInfo
+		for _, file := range local.GetFiles() {
+			if file.Path() != path {
+				continue
+			}
+			if !file.IsDeleted() {
+				current = file
+			} else if deleted == nil || deleted.DeletedTime().Before(file.DeletedTime()) {
+				deleted = file
+			}
+		}
+		if deleted == nil {
+			if current != nil {
+				log.Fatalf("ERROR: '%s' is not deleted\n", path)
+			}
+			log.Fatalf("ERROR: '%s' is not tracked\n", path)
+		}
+
+		dest := local.ResolvePath(path)
+		if !restoreForce {
+			if current != nil {
+				log.Fatalf("ERROR: '%s' is tracked as another file; use --force to overwrite it\n", path)
+			}
+			if _, err = os.Stat(dest); err == nil {
+				log.Fatalf("ERROR: '%s' already exists; use --force to overwrite it\n", dest)
+			}
+		}
+		if current != nil && local.IsAppendOnly() {
+			log.Fatalf("ERROR: repository is append-only; '%s' cannot be replaced\n", path)
+		}
+
+		source := findRestoreSource(deleted, remote)
+		if source == nil {
+			log.Fatalf("ERROR: no file in '%s' matches any version of '%s'\n", remote.GetBaseDir(), path)
+		}
+
+		src := remote.ResolvePath(source.Path())
+		fmt.Printf("cp %s %s\n", src, dest)
+		if err = _copyFile(src, dest); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		// bring back the mode the file had before it was deleted, if known
+		mode := deleted.Mode()
+		if mode == 0 {
+			mode = source.Mode()
+		} else if !dryRun {
+			if err = os.Chmod(dest, mode); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		if current != nil {
+			current.MarkDeleted()
+			current.History[len(current.History)-1].Note = restoreMessage
+		}
+		deleted.History = append(deleted.History, &lib.FileEvent{
+			Path:      path,
+			Time:      source.Time(),
+			Size:      source.Size(),
+			Checksum:  source.Checksum(),
+			Algorithm: source.History[len(source.History)-1].Algorithm,
+			Mode:      mode,
+			Note:      restoreMessage,
+		})
+
+		if !dryRun {
+			if err = local.Save(); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+	},
+}
+
+// findRestoreSource returns the remote file whose current content matches the
+// most recent possible version of the deleted file, or nil if there is none.
+func findRestoreSource(deleted *lib.FileInfo, remote lib.Boffin) *lib.FileInfo {
+	remoteFiles := remote.GetFiles()
+	for i := len(deleted.History) - 1; i >= 0; i-- {
+		event := deleted.History[i]
+		if event.Checksum == "" {
+			continue
+		}
+		for _, file := range remoteFiles {
+			if !file.IsDeleted() && file.Checksum() == event.Checksum && file.HashAlgorithm() == event.HashAlgorithm() {
+				return file
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().BoolVar(&restoreForce, "force", false, "overwrite existing file at the path of the restored file")
+	restoreCmd.Flags().StringVarP(&restoreMessage, "message", "m", "", "note recorded with the restored file")
+}
@@ -22,6 +22,12 @@ import (
 	"github.com/spf13/cobra"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"git.voreni.com/miki/boffin/lib"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -29,7 +35,13 @@ import (
 
 var cfgFile string
 var dbDir string
+var dbName string
 var dryRun bool
+var shortHash int
+
+// defaultShortHash is the checksum length shown by --short-hash without a
+// value, and by commands which always show short checksums.
+const defaultShortHash = 12
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -56,6 +68,83 @@ func stderr(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg, args...)
 }
 
+// displayHash returns the checksum as shown to the user. With --short-hash
+// only the first characters are shown; algorithm prefix, if any, is kept.
+func displayHash(checksum string) string {
+	if shortHash <= 0 {
+		return checksum
+	}
+	return shortenHash(checksum, shortHash)
+}
+
+// shortenHash returns the first n characters of the checksum, keeping the
+// algorithm prefix, if any.
+func shortenHash(checksum string, n int) string {
+	prefix := ""
+	if i := strings.LastIndex(checksum, ":"); i >= 0 {
+		prefix, checksum = checksum[:i+1], checksum[i+1:]
+	}
+	if len(checksum) > n {
+		checksum = checksum[:n]
+	}
+	return prefix + checksum
+}
+
+// displayPath returns the path as shown to the user. Paths which contain
+// new lines, tabs or other non-printable characters, start with a quote or
+// start or end with a space are quoted and escaped the same way as Go string
+// literals, so that every path is shown on a single line and can be parsed
+// unambiguously, e.g. with strconv.Unquote. Other paths are shown as they are.
+func displayPath(path string) string {
+	if path == "" {
+		return path
+	}
+	needsQuoting := strings.HasPrefix(path, `"`) || unicode.IsSpace(rune(path[0])) || unicode.IsSpace(rune(path[len(path)-1]))
+	for _, r := range path {
+		if !unicode.IsPrint(r) {
+			needsQuoting = true
+			break
+		}
+	}
+	if !needsQuoting {
+		return path
+	}
+	return strconv.Quote(path)
+}
+
+// hashWidth returns the width of the checksum column, which fits a full
+// base64 encoded sha256 checksum unless --short-hash is used.
+func hashWidth() int {
+	if shortHash > 0 {
+		return shortHash
+	}
+	return 44
+}
+
+// isOutside returns whether path, as returned by filepath.Rel, points outside
+// of the directory it is relative to.
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// repoPath converts path given on the command line to a path relative to the
+// repository base dir, or prefixed with the root it is in. Paths that do not
+// resolve to a location inside the base dir or any root are assumed to already
+// be repository paths.
+func repoPath(repo lib.Boffin, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		if rel, err := filepath.Rel(repo.GetBaseDir(), abs); err == nil && !isOutside(rel) {
+			return rel
+		}
+		for name, dir := range repo.GetRoots() {
+			if rel, err := filepath.Rel(dir, abs); err == nil && !isOutside(rel) {
+				return name + lib.RootSeparator + rel
+			}
+		}
+	}
+	return filepath.Clean(path)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -76,6 +165,9 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.boffin)")
 	rootCmd.PersistentFlags().StringVar(&dbDir, "db-dir", "", "db directory if out of BASE (default is BASE_DIR/.boffin)")
+	rootCmd.PersistentFlags().StringVar(&dbName, "db-name", lib.DefaultDbDirName, "name of the db directory; allows independent repositories of the same BASE, use a name starting with '.' so they do not track each other")
+	rootCmd.PersistentFlags().IntVar(&shortHash, "short-hash", 0, "show only the first N characters of checksums (12 if N is not given); repository files and JSON output keep full checksums")
+	rootCmd.PersistentFlags().Lookup("short-hash").NoOptDefVal = strconv.Itoa(defaultShortHash)
 	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "do not make any changed to files")
 
 	// Cobra also supports local flags, which will only run
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+func TestDisplayPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"plain.ext", "plain.ext"},
+		{"dir/with space.ext", "dir/with space.ext"},
+		{"$(rm -rf);.ext", "$(rm -rf);.ext"},
+		{"new\nline.ext", `"new\nline.ext"`},
+		{"tab\t.ext", `"tab\t.ext"`},
+		{`"quoted".ext`, `"\"quoted\".ext"`},
+		{" leading.ext", `" leading.ext"`},
+		{"trailing.ext ", `"trailing.ext "`},
+	}
+	for _, test := range tests {
+		if actual := displayPath(test.path); actual != test.expected {
+			t.Errorf("displayPath(%q): %s != %s", test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestShortenHash(t *testing.T) {
+	tests := []struct {
+		checksum string
+		expected string
+	}{
+		{"0123456789abcdef", "01234567"},
+		{"sha512:0123456789abcdef", "sha512:01234567"},
+		{"1024@1577836800000000000", "1024@157"},
+	}
+	for _, test := range tests {
+		if actual := shortenHash(test.checksum, 8); actual != test.expected {
+			t.Errorf("shortenHash(%q): %s != %s", test.checksum, test.expected, actual)
+		}
+	}
+}
+
+func TestIsOutside(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		rel      string
+		expected bool
+	}{
+		{".", false},
+		{"dir" + sep + "file.ext", false},
+		{"..file.ext", false},
+		{"..", true},
+		{".." + sep + "file.ext", true},
+		{".." + sep + ".." + sep + "dir", true},
+	}
+	for _, test := range tests {
+		if actual := isOutside(test.rel); actual != test.expected {
+			t.Errorf("isOutside(%q): %v != %v", test.rel, test.expected, actual)
+		}
+	}
+}
+
+func TestDisplayPathTrackedFile(t *testing.T) {
+	dir := t.TempDir()
+	name := "with spaces and a\nnew line.ext"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("contents"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo, err := lib.InitDbDir(lib.ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = lib.UpdateWithOptions(repo, &lib.UpdateOptions{Logger: lib.NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := repo.GetFiles()
+	if len(files) != 1 || files[0].Path() != name {
+		t.Fatalf("GetFiles: expected only %q", name)
+	}
+	shown := displayPath(files[0].Path())
+	if strings.ContainsAny(shown, "\n\t") {
+		t.Errorf("displayPath: %q is not a single line", shown)
+	}
+	if path, err := strconv.Unquote(shown); err != nil || path != name {
+		t.Errorf("displayPath: %s does not unquote to the original path: %q, %v", shown, path, err)
+	}
+}
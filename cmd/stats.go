@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show repository metrics.",
+	Long: `Stats prints an overview of the repository, one 'name: value' per
+	line, always in the same order so that the output can be used in scripts.
+	Times are in RFC 3339 format, or '-' if the repository is empty.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		local, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		stats := lib.CollectStats(local)
+		formatTime := func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.Format(time.RFC3339)
+		}
+
+		fmt.Printf("files: %d\n", stats.Files)
+		fmt.Printf("deleted-files: %d\n", stats.Deleted)
+		fmt.Printf("bytes: %d\n", stats.Bytes)
+		fmt.Printf("distinct-checksums: %d\n", stats.Checksums)
+		fmt.Printf("duplicate-groups: %d\n", stats.DuplicateGroups)
+		fmt.Printf("duplicate-files: %d\n", stats.DuplicateFiles)
+		fmt.Printf("events: %d\n", stats.Events)
+		fmt.Printf("average-history-length: %.2f\n", stats.AverageHistoryLength())
+		fmt.Printf("max-history-length: %d\n", stats.MaxHistoryLength)
+		fmt.Printf("oldest-event: %s\n", formatTime(stats.OldestEvent))
+		fmt.Printf("newest-event: %s\n", formatTime(stats.NewestEvent))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+}
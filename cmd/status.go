@@ -0,0 +1,137 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show changes that update would record.",
+	Long: `Status scans the repository the same way as 'update' does and shows a
+	summary of new, changed, moved and deleted files, without changing the
+	repository.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbDir == "" {
+			var err error
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
+			if err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		}
+
+		boffin, err := lib.LoadBoffin(dbDir)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		// checksums are logged while scanning, which is noise here
+		log.SetOutput(io.Discard)
+		report, err := lib.Status(boffin, &lib.UpdateOptions{
+			Filter:         contentsFilter(),
+			FollowSymlinks: followSymlinks,
+			MinSize:        minSize,
+		})
+		log.SetOutput(os.Stderr)
+		var unreadable *lib.UnreadableError
+		if err != nil && !errors.As(err, &unreadable) {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+
+		groups := []struct {
+			name  string
+			paths []string
+		}{
+			{"new", []string{}},
+			{"changed", []string{}},
+			{"moved", []string{}},
+			{"deleted", []string{}},
+			{"metadata changed", []string{}},
+			{"conflicts", []string{}},
+		}
+		add := func(group int, format string, args ...interface{}) {
+			groups[group].paths = append(groups[group].paths, fmt.Sprintf(format, args...))
+		}
+
+		for _, file := range report.RemoteOnly {
+			add(0, "%s", file.Path())
+		}
+		for _, pair := range append(report.RemoteChanged, report.ConflictPath...) {
+			add(1, "%s", pair.Remote.Path())
+		}
+		for _, pair := range report.Moved {
+			add(2, "%s => %s", pair.Local.Path(), pair.Remote.Path())
+		}
+		for _, file := range report.LocalOnly {
+			add(3, "%s", file.Path())
+		}
+		for _, pair := range report.MetaDataChanged {
+			add(4, "%s", pair.Local.Path())
+		}
+		for _, pair := range report.LocalChanged {
+			add(5, "%s", pair.Local.Path())
+		}
+		for _, conflict := range report.ConflictHash {
+			for _, file := range append(conflict.Local, conflict.Remote...) {
+				add(5, "%s", file.Path())
+			}
+		}
+
+		summary := ""
+		for _, group := range groups {
+			if summary != "" {
+				summary += ", "
+			}
+			summary += fmt.Sprintf("%d %s", len(group.paths), group.name)
+
+			if len(group.paths) == 0 {
+				continue
+			}
+			sort.Strings(group.paths)
+			fmt.Printf("%s (%d):\n", group.name, len(group.paths))
+			for _, path := range group.paths {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+		fmt.Println(summary)
+
+		if unreadable != nil {
+			log.Fatalf("ERROR: %v\n", unreadable)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVar(&checkContents, "check-contents", false, "force content check even if file metadata matches")
+	statusCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "scan symlinked directories and files, same as update")
+	statusCmd.Flags().Int64Var(&minSize, "min-size", 0, "ignore files smaller than this many bytes, same as update")
+}
@@ -19,26 +19,54 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
 )
 
 var checkContents bool
+var updateMessage string
+var hashAlgorithm string
+var updateJobs int
+var partialHashSize int64
+var followSymlinks bool
+var minSize int64
+var samplePercent float64
+var sampleSeed int64
+var savePartial bool
+var skipImportDir bool
+var maxReadRate int64
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
-	Use:   "update",
+	Use:   "update [<path>]...",
 	Short: "Look for changed files and update repository with any changes.",
 	Long: `Update looks for any added, removed or changed files in the
 	repository and updates meta-data correspondingly. By default, only if file
-	size or modification timestamp are changed will the file checksum be checked.`,
+	size or modification timestamp are changed will the file checksum be checked.
+	Files matching gitignore-style patterns in BASE_DIR/.boffinignore, or any
+	of the regular expressions in the 'ignore' list of the repo file, are not
+	tracked. Files matching patterns in BASE_DIR/.boffintrack are tracked by
+	their size and modification time only, and their contents are never read.
+	Update can be interrupted with Ctrl-C, in which case nothing is
+	saved, unless --save-partial is given.
+
+	If paths are given, only files and directories under them are scanned;
+	files elsewhere are left as they are.`,
 	// Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if dbDir == "" {
 			var err error
-			dbDir, err = lib.FindBoffinDir(dbDir)
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
 			if err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
@@ -49,22 +77,137 @@ var updateCmd = &cobra.Command{
 			log.Fatalf("ERROR: %v\n", err)
 		}
 
-		filterFunc := lib.CheckIfMetaChanged
-		if checkContents {
-			filterFunc = lib.ForceCheck
+		if samplePercent < 0 || samplePercent > 100 {
+			log.Fatalf("ERROR: --sample must be between 0 and 100\n")
+		}
+		if samplePercent > 0 && checkContents {
+			log.Fatalf("ERROR: --sample can not be used with --check-contents\n")
+		}
+		if maxReadRate < 0 {
+			log.Fatalf("ERROR: --max-read-rate can not be negative\n")
+		}
+		lib.SetMaxReadRate(maxReadRate)
+
+		filterFunc := contentsFilter()
+		if samplePercent > 0 {
+			filterFunc = lib.SampleCheck(samplePercent/100, sampleSeed)
+		}
+
+		opts := &lib.UpdateOptions{
+			Filter:          filterFunc,
+			Note:            updateMessage,
+			Preview:         dryRun,
+			HashAlgorithm:   lib.HashAlgorithm(hashAlgorithm),
+			Workers:         updateJobs,
+			PartialHashSize: partialHashSize,
+			FollowSymlinks:  followSymlinks,
+			MinSize:         minSize,
+			KeepPartial:     savePartial,
+			SkipImportDir:   skipImportDir,
+			// sampled files are hashed only to catch silent corruption
+			DetectCorruption: samplePercent > 0,
 		}
+		for _, path := range args {
+			opts.Paths = append(opts.Paths, repoPath(boffin, path))
+		}
+		if isTerminal(os.Stderr) {
+			progress := &progressLine{}
+			log.SetOutput(progress)
+			defer log.SetOutput(os.Stderr)
+			opts.Progress = progress.update
+		}
+		// stop the update on interrupt; interrupts are caught until the command
+		// exits, so saving is never cut short and leaves no temporary files
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-		if err = lib.Update(boffin, filterFunc); err != nil {
+		// unreadable files are skipped, but changes to the rest are saved
+		err = lib.UpdateWithOptionsContext(ctx, boffin, opts)
+		canceled := errors.Is(err, context.Canceled)
+		var unreadable *lib.UnreadableError
+		var corrupted *lib.CorruptedError
+		isUnreadable, isCorrupted := errors.As(err, &unreadable), errors.As(err, &corrupted)
+		if err != nil && !canceled && !isUnreadable && !isCorrupted {
 			log.Fatalf("ERROR: %v\n", err)
 		}
+		if canceled && !savePartial {
+			log.Fatalf("ERROR: update interrupted; no changes were saved\n")
+		}
 		if !dryRun {
 			if err = boffin.Save(); err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
 		}
+		if canceled {
+			log.Fatalf("ERROR: update interrupted; changes found so far were saved\n")
+		}
+		if corrupted != nil {
+			// also lists unreadable files
+			log.Fatalf("ERROR: %v\n", corrupted)
+		}
+		if unreadable != nil {
+			log.Fatalf("ERROR: %v\n", unreadable)
+		}
 	},
 }
 
+// contentsFilter returns the filter selected by --check-contents; by default
+// only files with changed size or modification time are hashed.
+func contentsFilter() lib.FilterFunc {
+	if checkContents {
+		return lib.ForceCheck
+	}
+	return lib.CheckIfMetaChanged
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressLine renders update progress on a single stderr line, which is
+// updated in place. Log output written through it is printed above the line.
+type progressLine struct {
+	mu       sync.Mutex
+	line     string
+	lastDraw time.Time
+}
+
+func (p *progressLine) update(progress lib.UpdateProgress) {
+	p.set(fmt.Sprintf("scanned %d files, hashed %d files (%.1f MB)", progress.Scanned, progress.Hashed, float64(progress.Bytes)/1e6), progress.Done)
+}
+
+// set replaces the progress line; it is redrawn at most every 100ms, except
+// when done, when it is drawn one last time and finished.
+func (p *progressLine) set(line string, done bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = line
+	if done {
+		// changes are printed to stdout next, so finish the line
+		p.draw()
+		stderr("\n")
+		p.line = ""
+	} else if time.Since(p.lastDraw) > 100*time.Millisecond {
+		p.draw()
+	}
+}
+
+func (p *progressLine) draw() {
+	stderr("\r\033[K%s", p.line)
+	p.lastDraw = time.Now()
+}
+
+func (p *progressLine) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stderr("\r\033[K%s", b)
+	if p.line != "" {
+		p.draw()
+	}
+	return len(b), nil
+}
+
 func init() {
 	rootCmd.AddCommand(updateCmd)
 
@@ -73,6 +216,17 @@ func init() {
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	updateCmd.PersistentFlags().BoolVar(&checkContents, "check-contents", false, "force content check even if file metadata matches")
+	updateCmd.PersistentFlags().StringVarP(&updateMessage, "message", "m", "", "note recorded with all changes made by this update")
+	updateCmd.PersistentFlags().StringVar(&hashAlgorithm, "hash", string(lib.DefaultHashAlgorithm), "hash algorithm used for new files, one of sha256, sha512, sha1 or md5")
+	updateCmd.PersistentFlags().Int64Var(&partialHashSize, "partial-hash", 0, "hash only the first and the last N bytes of new files larger than 2*N bytes; much faster for large files, but changes in the middle of the file will go unnoticed")
+	updateCmd.PersistentFlags().IntVarP(&updateJobs, "jobs", "j", 0, "number of files hashed in parallel (default is the number of CPUs)")
+	updateCmd.PersistentFlags().Float64Var(&samplePercent, "sample", 0, "also check contents of this percentage of unchanged files, picked at random, to detect silent corruption; corrupted files are reported and kept as they were")
+	updateCmd.PersistentFlags().Int64Var(&sampleSeed, "sample-seed", 0, "seed used to pick files for --sample; the same seed picks the same files (default is a new sample every run)")
+	updateCmd.PersistentFlags().BoolVar(&followSymlinks, "follow-symlinks", false, "scan symlinked directories and record symlinked files by their target's contents")
+	updateCmd.PersistentFlags().Int64Var(&minSize, "min-size", 0, "do not add files smaller than this many bytes; files already in the repository are kept as they are")
+	updateCmd.PersistentFlags().BoolVar(&skipImportDir, "skip-import-dir", false, "do not add new files found in the import directory until they are moved out of it")
+	updateCmd.PersistentFlags().Int64Var(&maxReadRate, "max-read-rate", 0, "read files at most this many bytes per second when calculating checksums, so that the disk is not saturated (default is unlimited)")
+	updateCmd.PersistentFlags().BoolVar(&savePartial, "save-partial", false, "if interrupted, save changes found so far; files not scanned yet are kept as they were")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"git.voreni.com/miki/boffin/lib"
+)
+
+// runUpdate runs the update command on the repo, the same way as it is run
+// from the command line.
+func runUpdate(t *testing.T, repo lib.Boffin, args ...string) lib.Boffin {
+	t.Helper()
+	defer func(dir string, check bool) { dbDir, checkContents = dir, check }(dbDir, checkContents)
+
+	rootCmd.SetArgs(append([]string{"--db-dir", repo.GetDbDir(), "update"}, args...))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloaded, err := lib.LoadBoffin(repo.GetDbDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return reloaded
+}
+
+func TestUpdateCheckContents(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{"a.ext": "original"})
+	path := repo.ResolvePath("a.ext")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// silently changed contents, with size and modification time as recorded
+	writeTestFile(t, path, "modified")
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	repo = runUpdate(t, repo)
+	if file := repo.GetFileByPath("a.ext"); len(file.History) != 1 {
+		t.Errorf("update: expected unchanged metadata to skip hashing, got %v", file.History)
+	}
+
+	repo = runUpdate(t, repo, "--check-contents")
+	file := repo.GetFileByPath("a.ext")
+	checksum, err := lib.CalculateChecksum(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(file.History) != 2 || file.Checksum() != checksum {
+		t.Errorf("update --check-contents: expected changed contents to be recorded, got %v", file.History)
+	}
+}
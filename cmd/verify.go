@@ -19,24 +19,52 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"git.voreni.com/miki/boffin/lib"
 	"github.com/spf13/cobra"
 )
 
+var verifyJobs int
+var verifyFixMetadata bool
+var verifyStale string
+var verifyRecord bool
+
+// verifyProgressInterval is how often progress is printed when stderr is not
+// a terminal.
+const verifyProgressInterval = 30 * time.Second
+
 // verifyCmd represents the verify command
 var verifyCmd = &cobra.Command{
-	Use:   "verify",
+	Use:   "verify [pattern]...",
 	Short: "verify integrity of all files in the repository",
-	Long:  `Verify directory for changes.`,
+	Long: `Verify directory for changes. If glob patterns are given, e.g.
+	'photos/2021/**', only files whose path matches any of them are verified.
+	Missing files whose content exists at another tracked path are reported as
+	possibly moved. Progress and estimated time left are shown on stderr.
+	Exit code is 2 if any file could not be read, 1 if any file
+	does not match its checksum or was possibly moved, and 3 if any pattern did
+	not match any file.
+
+	The repository is not modified unless asked to. With --record, time of the
+	last successful verification is recorded for every file, and the repository
+	is saved. With --stale, e.g. '30d', only files that were not verified for
+	that long, or were never verified, are verified, and verification times are
+	recorded, which spreads verification of large repositories over several
+	runs.
+
+	With --fix-metadata, files whose content matches, but modification time
+	changed, get their latest event updated with the current metadata, the
+	same way update does.`,
 	// Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if dbDir == "" {
 			var err error
-			dbDir, err = lib.FindBoffinDir(dbDir)
+			dbDir, err = lib.FindBoffinDirWithName(dbDir, dbName)
 			if err != nil {
 				log.Fatalf("ERROR: %v\n", err)
 			}
@@ -47,35 +75,104 @@ var verifyCmd = &cobra.Command{
 			log.Fatalf("ERROR: %v", err)
 		}
 
-		gotError := false
-		gotMismatch := false
+		if maxReadRate < 0 {
+			log.Fatalf("ERROR: --max-read-rate can not be negative\n")
+		}
+		lib.SetMaxReadRate(maxReadRate)
 
-		for _, file := range local.GetFiles() {
-			if file.IsDeleted() {
-				continue
-			}
-			path := filepath.Join(local.GetBaseDir(), file.Path())
-			checksum, err := lib.CalculateChecksum(path)
+		opts := &lib.VerifyOptions{
+			Workers:        verifyJobs,
+			FixMetadata:    verifyFixMetadata,
+			RecordVerified: verifyRecord || verifyStale != "",
+		}
+		if verifyStale != "" {
+			opts.StaleBefore, err = parseCutoff(verifyStale, time.Now())
 			if err != nil {
-				log.Printf("ERROR: %v", err)
-				gotError = true
-			} else if checksum != file.Checksum() {
-				log.Printf("%s: checksum does not match", file.Path())
-				gotMismatch = true
-			} else {
-				log.Printf("%s: OK", file.Path())
+				log.Fatalf("ERROR: %v\n", err)
 			}
 		}
-
-		if gotError {
-			os.Exit(2)
+		for _, pattern := range args {
+			opts.Patterns = append(opts.Patterns, repoPath(local, pattern))
+		}
+		start := time.Now()
+		if isTerminal(os.Stderr) {
+			progress := &progressLine{}
+			log.SetOutput(progress)
+			defer log.SetOutput(os.Stderr)
+			opts.Progress = func(p lib.VerifyProgress) {
+				progress.set(formatVerifyProgress(p, time.Since(start)), p.Done)
+			}
+		} else {
+			lastPrint := start
+			opts.Progress = func(p lib.VerifyProgress) {
+				if p.Done || time.Since(lastPrint) >= verifyProgressInterval {
+					stderr("%s\n", formatVerifyProgress(p, time.Since(start)))
+					lastPrint = time.Now()
+				}
+			}
+		}
+		report := lib.VerifyWithOptions(local, opts, printVerifyResult)
+		printVerifySummary(report)
+		if (report.MetadataFixed > 0 || (opts.RecordVerified && report.OK > 0)) && !dryRun {
+			if err = local.Save(); err != nil {
+				if report.MetadataFixed > 0 {
+					log.Fatalf("ERROR: %v", err)
+				}
+				// e.g. read-only media; the files were still verified
+				log.Printf("warning: verification times were not saved: %v", err)
+			}
 		}
-		if gotMismatch {
-			os.Exit(1)
+		for _, pattern := range report.UnmatchedPatterns {
+			log.Printf("ERROR: pattern '%s' did not match any file", pattern)
 		}
+
+		os.Exit(report.ExitCode())
 	},
 }
 
+func printVerifyResult(result *lib.VerifyResult) {
+	switch result.Status {
+	case lib.VerifyError:
+		log.Printf("ERROR: %v", result.Err)
+	case lib.VerifyMismatch:
+		log.Printf("%s: checksum does not match", result.File.Path())
+	case lib.VerifyInFlux:
+		log.Printf("%s: changed while being verified, skipped", result.File.Path())
+	case lib.VerifyMoved:
+		log.Printf("%s: missing, possibly moved to %s", result.File.Path(), strings.Join(result.MovedTo, ", "))
+	default:
+		if result.MetadataFixed {
+			log.Printf("%s: OK, metadata updated", result.File.Path())
+			return
+		}
+		log.Printf("%s: OK", result.File.Path())
+	}
+}
+
+// formatVerifyProgress describes the progress, with time left estimated from
+// the throughput so far.
+func formatVerifyProgress(p lib.VerifyProgress, elapsed time.Duration) string {
+	line := fmt.Sprintf("verified %d/%d files (%.1f/%.1f MB)", p.Checked, p.Total, float64(p.Bytes)/1e6, float64(p.TotalBytes)/1e6)
+	if p.Done {
+		return line + fmt.Sprintf(" in %v", elapsed.Round(time.Second))
+	}
+	if p.Bytes > 0 {
+		left := time.Duration(float64(elapsed) * float64(p.TotalBytes-p.Bytes) / float64(p.Bytes))
+		line += fmt.Sprintf(", %v left", left.Round(time.Second))
+	}
+	return line + ": " + p.Current
+}
+
+func printVerifySummary(report *lib.VerifyReport) {
+	log.Printf("%d ok, %d mismatched, %d errors, %d in flux, %d possibly moved", report.OK, report.Mismatched, report.Errors, report.InFlux, report.Moved)
+	if report.MetadataFixed > 0 {
+		log.Printf("%d files had their metadata updated", report.MetadataFixed)
+	}
+	if report.InFlux > 0 {
+		log.Printf("some files changed while being verified; run verify again to check them")
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(verifyCmd)
 
@@ -88,4 +185,9 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// verifyCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	verifyCmd.Flags().BoolVar(&verifyFixMetadata, "fix-metadata", false, "record current modification time of files whose content matches, but time changed, and save the repository")
+	verifyCmd.Flags().BoolVar(&verifyRecord, "record", false, "record time of the last successful verification of every file and save the repository")
+	verifyCmd.Flags().StringVar(&verifyStale, "stale", "", "verify only files not verified for this long, e.g. '30d' or '36h', or never verified, and record verification times")
+	verifyCmd.Flags().Int64Var(&maxReadRate, "max-read-rate", 0, "read files at most this many bytes per second, so that the disk is not saturated (default is unlimited)")
+	verifyCmd.Flags().IntVarP(&verifyJobs, "jobs", "j", 0, "number of files verified in parallel (default is the number of CPUs)")
 }
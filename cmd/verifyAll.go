@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package cmd ...
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"git.voreni.com/miki/boffin/lib"
+	"github.com/spf13/cobra"
+)
+
+// verifyAllCmd represents the verify-all command
+var verifyAllCmd = &cobra.Command{
+	Use:   "verify-all <parent-dir>",
+	Short: "verify integrity of all repositories under a directory",
+	Long: `Verify-all finds every repository under the given directory and
+	verifies integrity of all their files. Repositories nested inside another
+	repository are not searched for. Summary is printed for each repository and
+	overall, and exit code reflects the worst result of all repositories.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dbDirs, err := lib.FindBoffinDirsWithName(args[0], dbName)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		if len(dbDirs) == 0 {
+			log.Fatalf("ERROR: no repositories found under '%s'", args[0])
+		}
+
+		exit := 0
+		total := &lib.VerifyReport{}
+		failed := 0
+
+		for _, dbDir := range dbDirs {
+			repo, err := lib.LoadBoffin(dbDir)
+			if err != nil {
+				log.Printf("ERROR: %s: %v", dbDir, err)
+				failed++
+				exit = 2
+				continue
+			}
+
+			opts := &lib.VerifyOptions{
+				Workers: verifyJobs,
+			}
+			report := lib.VerifyWithOptions(repo, opts, func(result *lib.VerifyResult) {
+				if result.Status != lib.VerifyOK {
+					printVerifyResult(result)
+				}
+			})
+			fmt.Printf("%s: %d ok, %d mismatched, %d errors, %d in flux, %d possibly moved\n",
+				repo.GetBaseDir(), report.OK, report.Mismatched, report.Errors, report.InFlux, report.Moved)
+
+			total.OK += report.OK
+			total.Mismatched += report.Mismatched
+			total.Errors += report.Errors
+			total.InFlux += report.InFlux
+			total.Moved += report.Moved
+			if report.ExitCode() > exit {
+				exit = report.ExitCode()
+			}
+		}
+
+		fmt.Printf("total: %d repositories (%d failed to load), %d ok, %d mismatched, %d errors, %d in flux, %d possibly moved\n",
+			len(dbDirs), failed, total.OK, total.Mismatched, total.Errors, total.InFlux, total.Moved)
+
+		os.Exit(exit)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyAllCmd)
+
+	verifyAllCmd.Flags().IntVarP(&verifyJobs, "jobs", "j", 0, "number of files verified in parallel (default is the number of CPUs)")
+}
@@ -0,0 +1,226 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Whenever the repository is saved, the previous repo file is kept as
+// files.json.1 together with its journal as events.log.1, and older backups
+// are shifted to higher numbers. If the repo file or the journal gets
+// corrupted, LoadBoffin falls back to a backup of the corrupted file.
+
+// DefaultBackups is the number of backups kept unless set otherwise.
+const DefaultBackups = 3
+
+// GetBackups returns the number of previous repo files kept as backups.
+func (db *db) GetBackups() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.backups == nil {
+		return DefaultBackups
+	}
+	return *db.backups
+}
+
+// SetBackups sets the number of previous repo files kept as backups; zero
+// disables backups.
+func (db *db) SetBackups(n int) {
+	if n < 0 {
+		n = 0
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.backups = &n
+}
+
+func backupName(filename string, i int) string {
+	return fmt.Sprintf("%s.%d", filename, i)
+}
+
+// backupNumbers returns numbers of existing backups of the file in dbDir,
+// newest first.
+func backupNumbers(dbDir, filename string) []int {
+	matches, _ := filepath.Glob(filepath.Join(dbDir, filename+".*"))
+	numbers := []int{}
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(match), filename+"."))
+		if err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// rotateBackups keeps the repo file and its journal as the first backup,
+// shifting existing backups and removing the ones over the limit. The files
+// are moved if move is set, as they are about to be replaced, and copied
+// otherwise, as the journal is about to be appended to. Must be called with
+// the lock held.
+func (db *db) rotateBackups(move bool) error {
+	count := DefaultBackups
+	if db.backups != nil {
+		count = *db.backups
+	}
+
+	for _, filename := range []string{filesFilename, journalFilename} {
+		numbers := backupNumbers(db.dbDir, filename)
+		for i := len(numbers) - 1; i >= 0; i-- {
+			n := numbers[i]
+			path := filepath.Join(db.dbDir, backupName(filename, n))
+			if n >= count {
+				if err := os.Remove(path); err != nil {
+					return fmt.Errorf("failed to remove old backup: %v", err)
+				}
+				continue
+			}
+			if err := os.Rename(path, filepath.Join(db.dbDir, backupName(filename, n+1))); err != nil {
+				return fmt.Errorf("failed to rotate backups: %v", err)
+			}
+		}
+	}
+
+	filename := filepath.Join(db.dbDir, filesFilename)
+	if count == 0 {
+		if !move {
+			return nil
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to overwrite '%s'", filename)
+		}
+		return nil
+	}
+	// the journal belongs to the backed up repo file; if there is none, the
+	// backup simply has no journal
+	journal := filepath.Join(db.dbDir, journalFilename)
+	for _, path := range []string{filename, journal} {
+		var err error
+		if move {
+			err = os.Rename(path, backupName(path, 1))
+		} else {
+			err = copyBackup(path, backupName(path, 1))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to back up '%s': %v", path, err)
+		}
+	}
+	return nil
+}
+
+// copyBackup copies the file to the backup, keeping it read only.
+func copyBackup(path, backup string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(backup)
+		return err
+	}
+	return out.Close()
+}
+
+// loadBackup loads the repository when the repo file or its journal is
+// corrupted, replacing only the file which is corrupted. A corrupted journal
+// is replaced by the newest backup which applies to the repo file, or dropped
+// if there is none. A corrupted repo file is replaced by a backup with the
+// same contents, so that the journal still applies, or else the newest backup
+// is loaded together with its own journal. The repository is set up to
+// replace the current repo file and journal on next save.
+func loadBackup(dbDir string) (*db, error) {
+	var retval *db
+	if _, err := loadRepoFile(dbDir, filesFilename); err == nil {
+		// only the journal is corrupted
+		for _, n := range backupNumbers(dbDir, journalFilename) {
+			if retval, err = loadStrict(dbDir, filesFilename, backupName(journalFilename, n)); err == nil {
+				break
+			}
+		}
+		if retval == nil {
+			if retval, err = loadRepoFile(dbDir, filesFilename); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, n := range backupNumbers(dbDir, filesFilename) {
+			if retval, err = loadStrict(dbDir, backupName(filesFilename, n), journalFilename); err == nil {
+				break
+			}
+		}
+		if retval == nil {
+			for _, n := range backupNumbers(dbDir, filesFilename) {
+				if retval, err = loadRepoFiles(dbDir, backupName(filesFilename, n), backupName(journalFilename, n)); err == nil {
+					break
+				}
+			}
+		}
+		if retval == nil {
+			return nil, fmt.Errorf("no valid backup found")
+		}
+	}
+
+	// pretend that the current files were loaded, so that saving is not
+	// refused, and force the whole repo file to be written
+	for _, current := range []struct {
+		filename string
+		checksum *string
+	}{
+		{filesFilename, &retval.fileChecksum},
+		{journalFilename, &retval.journalChecksum},
+	} {
+		checksum, err := CalculateChecksum(filepath.Join(dbDir, current.filename))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		*current.checksum = checksum
+	}
+	retval.persisted = nil
+	return retval, nil
+}
+
+// loadStrict loads the repo file together with the journal, which must exist
+// and apply to it.
+func loadStrict(dbDir, filesName, journalName string) (*db, error) {
+	retval, err := loadRepoFile(dbDir, filesName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(filepath.Join(dbDir, journalName)); err != nil {
+		return nil, err
+	}
+	if err = retval.replayJournal(filepath.Join(dbDir, journalName)); err != nil {
+		return nil, err
+	}
+	return retval, nil
+}
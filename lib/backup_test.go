@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// every compaction adds a file and rotates backups
+	for i, path := range []string{"a.ext", "b.ext", "c.ext", "d.ext", "e.ext"} {
+		boffin.AddFile(&FileInfo{History: []*FileEvent{
+			&FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum(path)},
+		}})
+		if i%2 == 0 {
+			// leave some changes in the journal, which is backed up along
+			if err = boffin.Save(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if err = boffin.Compact(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err = boffin.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for n := 1; n <= DefaultBackups; n++ {
+		if _, err := os.Stat(filepath.Join(dbDir, backupName(filesFilename, n))); err != nil {
+			t.Errorf("backup %d: %v", n, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dbDir, backupName(filesFilename, DefaultBackups+1))); !os.IsNotExist(err) {
+		t.Errorf("expected only %d backups, got %v", DefaultBackups, err)
+	}
+
+	// corrupt the repo file; newest backup has all files, as the journal is
+	// backed up with it
+	filename := filepath.Join(dbDir, filesFilename)
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.WriteFile(filename, []byte(`{"v2": {"files": [`), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("LoadBoffin: expected fallback to backup, got %v", err)
+	}
+	if len(loaded.GetFiles()) != 5 {
+		t.Errorf("LoadBoffin: expected 5 files from backup, got %d", len(loaded.GetFiles()))
+	}
+
+	// saving the recovered repository replaces the corrupted file
+	if err = loaded.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded, err = LoadBoffin(dbDir); err != nil || len(loaded.GetFiles()) != 5 {
+		t.Errorf("LoadBoffin: expected recovered repository, got %v", err)
+	}
+
+	// without backups, corruption is reported
+	loaded.SetBackups(0)
+	if err = loaded.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if numbers := backupNumbers(dbDir, filesFilename); len(numbers) != 0 {
+		t.Errorf("expected backups to be removed, got %v", numbers)
+	}
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.WriteFile(filename, []byte(`{"v2": {"files": [`), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = LoadBoffin(dbDir); err == nil {
+		t.Errorf("LoadBoffin: expected error without backups")
+	}
+}
+
+func TestBackupsCorruptedFile(t *testing.T) {
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		dbDir := ConstuctDbPath(dir)
+		boffin, err := InitDbDir(dbDir, dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = boffin.Compact(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// every save appends to the journal and rotates backups
+		for _, path := range []string{"a.ext", "b.ext", "c.ext"} {
+			boffin.AddFile(&FileInfo{History: []*FileEvent{
+				&FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum(path)},
+			}})
+			if err = boffin.Save(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if _, err = os.Stat(filepath.Join(dbDir, backupName(journalFilename, 1))); err != nil {
+			t.Fatalf("expected journal to be backed up on save: %v", err)
+		}
+		return dbDir
+	}
+	corrupt := func(t *testing.T, filename string) {
+		if err := os.Chmod(filename, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(filename, []byte(`{"op": `), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	t.Run("repo file", func(t *testing.T) {
+		dbDir := setup(t)
+		corrupt(t, filepath.Join(dbDir, filesFilename))
+
+		// the backup has the same contents, so the journal still applies
+		loaded, err := LoadBoffin(dbDir)
+		if err != nil {
+			t.Fatalf("LoadBoffin: expected fallback to backup, got %v", err)
+		}
+		if len(loaded.GetFiles()) != 3 {
+			t.Errorf("LoadBoffin: expected 3 files, got %d", len(loaded.GetFiles()))
+		}
+	})
+
+	t.Run("journal", func(t *testing.T) {
+		dbDir := setup(t)
+		corrupt(t, filepath.Join(dbDir, journalFilename))
+
+		// the repo file is kept, with the journal as it was before last save
+		loaded, err := LoadBoffin(dbDir)
+		if err != nil {
+			t.Fatalf("LoadBoffin: expected fallback to backup, got %v", err)
+		}
+		if len(loaded.GetFiles()) != 2 {
+			t.Errorf("LoadBoffin: expected 2 files, got %d", len(loaded.GetFiles()))
+		}
+		if err = loaded.Save(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if loaded, err = LoadBoffin(dbDir); err != nil || len(loaded.GetFiles()) != 2 {
+			t.Errorf("LoadBoffin: expected recovered repository, got %v", err)
+		}
+	})
+}
@@ -18,16 +18,28 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -44,47 +56,104 @@ type FileEvent struct {
 	Size     int64     `json:"size,omitempty"`
 	Time     time.Time `json:"time"`
 	Checksum string    `json:"checksum,omitempty"`
+	// Algorithm used to calculate the checksum; empty means sha256, which was
+	// the only algorithm before it became configurable.
+	Algorithm HashAlgorithm `json:"algorithm,omitempty"`
+	// Mode holds the permission bits of the file; zero if they were not
+	// recorded, as in repos created before the mode was tracked.
+	Mode os.FileMode `json:"mode,omitempty"`
+	Note string      `json:"note,omitempty"`
+	// Origin of the repository the event was imported from, if it had one.
+	Origin string `json:"origin,omitempty"`
+}
+
+// HashAlgorithm returns the algorithm used to calculate the event checksum.
+func (e *FileEvent) HashAlgorithm() HashAlgorithm {
+	if e.Algorithm == "" {
+		return DefaultHashAlgorithm
+	}
+	return e.Algorithm
 }
 
 // FileInfo ...
 type FileInfo struct {
 	History []*FileEvent `json:"history,omitempty"`
+	// LastVerified is when Verify last confirmed that the contents match the
+	// checksum; nil if it never did. It is not part of the history, so it
+	// does not affect matching the file in Diff.
+	LastVerified *time.Time `json:"last-verified,omitempty"`
+}
+
+// CurrentEvent returns the event describing the current version of the file,
+// or nil if the file is deleted.
+func (fi *FileInfo) CurrentEvent() *FileEvent {
+	if fi.IsDeleted() {
+		return nil
+	}
+	return fi.History[len(fi.History)-1]
+}
+
+// lastVersion returns the current event, or for deleted files the event of the
+// version before it was deleted. Returns nil if there never was any content.
+func (fi *FileInfo) lastVersion() *FileEvent {
+	if event := fi.CurrentEvent(); event != nil {
+		return event
+	}
+	for i := len(fi.History) - 1; i >= 0; i-- {
+		if fi.History[i].Checksum != "" {
+			return fi.History[i]
+		}
+	}
+	return nil
 }
 
-// Checksum ...
+// Checksum returns the checksum of the current version, or an empty string if
+// the file is deleted.
 func (fi *FileInfo) Checksum() string {
-	return fi.History[len(fi.History)-1].Checksum
+	if event := fi.CurrentEvent(); event != nil {
+		return event.Checksum
+	}
+	return ""
+}
+
+// HashAlgorithm returns the algorithm used to calculate the current checksum.
+func (fi *FileInfo) HashAlgorithm() HashAlgorithm {
+	if event := fi.CurrentEvent(); event != nil {
+		return event.HashAlgorithm()
+	}
+	return DefaultHashAlgorithm
 }
 
-// Path ...
+// Path returns the path of the current version; deleted files keep the path
+// they had before they were deleted.
 func (fi *FileInfo) Path() string {
-	for i := range fi.History {
-		event := fi.History[len(fi.History)-1-i]
-		if event.Checksum != "" {
-			return event.Path
-		}
+	if event := fi.lastVersion(); event != nil {
+		return event.Path
 	}
 	return ""
 }
 
 // Size ...
 func (fi *FileInfo) Size() int64 {
-	for i := range fi.History {
-		event := fi.History[len(fi.History)-1-i]
-		if event.Checksum != "" {
-			return event.Size
-		}
+	if event := fi.lastVersion(); event != nil {
+		return event.Size
+	}
+	return 0
+}
+
+// Mode returns the permission bits of the current version of the file, or
+// zero if they were not recorded.
+func (fi *FileInfo) Mode() os.FileMode {
+	if event := fi.lastVersion(); event != nil {
+		return event.Mode
 	}
 	return 0
 }
 
 // Time ...
 func (fi *FileInfo) Time() time.Time {
-	for i := range fi.History {
-		event := fi.History[len(fi.History)-1-i]
-		if event.Checksum != "" {
-			return event.Time
-		}
+	if event := fi.lastVersion(); event != nil {
+		return event.Time
 	}
 	return time.Time{}
 }
@@ -97,6 +166,109 @@ func (fi *FileInfo) IsDeleted() bool {
 	return fi.History[len(fi.History)-1].Checksum == ""
 }
 
+// clone returns a deep copy of the file, so that it can be modified without
+// affecting the original.
+func (fi *FileInfo) clone() *FileInfo {
+	history := make([]*FileEvent, 0, len(fi.History))
+	for _, event := range fi.History {
+		e := *event
+		history = append(history, &e)
+	}
+	return &FileInfo{
+		History:      history,
+		LastVerified: fi.LastVerified,
+	}
+}
+
+// MergeHistory merges two file histories into one ordered by event time.
+// Events with the same checksum and time are considered duplicates and only
+// the first one is kept, preferring events from history a. Events of history b
+// are copied, so that the two histories never share events, e.g. when they
+// belong to different repositories.
+func MergeHistory(a, b []*FileEvent) []*FileEvent {
+	events := append([]*FileEvent{}, a...)
+	for _, event := range b {
+		e := *event
+		events = append(events, &e)
+	}
+
+	merged := make([]*FileEvent, 0, len(a)+len(b))
+	for _, event := range events {
+		duplicate := false
+		for _, other := range merged {
+			if event.Checksum == other.Checksum && event.Time.Equal(other.Time) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, event)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+
+	return merged
+}
+
+// CollapseHistory collapses consecutive events of the same file which have
+// the same checksum and path, i.e. only record changes of metadata, into one
+// event. Content changes, renames and deletions are kept. Collapsed event has
+// the time of the first event, when the content first appeared at the path,
+// except for the current version, which keeps the latest metadata so that it
+// still matches the file on disk. Returns the number of removed events; the
+// repository is modified, but not saved.
+func CollapseHistory(repo Boffin) int {
+	removed := 0
+	files := repo.GetFiles()
+	for i, file := range files {
+		history := collapseEvents(file.History)
+		if len(history) == len(file.History) {
+			continue
+		}
+		removed += len(file.History) - len(history)
+		files[i] = &FileInfo{History: history, LastVerified: file.LastVerified}
+	}
+	if removed > 0 {
+		repo.SetFiles(files)
+	}
+	return removed
+}
+
+func collapseEvents(history []*FileEvent) []*FileEvent {
+	collapsed := make([]*FileEvent, 0, len(history))
+	for i, event := range history {
+		if i > 0 {
+			prev := history[i-1]
+			if event.Checksum != "" && event.Checksum == prev.Checksum &&
+				event.HashAlgorithm() == prev.HashAlgorithm() && event.Path == prev.Path {
+				// events may be shared with other files, so update a copy
+				e := *collapsed[len(collapsed)-1]
+				e.Size = event.Size
+				e.Mode = event.Mode
+				if i == len(history)-1 {
+					e.Time = event.Time
+				}
+				collapsed[len(collapsed)-1] = &e
+				continue
+			}
+		}
+		collapsed = append(collapsed, event)
+	}
+	return collapsed
+}
+
+// DeletedTime returns the time the file was deleted, or zero time if the file
+// is not deleted.
+func (fi *FileInfo) DeletedTime() time.Time {
+	if len(fi.History) == 0 || !fi.IsDeleted() {
+		return time.Time{}
+	}
+	return fi.History[len(fi.History)-1].Time
+}
+
 // MarkDeleted ...
 func (fi *FileInfo) MarkDeleted() {
 	if !fi.IsDeleted() {
@@ -114,25 +286,66 @@ func (fi *FileInfo) MarkDeleted() {
 // 88.  .88 88.  .88
 // `88888P8 88Y8888'
 
-// Boffin ...
+// Boffin is the repository of tracked files.
+//
+// All methods are safe for concurrent use. GetFiles and GetFileByPath return
+// files shared with the repository; Update never modifies them in place, but
+// works on copies which replace the originals once complete. Files added or
+// removed while Update is running are kept that way. Other callers that
+// modify returned files (e.g. import) must not do so while the repository is
+// being read or updated concurrently.
 type Boffin interface {
 	GetFiles() []*FileInfo
+	GetFileByPath(path string) *FileInfo
+	FindByChecksum(checksum string) []*FileInfo
+	FindByHistoricalChecksum(checksum string) []*FileInfo
 	AddFile(file *FileInfo)
+	SetFiles(files []*FileInfo)
+	PruneDeleted(olderThan time.Time) int
 
 	GetDbDir() string
 	GetBaseDir() string
+	GetRelBaseDir() string
 	GetImportDir() string
 	GetRelImportDir() string
+	SetBaseDir(baseDir string) error
+	GetRoots() map[string]string
+	AddRoot(name, dir string) error
+	ResolvePath(path string) string
+
+	IsAppendOnly() bool
+	SetAppendOnly(appendOnly bool)
+	GetTimezone() string
+	SetTimezone(name string) error
+	GetBackups() int
+	SetBackups(n int)
+	GetOrigin() string
+	SetOrigin(origin string)
+	IsCompressed() bool
+	SetCompressed(compress bool)
+
+	DiffAgainst(remote Boffin, action DiffAction) error
+	DiffAgainstWithOptions(remote Boffin, action DiffAction, opts *DiffOptions) error
 
 	Save() error
+	Compact() error
+	Export(w io.Writer) error
 }
 
+// ignorePattern matches paths which are not tracked. Patterns stored in the
+// repo file are regular expressions matched against paths in the repo, while
+// patterns from ignore files are gitignore-style globs; see
+// parseIgnorePattern.
 type ignorePattern struct {
 	pattern string
 	re      *regexp.Regexp
 	used    bool
+	negate  bool // paths matched are not ignored, even if matched before
+	dirOnly bool
 }
 
+// ignore is a list of patterns. Like in gitignore, the last matching pattern
+// decides if the path is ignored.
 type ignore []ignorePattern
 
 func compileIgnorePatterns(patterns []string) ignore {
@@ -164,15 +377,47 @@ func (i ignore) getPatternSlice() []string {
 }
 
 type db struct {
+	// mu guards files and settings that can be changed after loading
+	mu sync.RWMutex
+
 	dbDir        string
 	absBaseDir   string
 	absImportDir string
-
-	ignore ignore
+	absRoots     map[string]string
+
+	ignore     ignore
+	appendOnly bool
+	timezone   *time.Location
+	backups    *int
+	origin     string
+	compress   bool
+
+	// lookup of files by checksum, built on first use and dropped whenever
+	// files are added or replaced
+	checksums *checksumIndex
+
+	// checksums of the repo file and the journal as they were when loaded or
+	// last saved; used to detect if another process changed them in the
+	// meantime
+	fileChecksum    string
+	journalChecksum string
+
+	// integrity of the repo file; identifies the journal that applies to it
+	integrity string
+	// integrity of the last journal record, which the next record chains to
+	journalIntegrity string
+	// settings as they were saved; any change requires rewriting the repo file
+	savedSettings string
+	// number of files with each history key, as saved; nil if the repo file
+	// must be rewritten on next save
+	persisted map[string]int
+	// last verification time of files with each history key, as saved
+	persistedVerified map[string]time.Time
 
 	// this is simply kept for saving purposes
 	baseDir   string
 	importDir string
+	roots     []*rootStruct
 	files     []*FileInfo
 }
 
@@ -186,6 +431,12 @@ func (db *db) GetBaseDir() string {
 	return db.absBaseDir
 }
 
+// GetRelBaseDir returns the base dir as saved, which is relative to the db dir
+// unless it is absolute.
+func (db *db) GetRelBaseDir() string {
+	return db.baseDir
+}
+
 // GetImportDir ...
 func (db *db) GetImportDir() string {
 	return db.absImportDir
@@ -196,14 +447,274 @@ func (db *db) GetRelImportDir() string {
 	return db.importDir
 }
 
+// SetBaseDir points the repository to a new base dir, e.g. after it was moved
+// to a different mount point. Import dir inside the old base dir is moved
+// along with it.
+func (db *db) SetBaseDir(baseDir string) error {
+	baseDir, err := cleanPath(baseDir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(baseDir)
+	if err != nil {
+		return fmt.Errorf("'%s' does not exist", baseDir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", baseDir)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if filepath.IsAbs(db.importDir) {
+		if rel, err := filepath.Rel(db.absBaseDir, db.absImportDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			db.importDir = filepath.Join(baseDir, rel)
+		}
+	}
+	dbDir, err := cleanPath(db.dbDir)
+	if err != nil {
+		return err
+	}
+	db.baseDir = relBaseDir(dbDir, baseDir)
+	return db.resolveDirs()
+}
+
+// IsAppendOnly ...
+func (db *db) IsAppendOnly() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.appendOnly
+}
+
+// SetAppendOnly ...
+func (db *db) SetAppendOnly(appendOnly bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.appendOnly = appendOnly
+}
+
+// GetTimezone returns the name of the timezone used when saving times, or an
+// empty string if times are saved in UTC.
+func (db *db) GetTimezone() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.timezone == nil {
+		return ""
+	}
+	return db.timezone.String()
+}
+
+// SetTimezone sets the timezone used when saving times. This affects only how
+// times are formatted in the repo file, not the times themselves. Empty name
+// resets it to UTC.
+func (db *db) SetTimezone(name string) error {
+	var loc *time.Location
+	if name != "" {
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return fmt.Errorf("invalid timezone '%s': %v", name, err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.timezone = loc
+	return nil
+}
+
+// GetOrigin returns the origin of the repository, e.g. 'phone', or an empty
+// string if none was set.
+func (db *db) GetOrigin() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.origin
+}
+
+// SetOrigin sets the origin of the repository.
+func (db *db) SetOrigin(origin string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.origin = origin
+}
+
+// IsCompressed returns true if the repo file is saved gzip compressed.
+func (db *db) IsCompressed() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.compress
+}
+
+// SetCompressed sets whether the repo file is saved gzip compressed. The repo
+// file is loaded the same either way.
+func (db *db) SetCompressed(compress bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.compress = compress
+}
+
 // GetFiles ...
 func (db *db) GetFiles() []*FileInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return append([]*FileInfo{}, db.files...)
 }
 
+// GetFileByPath returns the file currently at the given path. If there is no
+// such file, the most recently deleted file that was last seen at the path is
+// returned instead, or nil if the path is unknown.
+func (db *db) GetFileByPath(path string) *FileInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var deleted *FileInfo
+	for _, file := range db.files {
+		if file.Path() != path {
+			continue
+		}
+		if !file.IsDeleted() {
+			return file
+		}
+		if deleted == nil || deleted.History[len(deleted.History)-1].Time.Before(file.History[len(file.History)-1].Time) {
+			deleted = file
+		}
+	}
+	return deleted
+}
+
+// checksumIndex maps checksums to files with that content, either currently
+// or at any point in their history.
+type checksumIndex struct {
+	current    map[string][]*FileInfo
+	historical map[string][]*FileInfo
+}
+
+func newChecksumIndex(files []*FileInfo) *checksumIndex {
+	index := &checksumIndex{
+		current:    map[string][]*FileInfo{},
+		historical: map[string][]*FileInfo{},
+	}
+	for _, file := range files {
+		if !file.IsDeleted() {
+			checksum := file.Checksum()
+			index.current[checksum] = append(index.current[checksum], file)
+		}
+		seen := map[string]bool{}
+		for _, event := range file.History {
+			if event.Checksum != "" && !seen[event.Checksum] {
+				seen[event.Checksum] = true
+				index.historical[event.Checksum] = append(index.historical[event.Checksum], file)
+			}
+		}
+	}
+	return index
+}
+
+// FindByChecksum returns all live files whose current content has the given
+// checksum. Changes made to file histories directly are seen only after the
+// files are added or replaced, or the repository is saved.
+func (db *db) FindByChecksum(checksum string) []*FileInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.checksums == nil {
+		db.checksums = newChecksumIndex(db.files)
+	}
+	return append([]*FileInfo{}, db.checksums.current[checksum]...)
+}
+
+// FindByHistoricalChecksum returns all files, including deleted ones, that
+// had the content with the given checksum at any point in their history; see
+// FindByChecksum.
+func (db *db) FindByHistoricalChecksum(checksum string) []*FileInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.checksums == nil {
+		db.checksums = newChecksumIndex(db.files)
+	}
+	return append([]*FileInfo{}, db.checksums.historical[checksum]...)
+}
+
 // AddFile ...
 func (db *db) AddFile(file *FileInfo) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	db.files = append(db.files, file)
+	db.checksums = nil
+}
+
+// applyUpdate replaces the originals with their updated copies; updated has
+// the copies in the same order as originals, followed by new files. Originals
+// no longer in the repository were removed while the update was running, so
+// their copies are dropped, while files added meanwhile are kept.
+func (db *db) applyUpdate(originals, updated []*FileInfo) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	copies := make(map[*FileInfo]*FileInfo, len(originals))
+	for i, original := range originals {
+		copies[original] = updated[i]
+	}
+	files := make([]*FileInfo, 0, len(db.files)+len(updated)-len(originals))
+	for _, file := range db.files {
+		if updatedFile, ok := copies[file]; ok {
+			file = updatedFile
+		}
+		files = append(files, file)
+	}
+	db.files = append(files, updated[len(originals):]...)
+	db.checksums = nil
+}
+
+// SetFiles replaces all files in the repository.
+func (db *db) SetFiles(files []*FileInfo) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.files = append([]*FileInfo{}, files...)
+	db.checksums = nil
+}
+
+// PruneDeleted permanently removes files that were deleted before olderThan,
+// and returns the number of removed files. Deleted files that share any
+// checksum with a live file are kept, as their history is needed to match the
+// live file with copies in other repos.
+func (db *db) PruneDeleted(olderThan time.Time) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	live := map[string]bool{}
+	for _, file := range db.files {
+		if !file.IsDeleted() {
+			for _, event := range file.History {
+				if event.Checksum != "" {
+					live[event.checksumKey()] = true
+				}
+			}
+		}
+	}
+
+	files := make([]*FileInfo, 0, len(db.files))
+	removed := 0
+	for _, file := range db.files {
+		if file.IsDeleted() && file.DeletedTime().Before(olderThan) && !file.sharesChecksum(live) {
+			removed++
+			continue
+		}
+		files = append(files, file)
+	}
+	db.files = files
+	db.checksums = nil
+
+	return removed
+}
+
+// sharesChecksum returns true if any event of the file has one of the given
+// checksum keys.
+func (fi *FileInfo) sharesChecksum(keys map[string]bool) bool {
+	for _, event := range fi.History {
+		if event.Checksum != "" && keys[event.checksumKey()] {
+			return true
+		}
+	}
+	return false
 }
 
 func cleanPath(dir string) (string, error) {
@@ -221,13 +732,34 @@ func cleanPath(dir string) (string, error) {
 // 88        88.  .88 88.  .88 88.  .88  d8'     d8'   .8P 88.  .88 88 .88'  88.  ...
 // 88888888P `88888P' `88888P8 `88888P8 88        Y88888P  `88888P8 8888P'   `88888P'
 
-const defaultDbDir = ".boffin"
+// DefaultDbDirName is the name of the db directory, unless a different one is
+// requested. Using different names allows independent repositories of the
+// same base dir.
+const DefaultDbDirName = ".boffin"
 const filesFilename = "files.json"
 const newFilesFilename = "files.json.tmp"
 
+// gzipMagic starts every gzip stream; see RFC 1952.
+var gzipMagic = []byte{0x1f, 0x8b}
+
 type jsonStruct struct {
-	V1 *v1Struct `json:"v1,omitempty"`
-	V2 *v2Struct `json:"v2,omitempty"`
+	// Integrity is the checksum of the repository data, used to detect
+	// manual edits and corruption of the file.
+	Integrity string    `json:"integrity,omitempty"`
+	V1        *v1Struct `json:"v1,omitempty"`
+	V2        *v2Struct `json:"v2,omitempty"`
+}
+
+// integrityChecksum returns the checksum of the versioned repository data.
+// Data is hashed in its compact form so that formatting of the file does not
+// matter.
+func (j *jsonStruct) integrityChecksum() (string, error) {
+	data, err := json.Marshal(&jsonStruct{V1: j.V1, V2: j.V2})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
 }
 
 type v1Struct struct {
@@ -237,10 +769,20 @@ type v1Struct struct {
 }
 
 type v2Struct struct {
-	BaseDir   string      `json:"base-dir"`
-	ImportDir string      `json:"import-dir"`
-	Ignore    []string    `json:"ignore"`
-	Files     []*FileInfo `json:"files"`
+	BaseDir    string        `json:"base-dir"`
+	ImportDir  string        `json:"import-dir"`
+	Roots      []*rootStruct `json:"roots,omitempty"`
+	Ignore     []string      `json:"ignore"`
+	AppendOnly bool          `json:"append-only,omitempty"`
+	Timezone   string        `json:"timezone,omitempty"`
+	// number of previous repo files kept; nil means DefaultBackups
+	Backups *int `json:"backups,omitempty"`
+	// name of the device or collection the repository belongs to, recorded
+	// with events imported from it
+	Origin string `json:"origin,omitempty"`
+	// repo file is written gzip compressed
+	Compress bool        `json:"compress,omitempty"`
+	Files    []*FileInfo `json:"files"`
 }
 
 // InitDbDir ...
@@ -273,13 +815,7 @@ func InitDbDir(dbDir, baseDir string) (Boffin, error) {
 	db := &db{
 		dbDir:      dbDir,
 		absBaseDir: baseDir,
-	}
-
-	if relDir, err := filepath.Rel(dbDir, baseDir); err == nil {
-		// if we can deduce relative path, use it instead of absolute one
-		db.baseDir = relDir
-	} else {
-		db.baseDir = baseDir
+		baseDir:    relBaseDir(dbDir, baseDir),
 	}
 
 	if err = db.Save(); err != nil {
@@ -289,21 +825,137 @@ func InitDbDir(dbDir, baseDir string) (Boffin, error) {
 	return db, nil
 }
 
-// Save ...
+// relBaseDir returns the path to the base dir as saved in the repo file.
+func relBaseDir(dbDir, baseDir string) string {
+	if relDir, err := filepath.Rel(dbDir, baseDir); err == nil {
+		// if we can deduce relative path, use it instead of absolute one
+		return relDir
+	}
+	return baseDir
+}
+
+// Save writes changes made since the repository was loaded or last saved.
+// Usually only the changes are appended to the journal, but the whole repo
+// file is written when settings change.
 func (db *db) Save() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.dbDir == "" {
+		return fmt.Errorf("repository was not loaded from a db dir and can not be saved")
+	}
+
 	sort.Slice(db.files, func(i, j int) bool {
 		return db.files[i].Path() < db.files[j].Path()
 	})
+	// saved changes could have been made to the files directly
+	db.checksums = nil
+
+	if db.persisted == nil || db.settings() != db.savedSettings {
+		return db.saveSnapshot()
+	}
+	return db.appendJournal()
+}
+
+// settings returns all saved settings of the repository, in a form that can be
+// compared.
+func (db *db) settings() string {
+	timezone := ""
+	if db.timezone != nil {
+		timezone = db.timezone.String()
+	}
+	data, _ := json.Marshal(&v2Struct{
+		BaseDir:    db.baseDir,
+		ImportDir:  db.importDir,
+		Roots:      db.roots,
+		Ignore:     db.ignore.getPatternSlice(),
+		AppendOnly: db.appendOnly,
+		Timezone:   timezone,
+		Backups:    db.backups,
+		Origin:     db.origin,
+		Compress:   db.compress,
+	})
+	return string(data)
+}
+
+// checkUnchanged returns an error if another process saved the repository
+// since it was loaded or last saved.
+func (db *db) checkUnchanged() error {
+	for _, saved := range []struct{ filename, checksum string }{
+		{filesFilename, db.fileChecksum},
+		{journalFilename, db.journalChecksum},
+	} {
+		filename := filepath.Join(db.dbDir, saved.filename)
+		checksum, err := repoFileChecksum(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if checksum != saved.checksum {
+			return fmt.Errorf("'%s' was modified since it was loaded; reload the repository and try again", filename)
+		}
+	}
+	return nil
+}
+
+// repoFileChecksum returns the sha256 checksum of one of the repository's own
+// files. Unlike CalculateChecksum, it is not subject to the read rate limit,
+// so that saving is never slowed down by it.
+func repoFileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// saveSnapshot writes all files into a new repo file and removes the journal.
+func (db *db) saveSnapshot() error {
+	files := db.files
+	timezone := ""
+	if db.timezone != nil {
+		// format times in the requested timezone; copy the files to avoid
+		// changing the times held in memory
+		timezone = db.timezone.String()
+		files = make([]*FileInfo, 0, len(db.files))
+		for _, file := range db.files {
+			file = file.clone()
+			for _, event := range file.History {
+				event.Time = event.Time.In(db.timezone)
+			}
+			files = append(files, file)
+		}
+	}
 
 	rawJSON := &jsonStruct{
 		V2: &v2Struct{
-			BaseDir: db.baseDir,
-			Ignore:  db.ignore.getPatternSlice(),
-			Files:   db.files,
+			BaseDir:    db.baseDir,
+			ImportDir:  db.importDir,
+			Roots:      db.roots,
+			Ignore:     db.ignore.getPatternSlice(),
+			AppendOnly: db.appendOnly,
+			Timezone:   timezone,
+			Backups:    db.backups,
+			Origin:     db.origin,
+			Compress:   db.compress,
+			Files:      files,
 		},
 	}
+	integrity, err := rawJSON.integrityChecksum()
+	if err != nil {
+		return err
+	}
+	rawJSON.Integrity = integrity
 
 	newFilename := filepath.Join(db.dbDir, newFilesFilename)
+	newChecksum := ""
 	defer func() {
 		_ = os.Remove(newFilename) // cleanup; will work only if the old file could not be replaced
 	}()
@@ -317,7 +969,14 @@ func (db *db) Save() error {
 			_ = file.Close()
 		}()
 
-		encoder := json.NewEncoder(file)
+		hash := sha256.New()
+		var w io.Writer = io.MultiWriter(file, hash)
+		var compressed *gzip.Writer
+		if db.compress {
+			compressed = gzip.NewWriter(w)
+			w = compressed
+		}
+		encoder := json.NewEncoder(w)
 		if encoder == nil {
 			return fmt.Errorf("failed to create json encoder")
 		}
@@ -326,17 +985,29 @@ func (db *db) Save() error {
 		if err = encoder.Encode(rawJSON); err != nil {
 			return err
 		}
+		if compressed != nil {
+			if err = compressed.Close(); err != nil {
+				return err
+			}
+		}
+		newChecksum = base64.StdEncoding.EncodeToString(hash.Sum(nil))
 	}
 
 	{ // now replace old file with the new one
 		filename := filepath.Join(db.dbDir, filesFilename)
 
-		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to overwrite '%s'", filename)
+		// refuse to overwrite changes saved by someone else since we loaded
+		if err := db.checkUnchanged(); err != nil {
+			return err
+		}
+
+		if err := db.rotateBackups(true); err != nil {
+			return err
 		}
 		if err := os.Rename(newFilename, filename); err != nil {
 			return fmt.Errorf("critical error; failed to rename '%s' to '%s'", newFilename, filename)
 		}
+		db.fileChecksum = newChecksum
 
 		fi, err := os.Stat(filename)
 		if err == nil {
@@ -347,12 +1018,113 @@ func (db *db) Save() error {
 		}
 	}
 
+	// all changes from the journal are now in the repo file; if this fails
+	// the journal is ignored on next load as it applies to the old repo file
+	journal := filepath.Join(db.dbDir, journalFilename)
+	if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+		log.Printf("warning: failed to remove '%s': %v", journal, err)
+	} else {
+		db.journalChecksum = ""
+	}
+	db.integrity = integrity
+	db.savedSettings = db.settings()
+	db.markPersisted()
+
 	return nil
 }
 
-// LoadBoffin ...
+// LoadBoffin loads the repository from dbDir. If the repo file or its journal
+// can not be parsed, a valid backup of the corrupted file is loaded instead and
+// a warning is logged; saving such repository replaces the corrupted files. Repo file which
+// parses, but fails the integrity check, e.g. after editing by hand, is
+// reported as error.
 func LoadBoffin(dbDir string) (Boffin, error) {
-	boffinPath := filepath.Join(dbDir, filesFilename)
+	retval, err := loadRepoFiles(dbDir, filesFilename, journalFilename)
+	if err != nil {
+		var corrupted *corruptedError
+		if !errors.As(err, &corrupted) {
+			return nil, err
+		}
+		backup, backupErr := loadBackup(dbDir)
+		if backupErr != nil {
+			return nil, err
+		}
+		log.Printf("warning: %v; loaded a valid backup instead", err)
+		retval = backup
+	}
+
+	if err = retval.resolveDirs(); err != nil {
+		return nil, err
+	}
+
+	return retval, nil
+}
+
+// LoadBoffinFrom loads the repository data, as stored in the repo file, from
+// r instead of the db dir, and uses baseDir as its base directory. Relative
+// import and root dirs are resolved against baseDir and the current directory
+// respectively. The repository has no db dir, so it can be inspected and
+// diffed, but not saved.
+func LoadBoffinFrom(r io.Reader, baseDir string) (Boffin, error) {
+	retval, err := loadDbFrom(r, "", "input")
+	if err != nil {
+		return nil, err
+	}
+	retval.baseDir = baseDir
+
+	if err = retval.resolveDirs(); err != nil {
+		return nil, err
+	}
+
+	return retval, nil
+}
+
+// loadDbFrom decodes the repo file from r into a repository in dbDir. Name is
+// used only for messages. Directories are not resolved and the journal is not
+// replayed.
+func loadDbFrom(r io.Reader, dbDir, name string) (*db, error) {
+	rawJSON, err := decodeRepoJSON(r, name)
+	if err != nil {
+		return nil, err
+	}
+	if rawJSON.Integrity == "" && dbDir != "" {
+		log.Printf("warning: '%s' has no integrity checksum; it will be added when the repository is saved", name)
+	}
+
+	retval, err := newDbFromJSON(dbDir, rawJSON)
+	if err != nil {
+		return nil, err
+	}
+	retval.integrity = rawJSON.Integrity
+	retval.savedSettings = retval.settings()
+
+	return retval, nil
+}
+
+// loadRepoFiles loads the repository from the given repo file and journal in
+// dbDir. Directories are not resolved.
+func loadRepoFiles(dbDir, filesName, journalName string) (*db, error) {
+	retval, err := loadRepoFile(dbDir, filesName)
+	if err != nil {
+		return nil, err
+	}
+	err = retval.replayJournal(filepath.Join(dbDir, journalName))
+	if errors.Is(err, errStaleJournal) {
+		// repo file was compacted, but saving was interrupted before the
+		// journal was removed; next save will remove it
+		log.Printf("warning: %v", err)
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return retval, nil
+}
+
+// loadRepoFile loads the repo file alone, without replaying its journal.
+func loadRepoFile(dbDir, filesName string) (*db, error) {
+	boffinPath := filepath.Join(dbDir, filesName)
 
 	boffinFile, err := os.Open(boffinPath)
 	if err != nil {
@@ -362,29 +1134,98 @@ func LoadBoffin(dbDir string) (Boffin, error) {
 		_ = boffinFile.Close()
 	}()
 
-	decoder := json.NewDecoder(boffinFile)
+	hash := sha256.New()
+	retval, err := loadDbFrom(io.TeeReader(boffinFile, hash), dbDir, boffinPath)
+	if err != nil {
+		return nil, err
+	}
+	retval.fileChecksum = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	return retval, nil
+}
+
+// corruptedError is returned when the repo file or the journal can not be
+// parsed.
+type corruptedError struct {
+	error
+}
+
+func (e *corruptedError) Unwrap() error {
+	return e.error
+}
+
+// decodeRepoJSON reads the repository data from r and checks its integrity if
+// the checksum is present. Data compressed with gzip is recognized by its
+// header and decompressed. Name is used only for error messages.
+func decodeRepoJSON(r io.Reader, name string) (*jsonStruct, error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, gzipMagic) {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, &corruptedError{err}
+		}
+		defer func() {
+			_ = decompressed.Close()
+		}()
+		r = decompressed
+	} else {
+		r = buffered
+	}
+
+	decoder := json.NewDecoder(r)
 	decoder.DisallowUnknownFields()
 
 	rawJSON := &jsonStruct{}
 	if err := decoder.Decode(&rawJSON); err != nil {
-		return nil, err
+		return nil, &corruptedError{err}
 	}
 
 	// ensure there is nothing after the first json object
 	dummy := &jsonStruct{}
-	if err = decoder.Decode(&dummy); err != io.EOF {
-		return nil, fmt.Errorf("unexpected contents at the end of config file")
+	if err := decoder.Decode(&dummy); err != io.EOF {
+		return nil, &corruptedError{fmt.Errorf("unexpected contents at the end of config file")}
+	}
+
+	if rawJSON.Integrity != "" {
+		integrity, err := rawJSON.integrityChecksum()
+		if err != nil {
+			return nil, err
+		}
+		if integrity != rawJSON.Integrity {
+			return nil, fmt.Errorf("'%s' is corrupted or was modified by hand; integrity checksum does not match", name)
+		}
 	}
 
+	return rawJSON, nil
+}
+
+// newDbFromJSON creates the repository from the decoded repo file. Directories
+// are not resolved and the journal is not replayed.
+func newDbFromJSON(dbDir string, rawJSON *jsonStruct) (*db, error) {
 	var retval *db
 
 	if rawJSON.V2 != nil {
 		retval = &db{
-			dbDir:     dbDir,
-			baseDir:   rawJSON.V2.BaseDir,
-			importDir: rawJSON.V2.ImportDir,
-			ignore:    compileIgnorePatterns(rawJSON.V2.Ignore),
-			files:     rawJSON.V2.Files,
+			dbDir:      dbDir,
+			baseDir:    rawJSON.V2.BaseDir,
+			importDir:  rawJSON.V2.ImportDir,
+			roots:      rawJSON.V2.Roots,
+			ignore:     compileIgnorePatterns(rawJSON.V2.Ignore),
+			appendOnly: rawJSON.V2.AppendOnly,
+			backups:    rawJSON.V2.Backups,
+			origin:     rawJSON.V2.Origin,
+			compress:   rawJSON.V2.Compress,
+			files:      rawJSON.V2.Files,
+		}
+		if rawJSON.V2.Timezone != "" {
+			if err := retval.SetTimezone(rawJSON.V2.Timezone); err != nil {
+				return nil, err
+			}
+			// keep times in memory in UTC, same as if they were saved in UTC
+			for _, file := range retval.files {
+				for _, event := range file.History {
+					event.Time = event.Time.UTC()
+				}
+			}
 		}
 	} else if rawJSON.V1 != nil {
 		retval = &db{
@@ -397,34 +1238,59 @@ func LoadBoffin(dbDir string) (Boffin, error) {
 		return nil, fmt.Errorf("config file is empty")
 	}
 
-	if filepath.IsAbs(retval.baseDir) {
-		retval.absBaseDir, err = cleanPath(retval.baseDir)
+	return retval, nil
+}
+
+// resolveDirs sets absolute base, import and root dirs from the saved ones.
+func (db *db) resolveDirs() error {
+	var err error
+
+	if filepath.IsAbs(db.baseDir) {
+		db.absBaseDir, err = cleanPath(db.baseDir)
 	} else {
-		retval.absBaseDir, err = cleanPath(filepath.Join(dbDir, retval.baseDir))
+		db.absBaseDir, err = cleanPath(filepath.Join(db.dbDir, db.baseDir))
 	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if filepath.IsAbs(retval.importDir) {
-		retval.absImportDir, err = cleanPath(retval.importDir)
-	} else {
-		retval.absImportDir, err = cleanPath(filepath.Join(retval.absBaseDir, retval.importDir))
-	}
-	if err != nil {
-		return nil, err
+	db.absRoots = map[string]string{}
+	for _, root := range db.roots {
+		dir := root.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(db.dbDir, dir)
+		}
+		if db.absRoots[root.Name], err = cleanPath(dir); err != nil {
+			return err
+		}
 	}
 
-	return retval, nil
+	if filepath.IsAbs(db.importDir) {
+		db.absImportDir, err = cleanPath(db.importDir)
+	} else {
+		db.absImportDir, err = cleanPath(filepath.Join(db.absBaseDir, db.importDir))
+	}
+	return err
 }
 
 // ConstuctDbPath ...
 func ConstuctDbPath(baseDir string) string {
-	return filepath.Join(baseDir, defaultDbDir)
+	return ConstuctDbPathWithName(baseDir, DefaultDbDirName)
+}
+
+// ConstuctDbPathWithName returns the path of the db dir with the given name.
+func ConstuctDbPathWithName(baseDir, name string) string {
+	return filepath.Join(baseDir, name)
 }
 
 // FindBoffinDir ...
 func FindBoffinDir(dir string) (string, error) {
+	return FindBoffinDirWithName(dir, DefaultDbDirName)
+}
+
+// FindBoffinDirWithName looks for the db dir with the given name in dir or
+// any of its parents.
+func FindBoffinDirWithName(dir, name string) (string, error) {
 	// if dir is empty, start in current directory
 	if dir == "" {
 		var err error
@@ -438,9 +1304,9 @@ func FindBoffinDir(dir string) (string, error) {
 		return "", err
 	}
 
-	// look into current or any parent directory for a root which has defaultDbDir
+	// look into current or any parent directory for a root which has the db dir
 	for {
-		dbDir := filepath.Join(dir, defaultDbDir)
+		dbDir := filepath.Join(dir, name)
 		info, err := os.Stat(dbDir)
 		if err == nil && info.IsDir() {
 			return dbDir, nil
@@ -451,11 +1317,183 @@ func FindBoffinDir(dir string) (string, error) {
 		dir = filepath.Dir(dir)
 	}
 
-	return "", fmt.Errorf("could not find %s dir", defaultDbDir)
+	return "", fmt.Errorf("could not find %s dir", name)
+}
+
+// FindBoffinDirs returns db dirs of all repositories found under the root dir.
+// Directories of a repository are not searched further, so repositories nested
+// inside another one are not returned. Hidden directories are skipped.
+func FindBoffinDirs(root string) ([]string, error) {
+	return FindBoffinDirsWithName(root, DefaultDbDirName)
+}
+
+// FindBoffinDirsWithName is same as FindBoffinDirs, but looks for db dirs with
+// the given name.
+func FindBoffinDirsWithName(root, name string) ([]string, error) {
+	root, err := cleanPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	dbDirs := []string{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				log.Printf("%s: permission denied", path)
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		dbDir := filepath.Join(path, name)
+		if info, err := os.Stat(dbDir); err == nil && info.IsDir() {
+			dbDirs = append(dbDirs, dbDir)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dbDirs, nil
+}
+
+// HashAlgorithm identifies the algorithm used to calculate file checksums.
+type HashAlgorithm string
+
+// Supported hash algorithms. Others can be added with RegisterHashAlgorithm.
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA512 HashAlgorithm = "sha512"
+	HashSHA1   HashAlgorithm = "sha1"
+	HashMD5    HashAlgorithm = "md5"
+	// HashMetadataOnly is not a hash; contents of such files are never read,
+	// and their checksum is made of their size and modification time instead.
+	// They never match files with real checksums, nor files at other paths,
+	// and copies of them are not verified.
+	HashMetadataOnly HashAlgorithm = "metadata-only"
+)
+
+// DefaultHashAlgorithm is used for new files unless requested otherwise, and
+// is assumed for events that do not record the algorithm.
+const DefaultHashAlgorithm = HashSHA256
+
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	HashSHA256: sha256.New,
+	HashSHA512: sha512.New,
+	HashSHA1:   sha1.New,
+	HashMD5:    md5.New,
+}
+
+// RegisterHashAlgorithm makes a hash algorithm available under the given
+// name, e.g. to use BLAKE3 or xxhash implementations from other packages. It
+// should be called before any repository is loaded or updated.
+func RegisterHashAlgorithm(algorithm HashAlgorithm, newHash func() hash.Hash) {
+	hashAlgorithms[algorithm] = newHash
+}
+
+const partialHashInfix = "-partial-"
+
+// PartialHash returns the algorithm that hashes only the first and the last
+// blockSize bytes of the file, together with the file size, using the given
+// algorithm. This is much faster for large files, but changes elsewhere in
+// the file are not detected. Partial checksums never match full ones.
+func PartialHash(algorithm HashAlgorithm, blockSize int64) HashAlgorithm {
+	return HashAlgorithm(fmt.Sprintf("%s%s%d", algorithm, partialHashInfix, blockSize))
+}
+
+// Partial returns the underlying algorithm and the block size if the
+// algorithm is a partial hash; see PartialHash. Block size is 0 otherwise.
+func (a HashAlgorithm) Partial() (HashAlgorithm, int64) {
+	i := strings.LastIndex(string(a), partialHashInfix)
+	if i < 0 {
+		return a, 0
+	}
+	blockSize, err := strconv.ParseInt(string(a[i+len(partialHashInfix):]), 10, 64)
+	if err != nil || blockSize <= 0 {
+		return a, 0
+	}
+	return a[:i], blockSize
+}
+
+func newHash(algorithm HashAlgorithm) (hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = DefaultHashAlgorithm
+	}
+	algorithm, _ = algorithm.Partial()
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm '%s'", algorithm)
+	}
+	return newHash(), nil
 }
 
-// CalculateChecksum ...
+// DefaultHashBlockSize is the size of reads used when calculating checksums,
+// unless changed with SetHashBlockSize.
+const DefaultHashBlockSize = 1 << 20
+
+var hashReaders = newHashReaderPool(DefaultHashBlockSize)
+
+func newHashReaderPool(blockSize int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return bufio.NewReaderSize(nil, blockSize)
+		},
+	}
+}
+
+// SetHashBlockSize changes the size of reads used when calculating checksums.
+// Larger reads are usually faster on spinning disks. Checksums do not depend
+// on the block size. Size of 0 or less restores DefaultHashBlockSize. It
+// should be called before any checksums are calculated.
+func SetHashBlockSize(blockSize int) {
+	if blockSize <= 0 {
+		blockSize = DefaultHashBlockSize
+	}
+	hashReaders = newHashReaderPool(blockSize)
+}
+
+// copyBuffered copies the whole file into the hash, reading it in blocks of
+// the configured size.
+func copyBuffered(hash hash.Hash, file io.Reader) error {
+	reader := hashReaders.Get().(*bufio.Reader)
+	defer hashReaders.Put(reader)
+	// hide io.WriterTo of the file, otherwise it would be used to copy the
+	// file with its own, much smaller, buffer
+	reader.Reset(struct{ io.Reader }{throttle(file)})
+	defer reader.Reset(nil)
+	_, err := reader.WriteTo(hash)
+	return err
+}
+
+// CalculateChecksum calculates the file checksum using DefaultHashAlgorithm.
 func CalculateChecksum(path string) (string, error) {
+	return CalculateChecksumWith(path, DefaultHashAlgorithm)
+}
+
+// CalculateChecksumWith calculates the file checksum using the given
+// algorithm.
+func CalculateChecksumWith(path string, algorithm HashAlgorithm) (string, error) {
+	if algorithm == HashMetadataOnly {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		return metadataChecksum(info.Size(), info.ModTime()), nil
+	}
+
+	hash, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		return "", err
@@ -464,10 +1502,47 @@ func CalculateChecksum(path string) (string, error) {
 		_ = file.Close()
 	}()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	if _, blockSize := algorithm.Partial(); blockSize > 0 {
+		err = hashPartial(hash, file, blockSize)
+	} else {
+		err = copyBuffered(hash, file)
+	}
+	if err != nil {
 		return "", err
 	}
 
 	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
 }
+
+// metadataChecksum is the checksum of files tracked with HashMetadataOnly,
+// i.e. size at modification time in nanoseconds. It does not use ':', which
+// separates the algorithm from the checksum in keys and output.
+func metadataChecksum(size int64, modTime time.Time) string {
+	return fmt.Sprintf("%d@%d", size, modTime.UnixNano())
+}
+
+// hashPartial hashes the file size followed by the first and the last
+// blockSize bytes of the file. Files smaller than two blocks are hashed whole.
+func hashPartial(hash hash.Hash, file *os.File, blockSize int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	if err = binary.Write(hash, binary.BigEndian, size); err != nil {
+		return err
+	}
+	if size <= 2*blockSize {
+		return copyBuffered(hash, file)
+	}
+
+	if _, err = io.CopyN(hash, throttle(file), blockSize); err != nil {
+		return err
+	}
+	if _, err = file.Seek(size-blockSize, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(hash, throttle(file), blockSize)
+	return err
+}
@@ -1,13 +1,20 @@
 package lib
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func getTestDir() string {
@@ -29,6 +36,7 @@ type result struct {
 	Result string
 	Local  []string
 	Remote []string
+	Moved  bool
 }
 
 type testAction struct {
@@ -107,19 +115,37 @@ func (t *testAction) RemoteDeleted(localFile, remoteFile *FileInfo) {
 	})
 }
 
-func (t *testAction) LocalChanged(localFile, remoteFile *FileInfo) {
+func (t *testAction) BothDeleted(localFile, remoteFile *FileInfo) {
+	t.Result = append(t.Result, &result{
+		Result: "both-deleted",
+		Local:  []string{localFile.Path()},
+		Remote: []string{remoteFile.Path()},
+	})
+}
+
+func (t *testAction) ReAdded(localFile, remoteFile *FileInfo) {
+	t.Result = append(t.Result, &result{
+		Result: "re-added",
+		Local:  []string{localFile.Path()},
+		Remote: []string{remoteFile.Path()},
+	})
+}
+
+func (t *testAction) LocalChanged(localFile, remoteFile *FileInfo, moved bool) {
 	t.Result = append(t.Result, &result{
 		Result: "local-changed",
 		Local:  []string{localFile.Path()},
 		Remote: []string{remoteFile.Path()},
+		Moved:  moved,
 	})
 }
 
-func (t *testAction) RemoteChanged(localFile, remoteFile *FileInfo) {
+func (t *testAction) RemoteChanged(localFile, remoteFile *FileInfo, moved bool) {
 	t.Result = append(t.Result, &result{
 		Result: "remote-changed",
 		Local:  []string{localFile.Path()},
 		Remote: []string{remoteFile.Path()},
+		Moved:  moved,
 	})
 }
 
@@ -131,7 +157,23 @@ func (t *testAction) ConflictPath(localFile, remoteFile *FileInfo) {
 	})
 }
 
+func (t *testAction) DivergedFromAncestor(localFile, remoteFile *FileInfo) {
+	t.Result = append(t.Result, &result{
+		Result: "diverged",
+		Local:  []string{localFile.Path()},
+		Remote: []string{remoteFile.Path()},
+	})
+}
+
+func (t *testAction) ConflictCase(localFiles, remoteFiles []*FileInfo) {
+	t.conflict("conflict-case", localFiles, remoteFiles)
+}
+
 func (t *testAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
+	t.conflict("conflict", localFiles, remoteFiles)
+}
+
+func (t *testAction) conflict(kind string, localFiles, remoteFiles []*FileInfo) {
 	local := []string{}
 	for _, file := range localFiles {
 		local = append(local, file.Path())
@@ -145,7 +187,7 @@ func (t *testAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
 	sort.Strings(remote)
 
 	t.Result = append(t.Result, &result{
-		Result: "conflict",
+		Result: kind,
 		Local:  local,
 		Remote: remote,
 	})
@@ -234,6 +276,61 @@ func TestFindBoffin(t *testing.T) {
 	}
 }
 
+func TestFindBoffinDirWithName(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "contents")
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	originals, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	edits, err := InitDbDir(ConstuctDbPathWithName(dir, "edits"), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, repo := range []Boffin{originals, edits} {
+		if err = Update(repo, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = repo.Save(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	found, err := FindBoffinDirWithName(sub, "edits")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, "edits"); found != expected {
+		t.Errorf("FindBoffinDirWithName: expected '%s', got '%s'", expected, found)
+	}
+	loaded, err := LoadBoffin(found)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the db dir itself is not tracked, even if it is not hidden
+	if len(loaded.GetFiles()) != 1 {
+		t.Errorf("GetFiles: expected 1 file, got %d", len(loaded.GetFiles()))
+	}
+
+	found, err = FindBoffinDir(sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, DefaultDbDirName); found != expected {
+		t.Errorf("FindBoffinDir: expected '%s', got '%s'", expected, found)
+	}
+
+	if _, err = FindBoffinDirWithName(sub, ".missing"); err == nil || err.Error() != "could not find .missing dir" {
+		t.Errorf("FindBoffinDirWithName: unexpected error: %v", err)
+	}
+}
+
 func TestLoadBoffin(t *testing.T) {
 	dir := filepath.Join(getTestDir(), "load-boffin", ".boffin")
 
@@ -307,3 +404,761 @@ func TestLoadBoffin(t *testing.T) {
 		t.Errorf("GetImportDir: '%s' != '%s'", expected, boffin.GetImportDir())
 	}
 }
+
+func TestLoadBoffinFrom(t *testing.T) {
+	dir := t.TempDir()
+	buf := bytes.NewBufferString(`{
+  "v1": {
+    "base-dir": "ignored",
+    "import-dir": "import",
+    "files": [
+      {"history": [{"path": "a.jpg", "size": 10, "time": "2020-01-01T12:34:56Z", "checksum": "hash"}]}
+    ]
+  }
+}`)
+
+	boffin, err := LoadBoffinFrom(buf, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if boffin.GetBaseDir() != dir {
+		t.Errorf("GetBaseDir: expected '%s', got '%s'", dir, boffin.GetBaseDir())
+	}
+	if expected := filepath.Join(dir, "import"); boffin.GetImportDir() != expected {
+		t.Errorf("GetImportDir: expected '%s', got '%s'", expected, boffin.GetImportDir())
+	}
+	file := boffin.GetFileByPath("a.jpg")
+	if file == nil || file.Checksum() != "hash" {
+		t.Fatalf("GetFileByPath: expected 'a.jpg' with checksum 'hash', got %v", file)
+	}
+	if err = boffin.Save(); err == nil {
+		t.Errorf("Save: expected error for repository without db dir")
+	}
+
+	// exported repository loads the same
+	out := &bytes.Buffer{}
+	if err = boffin.Export(out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloaded, err := LoadBoffinFrom(out, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reloaded.GetFiles()) != 1 || reloaded.GetFileByPath("a.jpg") == nil {
+		t.Errorf("GetFiles: expected 'a.jpg', got %d files", len(reloaded.GetFiles()))
+	}
+
+	if _, err = LoadBoffinFrom(bytes.NewBufferString("{} trailing"), dir); err == nil {
+		t.Errorf("LoadBoffinFrom: expected error for invalid input")
+	}
+}
+
+func TestGetFileByPath(t *testing.T) {
+	live := &FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "file", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-3"},
+		},
+	}
+	oldDeleted := &FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "deleted", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+			&FileEvent{Path: "deleted", Time: parseTime("2020-01-02T12:34:56Z")},
+		},
+	}
+	newDeleted := &FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "deleted", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-2"},
+			&FileEvent{Path: "deleted", Time: parseTime("2020-01-04T12:34:56Z")},
+		},
+	}
+	boffin := &db{
+		files: []*FileInfo{oldDeleted, live, newDeleted},
+	}
+
+	if actual := boffin.GetFileByPath("file"); actual != live {
+		t.Errorf("GetFileByPath(file): expected live file")
+	}
+	if actual := boffin.GetFileByPath("deleted"); actual != newDeleted {
+		t.Errorf("GetFileByPath(deleted): expected most recently deleted file")
+	}
+	if actual := boffin.GetFileByPath("unknown"); actual != nil {
+		t.Errorf("GetFileByPath(unknown): expected nil")
+	}
+}
+
+func TestSaveTimezone(t *testing.T) {
+	dir := t.TempDir()
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []*FileInfo{
+		{
+			History: []*FileEvent{
+				&FileEvent{
+					Path:     "file.ext",
+					Size:     10,
+					Time:     parseTime("2020-01-01T12:34:56Z"),
+					Checksum: "hash",
+				},
+			},
+		},
+	}
+	boffin.AddFile(expected[0].clone())
+	if err = boffin.SetTimezone("Europe/Belgrade"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(ConstuctDbPath(dir), filesFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(raw), `"2020-01-01T13:34:56+01:00"`) {
+		t.Errorf("time was not saved in the configured timezone:\n%s", raw)
+	}
+	if boffin.GetFiles()[0].Time().Location() != time.UTC {
+		t.Errorf("Save: in memory time was modified")
+	}
+
+	boffin, err = LoadBoffin(ConstuctDbPath(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if boffin.GetTimezone() != "Europe/Belgrade" {
+		t.Errorf("GetTimezone: 'Europe/Belgrade' != '%s'", boffin.GetTimezone())
+	}
+
+	margin, _ := time.ParseDuration("2s")
+	opt1 := cmpopts.EquateApproxTime(margin)
+	if diff := cmp.Diff(expected, boffin.GetFiles(), opt1); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	if err = boffin.SetTimezone("Not/AZone"); err == nil {
+		t.Errorf("SetTimezone: expected error for invalid timezone")
+	}
+}
+
+func TestSaveRefusesConcurrentChange(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+
+	if _, err := InitDbDir(dbDir, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// saving repeatedly without outside changes must work
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// simulate another process saving the repo
+	other, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other.AddFile(&FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "other.ext", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash"},
+		},
+	})
+	if err = other.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = boffin.Save(); err == nil {
+		t.Errorf("Save: expected error after repo file was changed")
+	}
+
+	reloaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reloaded.GetFiles()) != 1 {
+		t.Errorf("GetFiles: changes from other process were overwritten")
+	}
+}
+
+func TestLoadBoffinIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.AddFile(&FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "file.ext", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash"},
+		},
+	})
+	if err = boffin.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = LoadBoffin(dbDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	filename := filepath.Join(dbDir, filesFilename)
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(raw), `"integrity": "`) {
+		t.Fatalf("integrity checksum was not saved:\n%s", raw)
+	}
+	write := func(raw string) {
+		if err := os.Chmod(filename, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(filename, []byte(raw), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// change a single byte
+	write(strings.Replace(string(raw), `"size": 10`, `"size": 11`, 1))
+	if _, err = LoadBoffin(dbDir); err == nil || !strings.Contains(err.Error(), "integrity") {
+		t.Errorf("LoadBoffin: expected integrity error, got: %v", err)
+	}
+
+	// files saved before integrity was added must still load
+	lines := strings.Split(string(raw), "\n")
+	var filtered []string
+	for _, line := range lines {
+		if !strings.Contains(line, `"integrity"`) {
+			filtered = append(filtered, line)
+		}
+	}
+	write(strings.Join(filtered, "\n"))
+	if _, err = LoadBoffin(dbDir); err != nil {
+		t.Errorf("LoadBoffin: unexpected error for file without integrity: %v", err)
+	}
+}
+
+func TestFindByChecksum(t *testing.T) {
+	boffin := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash1"},
+					&FileEvent{Path: "a", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash2"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "b", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash1"},
+					&FileEvent{Path: "b", Time: parseTime("2020-01-02T12:34:56Z")},
+				},
+			},
+		},
+	}
+
+	paths := func(files []*FileInfo) []string {
+		retval := []string{}
+		for _, file := range files {
+			retval = append(retval, file.Path())
+		}
+		sort.Strings(retval)
+		return retval
+	}
+
+	if diff := cmp.Diff([]string{"a"}, paths(boffin.FindByChecksum("hash2"))); diff != "" {
+		t.Errorf("FindByChecksum:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{}, paths(boffin.FindByChecksum("hash1"))); diff != "" {
+		t.Errorf("FindByChecksum:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, paths(boffin.FindByHistoricalChecksum("hash1"))); diff != "" {
+		t.Errorf("FindByHistoricalChecksum:\n%s", diff)
+	}
+
+	// added files are found
+	boffin.AddFile(&FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "c", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash1"},
+	}})
+	if diff := cmp.Diff([]string{"c"}, paths(boffin.FindByChecksum("hash1"))); diff != "" {
+		t.Errorf("FindByChecksum:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, paths(boffin.FindByHistoricalChecksum("hash1"))); diff != "" {
+		t.Errorf("FindByHistoricalChecksum:\n%s", diff)
+	}
+}
+
+func TestPruneDeleted(t *testing.T) {
+	event := func(path, checksum, time string) *FileEvent {
+		return &FileEvent{Path: path, Size: 10, Time: parseTime(time), Checksum: checksum}
+	}
+	deleted := func(path, time string) *FileEvent {
+		return &FileEvent{Path: path, Time: parseTime(time)}
+	}
+	boffin := &db{
+		files: []*FileInfo{
+			{History: []*FileEvent{event("live", "hash-1", "2020-01-01T12:34:56Z")}},
+			{History: []*FileEvent{event("old", "hash-2", "2020-01-01T12:34:56Z"), deleted("old", "2020-01-02T12:34:56Z")}},
+			{History: []*FileEvent{event("recent", "hash-3", "2020-01-01T12:34:56Z"), deleted("recent", "2020-02-02T12:34:56Z")}},
+			// same content as a live file, so it must be kept
+			{History: []*FileEvent{event("copy", "hash-1", "2020-01-01T12:34:56Z"), deleted("copy", "2020-01-02T12:34:56Z")}},
+		},
+	}
+
+	if removed := boffin.PruneDeleted(parseTime("2020-02-01T00:00:00Z")); removed != 1 {
+		t.Errorf("PruneDeleted: expected 1 removed file, got %d", removed)
+	}
+
+	expected := []string{"live", "recent", "copy"}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
+
+func TestExportImportDb(t *testing.T) {
+	dir := t.TempDir()
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetAppendOnly(true)
+	if err = boffin.SetTimezone("Europe/Belgrade"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.AddFile(&FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "b.ext", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		},
+	})
+	boffin.AddFile(&FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "a.ext", Size: 20, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-2"},
+		},
+	})
+
+	var exported strings.Builder
+	if err = boffin.Export(&exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target := t.TempDir()
+	dbDir := ConstuctDbPath(target)
+	imported, err := ImportDb(strings.NewReader(exported.String()), dbDir, target, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if imported.GetBaseDir() != target {
+		t.Errorf("GetBaseDir: expected '%s', got '%s'", target, imported.GetBaseDir())
+	}
+	if !imported.IsAppendOnly() || imported.GetTimezone() != "Europe/Belgrade" {
+		t.Errorf("settings were not imported")
+	}
+
+	// export of the imported repo must be the same
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var reexported strings.Builder
+	if err = loaded.Export(&reexported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(exported.String(), reexported.String()); diff != "" {
+		t.Errorf("Export:\n%s", diff)
+	}
+
+	// existing repo is replaced only when forced
+	if _, err = ImportDb(strings.NewReader(exported.String()), dbDir, target, false); err == nil {
+		t.Errorf("ImportDb: expected error for existing repo")
+	}
+	if _, err = ImportDb(strings.NewReader(exported.String()), dbDir, target, true); err != nil {
+		t.Errorf("ImportDb: unexpected error: %v", err)
+	}
+}
+
+func TestSetBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := filepath.Join(dir, "db")
+	for _, sub := range []string{"old", "new"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	boffin, err := InitDbDir(dbDir, filepath.Join(dir, "old"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.(*db).importDir = filepath.Join(dir, "old", "import")
+	boffin.(*db).absImportDir = boffin.(*db).importDir
+	if err = boffin.SetBaseDir(filepath.Join(dir, "new")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := filepath.Join(dir, "new"); loaded.GetBaseDir() != expected {
+		t.Errorf("GetBaseDir: expected '%s', got '%s'", expected, loaded.GetBaseDir())
+	}
+	if expected := filepath.Join(dir, "new", "import"); loaded.GetImportDir() != expected {
+		t.Errorf("GetImportDir: expected '%s', got '%s'", expected, loaded.GetImportDir())
+	}
+	if raw, err := os.ReadFile(filepath.Join(dbDir, filesFilename)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.Contains(string(raw), `"base-dir": "../new"`) {
+		t.Errorf("base dir was not saved relative to the repository:\n%s", raw)
+	}
+
+	if err = boffin.SetBaseDir(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("SetBaseDir: expected error for missing directory")
+	}
+}
+
+func TestCurrentEvent(t *testing.T) {
+	current := &FileEvent{Path: "new", Size: 20, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-2"}
+	file := &FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "old", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		current,
+	}}
+	if event := file.CurrentEvent(); event != current {
+		t.Errorf("CurrentEvent: expected %v, got %v", current, event)
+	}
+	if file.Path() != "new" || file.Size() != 20 || !file.Time().Equal(current.Time) || file.Checksum() != "hash-2" {
+		t.Errorf("accessors do not match the current event: %s, %d, %v, %s", file.Path(), file.Size(), file.Time(), file.Checksum())
+	}
+
+	// deleted file has no current event, but keeps its last path
+	file.MarkDeleted()
+	if event := file.CurrentEvent(); event != nil {
+		t.Errorf("CurrentEvent: expected nil for deleted file, got %v", event)
+	}
+	if file.Path() != "new" || file.Size() != 20 || file.Checksum() != "" {
+		t.Errorf("deleted file: unexpected %s, %d, %s", file.Path(), file.Size(), file.Checksum())
+	}
+
+	if event := (&FileInfo{}).CurrentEvent(); event != nil {
+		t.Errorf("CurrentEvent: expected nil for empty history, got %v", event)
+	}
+}
+
+func TestCollapseHistory(t *testing.T) {
+	history := []*FileEvent{
+		&FileEvent{Path: "a.ext", Size: 10, Time: parseTime("2020-01-01T00:00:00Z"), Checksum: "hash-1", Note: "added"},
+	}
+	// noisy chains before and after a rename and a content change
+	for day := 2; day <= 20; day++ {
+		history = append(history, &FileEvent{Path: "a.ext", Size: 10, Time: parseTime(fmt.Sprintf("2020-01-%02dT00:00:00Z", day)), Checksum: "hash-1"})
+	}
+	history = append(history,
+		&FileEvent{Path: "b.ext", Size: 10, Time: parseTime("2020-02-01T00:00:00Z"), Checksum: "hash-1"},
+		&FileEvent{Path: "b.ext", Size: 20, Time: parseTime("2020-02-02T00:00:00Z"), Checksum: "hash-2"},
+	)
+	for day := 3; day <= 28; day++ {
+		history = append(history, &FileEvent{Path: "b.ext", Size: 20, Time: parseTime(fmt.Sprintf("2020-02-%02dT00:00:00Z", day)), Checksum: "hash-2", Mode: 0644})
+	}
+	verified := parseTime("2020-03-01T00:00:00Z")
+	noisy := &FileInfo{History: history, LastVerified: &verified}
+	deleted := &FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "c.ext", Size: 10, Time: parseTime("2020-01-01T00:00:00Z"), Checksum: "hash-3"},
+		&FileEvent{Path: "c.ext", Size: 10, Time: parseTime("2020-01-02T00:00:00Z"), Checksum: "hash-3"},
+		&FileEvent{Path: "c.ext", Time: parseTime("2020-01-03T00:00:00Z")},
+	}}
+	clean := &FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "d.ext", Size: 10, Time: parseTime("2020-01-01T00:00:00Z"), Checksum: "hash-4"},
+	}}
+
+	boffin := &db{files: []*FileInfo{noisy, deleted, clean}}
+	current := *noisy.CurrentEvent()
+	if removed := CollapseHistory(boffin); removed != 19+26+1 {
+		t.Errorf("CollapseHistory: expected 46 removed events, got %d", removed)
+	}
+
+	files := boffin.GetFiles()
+	expected := []*FileEvent{
+		&FileEvent{Path: "a.ext", Size: 10, Time: parseTime("2020-01-01T00:00:00Z"), Checksum: "hash-1", Note: "added"},
+		&FileEvent{Path: "b.ext", Size: 10, Time: parseTime("2020-02-01T00:00:00Z"), Checksum: "hash-1"},
+		&FileEvent{Path: "b.ext", Size: 20, Time: parseTime("2020-02-28T00:00:00Z"), Checksum: "hash-2", Mode: 0644},
+	}
+	if diff := cmp.Diff(expected, files[0].History); diff != "" {
+		t.Errorf("noisy:\n%s", diff)
+	}
+	if diff := cmp.Diff(&current, files[0].CurrentEvent()); diff != "" {
+		t.Errorf("current state changed:\n%s", diff)
+	}
+	if files[0].LastVerified == nil || !files[0].LastVerified.Equal(verified) {
+		t.Errorf("noisy: expected last verification time to be kept, got %v", files[0].LastVerified)
+	}
+	if len(files[1].History) != 2 || !files[1].IsDeleted() || files[1].Path() != "c.ext" {
+		t.Errorf("deleted: unexpected history %v", files[1].History)
+	}
+	if files[2] != clean {
+		t.Errorf("clean: expected file without redundant events to be kept as is")
+	}
+	// original events are not modified
+	if !history[21].Time.Equal(parseTime("2020-02-02T00:00:00Z")) {
+		t.Errorf("original event was modified: %v", history[21])
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if boffin.GetOrigin() != "" {
+		t.Errorf("GetOrigin: expected no origin, got '%s'", boffin.GetOrigin())
+	}
+	// repo file without origin still loads
+	if _, err = LoadBoffin(dbDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin.SetOrigin("phone")
+	boffin.AddFile(&FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "a.jpg", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash", Origin: "laptop"},
+	}})
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded.GetOrigin() != "phone" {
+		t.Errorf("GetOrigin: expected 'phone', got '%s'", loaded.GetOrigin())
+	}
+	if origin := loaded.GetFileByPath("a.jpg").History[0].Origin; origin != "laptop" {
+		t.Errorf("event origin: expected 'laptop', got '%s'", origin)
+	}
+
+	buf := &strings.Builder{}
+	if err = loaded.Export(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"origin": "phone"`) || !strings.Contains(buf.String(), `"origin": "laptop"`) {
+		t.Errorf("Export: expected origins in the output:\n%s", buf.String())
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetCompressed(true)
+	boffin.AddFile(&FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "a.jpg", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-a"},
+	}})
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dbDir, filesFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("%s: expected gzip compressed file", filesFilename)
+	}
+
+	// changes saved to the journal are replayed on top of compressed file
+	boffin.AddFile(&FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "b.jpg", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-b"},
+	}})
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !loaded.IsCompressed() || loaded.GetFileByPath("a.jpg") == nil || loaded.GetFileByPath("b.jpg") == nil {
+		t.Errorf("LoadBoffin: expected compressed repository with 2 files, got %d files", len(loaded.GetFiles()))
+	}
+
+	// switching back writes plain JSON
+	loaded.SetCompressed(false)
+	if err = loaded.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err = os.ReadFile(filepath.Join(dbDir, filesFilename)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("{")) {
+		t.Errorf("%s: expected plain JSON", filesFilename)
+	}
+	if loaded, err = LoadBoffin(dbDir); err != nil || len(loaded.GetFiles()) != 2 {
+		t.Errorf("LoadBoffin: expected 2 files, got error: %v", err)
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	local := []*FileEvent{
+		&FileEvent{Path: "local", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		&FileEvent{Path: "local", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-3"},
+	}
+	remote := []*FileEvent{
+		&FileEvent{Path: "remote", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		&FileEvent{Path: "remote", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-2"},
+		&FileEvent{Path: "remote", Time: parseTime("2020-01-04T12:34:56Z")},
+		&FileEvent{Path: "remote", Size: 10, Time: parseTime("2020-01-05T12:34:56Z"), Checksum: "hash-3"},
+	}
+
+	expected := []*FileEvent{
+		&FileEvent{Path: "local", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		&FileEvent{Path: "remote", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-2"},
+		&FileEvent{Path: "local", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-3"},
+		&FileEvent{Path: "remote", Time: parseTime("2020-01-04T12:34:56Z")},
+		&FileEvent{Path: "remote", Size: 10, Time: parseTime("2020-01-05T12:34:56Z"), Checksum: "hash-3"},
+	}
+
+	actual := MergeHistory(local, remote)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("MergeHistory:\n%s", diff)
+	}
+	for _, event := range remote {
+		for _, merged := range actual {
+			if event == merged {
+				t.Errorf("MergeHistory: remote event %v is shared", event)
+			}
+		}
+	}
+}
+
+func TestCalculateChecksumBlockSize(t *testing.T) {
+	defer SetHashBlockSize(0)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	contents := strings.Repeat("0123456789", 100001)
+	writeTestFile(t, path, contents)
+
+	sum := sha256.Sum256([]byte(contents))
+	expected := map[HashAlgorithm]string{
+		HashSHA256: base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	// partial hash of a file smaller than two blocks reads it whole
+	partial := PartialHash(HashSHA256, 1<<20)
+	var err error
+	if expected[partial], err = CalculateChecksumWith(path, partial); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, blockSize := range []int{16, 1000, 4096, 1 << 20, 4 << 20} {
+		SetHashBlockSize(blockSize)
+		for algorithm, expected := range expected {
+			checksum, err := CalculateChecksumWith(path, algorithm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if checksum != expected {
+				t.Errorf("%s, block size %d: %s != %s", algorithm, blockSize, expected, checksum)
+			}
+		}
+	}
+}
+
+func BenchmarkCalculateChecksum(b *testing.B) {
+	defer SetHashBlockSize(0)
+
+	dir := b.TempDir()
+	path := filepath.Join(dir, "large.ext")
+	const size = 64 << 20
+	writeTestFile(b, path, strings.Repeat("0123456789abcdef", size/16))
+
+	// plain io.Copy, as used before the block size was configurable
+	b.Run("io.Copy", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			if _, err = io.Copy(sha256.New(), file); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			_ = file.Close()
+		}
+	})
+	for _, blockSize := range []int{32 << 10, 1 << 20, 4 << 20} {
+		b.Run(fmt.Sprintf("block-%dk", blockSize>>10), func(b *testing.B) {
+			SetHashBlockSize(blockSize)
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := CalculateChecksum(path); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateChecksumPartial(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "large.ext")
+	contents := []byte(strings.Repeat("0123456789", 100))
+	writeTestFile(t, path, string(contents))
+
+	partial := PartialHash(HashSHA256, 100)
+	if algorithm, blockSize := partial.Partial(); algorithm != HashSHA256 || blockSize != 100 {
+		t.Errorf("Partial: %s, %d", algorithm, blockSize)
+	}
+	if _, blockSize := HashSHA256.Partial(); blockSize != 0 {
+		t.Errorf("Partial: 0 != %d", blockSize)
+	}
+
+	full, err := CalculateChecksumWith(path, HashSHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original, err := CalculateChecksumWith(path, partial)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if original == full {
+		t.Errorf("partial checksum must differ from full checksum")
+	}
+
+	checksum := func(offset int) string {
+		changed := append([]byte{}, contents...)
+		changed[offset] = 'x'
+		writeTestFile(t, path, string(changed))
+		checksum, err := CalculateChecksumWith(path, partial)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return checksum
+	}
+
+	if checksum(500) != original {
+		t.Errorf("change in the middle of the file must not be detected")
+	}
+	if checksum(50) == original {
+		t.Errorf("change in the head of the file must be detected")
+	}
+	if checksum(950) == original {
+		t.Errorf("change in the tail of the file must be detected")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checksums calculated during an update are cached next to the repo file,
+// keyed by the path, size and modification time of the file. The cache is
+// consulted before hashing a file whose metadata does not match the repo, so
+// that a time which was rounded or changed by a copy does not make repeated
+// updates hash the whole tree again. The cache holds nothing that can't be
+// recalculated and can be deleted at any time.
+
+const cacheFilename = "checksums.cache"
+const newCacheFilename = "checksums.cache.tmp"
+
+type cacheEntry struct {
+	Size      int64         `json:"size"`
+	Time      int64         `json:"time"` // nanoseconds since epoch
+	Algorithm HashAlgorithm `json:"algorithm"`
+	Checksum  string        `json:"checksum"`
+}
+
+type checksumCache struct {
+	mu       sync.Mutex
+	filename string
+	entries  map[string]*cacheEntry
+	// entries of files found during the scan; others are dropped on save
+	seen map[string]*cacheEntry
+}
+
+// loadChecksumCache reads the cache from dbDir. A missing or unreadable cache
+// is not an error; the cache just starts empty.
+func loadChecksumCache(dbDir string, logger Logger) *checksumCache {
+	cache := &checksumCache{
+		entries: map[string]*cacheEntry{},
+		seen:    map[string]*cacheEntry{},
+	}
+	if dbDir == "" {
+		return cache
+	}
+	cache.filename = filepath.Join(dbDir, cacheFilename)
+
+	data, err := os.ReadFile(cache.filename)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		logger.Warnf("ignoring corrupt checksum cache '%s': %v", cache.filename, err)
+		cache.entries = map[string]*cacheEntry{}
+	}
+	return cache
+}
+
+// get returns the cached checksum of the file, if its size and modification
+// time did not change since it was cached.
+func (c *checksumCache) get(relPath string, info os.FileInfo, algorithm HashAlgorithm) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[relPath]
+	if !ok || entry.Size != info.Size() || entry.Time != info.ModTime().UnixNano() || entry.Algorithm != algorithm {
+		return "", false
+	}
+	c.seen[relPath] = entry
+	return entry.Checksum, true
+}
+
+// put records the checksum of the file.
+func (c *checksumCache) put(relPath string, info os.FileInfo, algorithm HashAlgorithm, checksum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[relPath] = &cacheEntry{
+		Size:      info.Size(),
+		Time:      info.ModTime().UnixNano(),
+		Algorithm: algorithm,
+		Checksum:  checksum,
+	}
+}
+
+// keep carries the entry of a file that was found, but not hashed, over to
+// the saved cache.
+func (c *checksumCache) keep(relPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[relPath]; ok {
+		if _, ok := c.seen[relPath]; !ok {
+			c.seen[relPath] = entry
+		}
+	}
+}
+
+// keepIf carries entries of files for which keep returns true over to the
+// saved cache, e.g. files that were not scanned at all.
+func (c *checksumCache) keepIf(keep func(relPath string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for relPath, entry := range c.entries {
+		if _, ok := c.seen[relPath]; !ok && keep(relPath) {
+			c.seen[relPath] = entry
+		}
+	}
+}
+
+// save replaces the cache with the entries of the files found during the
+// scan. The cache is only written if the db directory exists.
+func (c *checksumCache) save() error {
+	if c.filename == "" {
+		return nil
+	}
+	dir := filepath.Dir(c.filename)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	c.mu.Lock()
+	data, err := json.Marshal(c.seen)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	newFilename := filepath.Join(dir, newCacheFilename)
+	if err := os.WriteFile(newFilename, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(newFilename, c.filename); err != nil {
+		_ = os.Remove(newFilename)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IssueKind identifies the type of problem found by CheckRepo.
+type IssueKind string
+
+// Kinds of issues reported by CheckRepo.
+const (
+	IssueEmptyHistory     IssueKind = "empty-history"
+	IssueDuplicatePath    IssueKind = "duplicate-path"
+	IssueEventOrder       IssueKind = "event-order"
+	IssueCurrentEvent     IssueKind = "current-event"
+	IssueMalformedHash    IssueKind = "malformed-checksum"
+	IssueOrphanedTempFile IssueKind = "orphaned-temp-file"
+	IssuePathCollision    IssueKind = "path-collision"
+	IssueHashCollision    IssueKind = "checksum-collision"
+	IssueDeletionPath     IssueKind = "deletion-path"
+)
+
+// Issue is a single problem found in the repository.
+type Issue struct {
+	Kind    IssueKind
+	Path    string
+	Message string
+}
+
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Kind, i.Path, i.Message)
+}
+
+// suffixes of temporary files created while saving the repo or importing files
+var tempFileSuffixes = []string{".boffin-tmp", ".boffin-old"}
+
+// isTempFile returns true if the file name is of a temporary file created
+// while importing files.
+func isTempFile(name string) bool {
+	for _, suffix := range tempFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRepo validates consistency of the repository and returns all issues
+// found. It checks the recorded history of every file, and looks for temporary
+// files left behind by interrupted operations.
+func CheckRepo(repo Boffin) []*Issue {
+	issues := []*Issue{}
+	files := repo.GetFiles()
+
+	for _, file := range files {
+		issues = append(issues, checkFile(file)...)
+	}
+	issues = append(issues, checkPaths(files)...)
+	issues = append(issues, checkChecksums(files)...)
+	issues = append(issues, checkTempFiles(repo)...)
+
+	return issues
+}
+
+func checkFile(file *FileInfo) []*Issue {
+	issues := []*Issue{}
+
+	if len(file.History) == 0 {
+		return append(issues, &Issue{
+			Kind:    IssueEmptyHistory,
+			Message: "file has no history",
+		})
+	}
+
+	path := file.Path()
+	if path == "" {
+		path = file.History[len(file.History)-1].Path
+	}
+
+	if file.History[0].Checksum == "" {
+		issues = append(issues, &Issue{
+			Kind:    IssueCurrentEvent,
+			Path:    path,
+			Message: "history starts with a deletion",
+		})
+	}
+
+	for i, event := range file.History {
+		if event.Path == "" {
+			issues = append(issues, &Issue{
+				Kind:    IssueCurrentEvent,
+				Path:    path,
+				Message: fmt.Sprintf("event %d has no path", i),
+			})
+		}
+		if i > 0 && event.Checksum == "" && event.Path != "" && file.History[i-1].Path != "" && event.Path != file.History[i-1].Path {
+			issues = append(issues, &Issue{
+				Kind:    IssueDeletionPath,
+				Path:    path,
+				Message: fmt.Sprintf("deletion event %d has path '%s' instead of '%s'", i, event.Path, file.History[i-1].Path),
+			})
+		}
+		if i > 0 && event.Time.Before(file.History[i-1].Time) {
+			issues = append(issues, &Issue{
+				Kind:    IssueEventOrder,
+				Path:    path,
+				Message: fmt.Sprintf("event %d is older than the one before it", i),
+			})
+		}
+		if event.Checksum != "" && !isValidChecksum(event.Checksum, event.HashAlgorithm()) {
+			issues = append(issues, &Issue{
+				Kind:    IssueMalformedHash,
+				Path:    path,
+				Message: fmt.Sprintf("event %d has malformed checksum '%s'", i, event.Checksum),
+			})
+		}
+	}
+
+	return issues
+}
+
+// RepairRepo fixes problems in file histories that can be fixed without
+// losing any recorded content, and returns the changes made. Events without a
+// path get the path of the events around them, deletions take the path of the
+// version they delete, and deletions at the start of the history or repeated
+// deletions are dropped. Files left without any content are dropped. Other
+// problems, e.g. events out of order, are left for CheckRepo to report.
+func RepairRepo(repo Boffin) []*Issue {
+	issues := []*Issue{}
+	files := []*FileInfo{}
+
+	for _, file := range repo.GetFiles() {
+		repaired, fixes := repairFile(file)
+		issues = append(issues, fixes...)
+		if repaired != nil {
+			files = append(files, repaired)
+		}
+	}
+
+	if len(issues) > 0 {
+		repo.SetFiles(files)
+	}
+	return issues
+}
+
+// repairFile returns the repaired file, or nil if nothing can be recovered
+// from it, together with the changes made. Events are copied before they are
+// changed, as they may be shared with other files.
+func repairFile(file *FileInfo) (*FileInfo, []*Issue) {
+	issues := []*Issue{}
+	fixed := func(kind IssueKind, path, format string, args ...interface{}) {
+		issues = append(issues, &Issue{Kind: kind, Path: path, Message: "fixed: " + fmt.Sprintf(format, args...)})
+	}
+
+	history := make([]*FileEvent, len(file.History))
+	copy(history, file.History)
+	setPath := func(i int, path string) {
+		event := *history[i]
+		event.Path = path
+		history[i] = &event
+	}
+
+	// take missing paths from the closest event before, or after if there is
+	// none before
+	lastPath := ""
+	for i, event := range history {
+		if event.Path == "" && lastPath != "" {
+			setPath(i, lastPath)
+			fixed(IssueCurrentEvent, lastPath, "event %d had no path", i)
+		}
+		lastPath = history[i].Path
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Path == "" && lastPath != "" {
+			setPath(i, lastPath)
+			fixed(IssueCurrentEvent, lastPath, "event %d had no path", i)
+		}
+		lastPath = history[i].Path
+	}
+
+	// a deletion removes the version before it, so it must have its path
+	for i := 1; i < len(history); i++ {
+		if history[i].Checksum == "" && history[i-1].Checksum != "" && history[i].Path != history[i-1].Path {
+			fixed(IssueDeletionPath, history[i-1].Path, "deletion event %d had path '%s'", i, history[i].Path)
+			setPath(i, history[i-1].Path)
+		}
+	}
+
+	// deletions that do not follow any content carry no information
+	kept := make([]*FileEvent, 0, len(history))
+	for i, event := range history {
+		if event.Checksum == "" && (len(kept) == 0 || kept[len(kept)-1].Checksum == "") {
+			fixed(IssueCurrentEvent, event.Path, "dropped deletion event %d which did not follow any content", i)
+			continue
+		}
+		kept = append(kept, event)
+	}
+
+	if len(kept) == 0 {
+		if len(file.History) == 0 {
+			fixed(IssueEmptyHistory, "", "dropped file with no history")
+		} else {
+			fixed(IssueEmptyHistory, file.History[len(file.History)-1].Path, "dropped file with no recorded content")
+		}
+		return nil, issues
+	}
+	if len(issues) == 0 {
+		return file, issues
+	}
+	return &FileInfo{History: kept, LastVerified: file.LastVerified}, issues
+}
+
+func isValidChecksum(checksum string, algorithm HashAlgorithm) bool {
+	if algorithm == HashMetadataOnly {
+		var size, modTime int64
+		n, err := fmt.Sscanf(checksum, "%d@%d", &size, &modTime)
+		return err == nil && n == 2 && checksum == fmt.Sprintf("%d@%d", size, modTime)
+	}
+	hash, err := newHash(algorithm)
+	if err != nil {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(checksum)
+	return err == nil && len(raw) == hash.Size()
+}
+
+// checkPaths looks for multiple current files with the same path, paths that
+// differ only in case, and files whose path is used as a directory by another.
+func checkPaths(files []*FileInfo) []*Issue {
+	issues := []*Issue{}
+
+	paths := []string{}
+	byPath := map[string]int{}
+	for _, file := range files {
+		if len(file.History) == 0 || file.IsDeleted() {
+			continue
+		}
+		if byPath[file.Path()] == 0 {
+			paths = append(paths, file.Path())
+		}
+		byPath[file.Path()]++
+	}
+	sort.Strings(paths)
+
+	byFoldedPath := map[string]string{}
+	for _, path := range paths {
+		if count := byPath[path]; count > 1 {
+			issues = append(issues, &Issue{
+				Kind:    IssueDuplicatePath,
+				Path:    path,
+				Message: fmt.Sprintf("%d current files have the same path", count),
+			})
+		}
+
+		folded := foldCase(path)
+		if other, ok := byFoldedPath[folded]; ok {
+			issues = append(issues, &Issue{
+				Kind:    IssuePathCollision,
+				Path:    path,
+				Message: fmt.Sprintf("differs only in case from '%s'", other),
+			})
+		} else {
+			byFoldedPath[folded] = path
+		}
+
+		for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if byPath[dir] > 0 {
+				issues = append(issues, &Issue{
+					Kind:    IssuePathCollision,
+					Path:    path,
+					Message: fmt.Sprintf("parent directory '%s' is also a file", dir),
+				})
+			}
+		}
+	}
+
+	return issues
+}
+
+// checkChecksums looks for current files with the same checksum but different
+// sizes. Such files cannot have the same content, so the checksum must have
+// been corrupted, and Diff would wrongly match them.
+func checkChecksums(files []*FileInfo) []*Issue {
+	issues := []*Issue{}
+
+	byHash := FilesToHashMap(files)
+	hashes := make([]string, 0, len(byHash))
+	for hash := range byHash {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	for _, hash := range hashes {
+		sameHash := byHash[hash]
+		for _, file := range sameHash[1:] {
+			if file.Size() != sameHash[0].Size() {
+				issues = append(issues, &Issue{
+					Kind:    IssueHashCollision,
+					Path:    file.Path(),
+					Message: fmt.Sprintf("has the same checksum as '%s', but size %d != %d", sameHash[0].Path(), file.Size(), sameHash[0].Size()),
+				})
+			}
+		}
+	}
+
+	return issues
+}
+
+// ValidateChecksums returns an error listing all current files that share a
+// checksum with another file of different size; see CheckRepo.
+func ValidateChecksums(files []*FileInfo) error {
+	issues := checkChecksums(files)
+	if len(issues) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, fmt.Sprintf("'%s' %s", issue.Path, issue.Message))
+	}
+	return fmt.Errorf("checksum collision, repository may be corrupted: %s", strings.Join(messages, "; "))
+}
+
+// checkTempFiles looks for temporary files left behind in the db dir by
+// interrupted Save, or in the base dir by interrupted import.
+func checkTempFiles(repo Boffin) []*Issue {
+	issues := []*Issue{}
+	for _, path := range FindTempFiles(repo) {
+		message := "left behind by interrupted import"
+		if filepath.Base(path) == newFilesFilename {
+			message = "left behind by interrupted save"
+		}
+		issues = append(issues, &Issue{
+			Kind:    IssueOrphanedTempFile,
+			Path:    path,
+			Message: message,
+		})
+	}
+	return issues
+}
+
+// FindTempFiles returns paths of temporary files left behind in the db dir by
+// interrupted Save, or in the base dir and roots by interrupted import. Files
+// of a save or import that is still running are returned as well.
+func FindTempFiles(repo Boffin) []string {
+	paths := []string{}
+
+	if repo.GetDbDir() != "" {
+		path := filepath.Join(repo.GetDbDir(), newFilesFilename)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	if repo.GetBaseDir() == "" {
+		return paths
+	}
+	for _, root := range scanRoots(repo) {
+		_ = filepath.Walk(root.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != root.dir && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isTempFile(info.Name()) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+
+	return paths
+}
@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testChecksum(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestCheckRepo(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dbDir, newFilesFilename), "")
+	writeTestFile(t, filepath.Join(dir, "sub", "file.ext.boffin-tmp"), "")
+
+	boffin.SetFiles([]*FileInfo{
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "ok", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("ok")},
+				&FileEvent{Path: "ok", Time: parseTime("2020-01-02T12:34:56Z")},
+				&FileEvent{Path: "ok", Size: 1, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: testChecksum("ok")},
+			},
+		},
+		{
+			History: []*FileEvent{},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "duplicate", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("duplicate-1")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "duplicate", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("duplicate-2")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "out-of-order", Size: 1, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: testChecksum("order-1")},
+				&FileEvent{Path: "out-of-order", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("order-2")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "starts-deleted", Time: parseTime("2020-01-01T12:34:56Z")},
+				&FileEvent{Path: "starts-deleted", Size: 1, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: testChecksum("deleted")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "malformed", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "not-a-checksum"},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "Case", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("case-1")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "case", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("case-2")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "ok/nested", Size: 1, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("nested")},
+			},
+		},
+	})
+
+	expected := []string{
+		string(IssueCurrentEvent) + ":starts-deleted",
+		string(IssueDuplicatePath) + ":duplicate",
+		string(IssueEmptyHistory) + ":",
+		string(IssueEventOrder) + ":out-of-order",
+		string(IssueMalformedHash) + ":malformed",
+		string(IssueOrphanedTempFile) + ":" + filepath.Join(dbDir, newFilesFilename),
+		string(IssueOrphanedTempFile) + ":" + filepath.Join(dir, "sub", "file.ext.boffin-tmp"),
+		string(IssuePathCollision) + ":case",
+		string(IssuePathCollision) + ":ok/nested",
+	}
+
+	actual := []string{}
+	for _, issue := range CheckRepo(boffin) {
+		actual = append(actual, string(issue.Kind)+":"+issue.Path)
+	}
+	sort.Strings(actual)
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("CheckRepo:\n%s", diff)
+	}
+
+	// a clean repo has no issues
+	if err = os.Remove(filepath.Join(dbDir, newFilesFilename)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Remove(filepath.Join(dir, "sub", "file.ext.boffin-tmp")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetFiles(boffin.GetFiles()[:1])
+	if issues := CheckRepo(boffin); len(issues) != 0 {
+		t.Errorf("CheckRepo: unexpected issues %v", issues)
+	}
+}
+
+func TestRepairRepo(t *testing.T) {
+	event := func(path, checksum, time string) *FileEvent {
+		return &FileEvent{Path: path, Size: 1, Time: parseTime(time), Checksum: checksum}
+	}
+	deleted := func(path, time string) *FileEvent {
+		return &FileEvent{Path: path, Time: parseTime(time)}
+	}
+	ok := &FileInfo{History: []*FileEvent{
+		event("ok", testChecksum("ok"), "2020-01-01T12:34:56Z"),
+		deleted("ok", "2020-01-02T12:34:56Z"),
+	}}
+	shared := event("", testChecksum("no-path"), "2020-01-02T12:34:56Z")
+	verified := parseTime("2020-01-05T12:34:56Z")
+	boffin := &db{files: []*FileInfo{
+		ok,
+		{History: []*FileEvent{}},
+		{History: []*FileEvent{
+			event("no-path", testChecksum("no-path-1"), "2020-01-01T12:34:56Z"),
+			shared,
+			deleted("", "2020-01-03T12:34:56Z"),
+		}},
+		{History: []*FileEvent{
+			deleted("starts-deleted", "2020-01-01T12:34:56Z"),
+			event("starts-deleted", testChecksum("starts-deleted"), "2020-01-02T12:34:56Z"),
+			deleted("starts-deleted", "2020-01-03T12:34:56Z"),
+			deleted("starts-deleted", "2020-01-04T12:34:56Z"),
+		}},
+		{History: []*FileEvent{
+			deleted("only-deleted", "2020-01-01T12:34:56Z"),
+		}},
+		{History: []*FileEvent{
+			event("old-path", testChecksum("moved"), "2020-01-01T12:34:56Z"),
+			deleted("other-path", "2020-01-02T12:34:56Z"),
+		}, LastVerified: &verified},
+		{History: []*FileEvent{
+			// content without any path can not be repaired, but is kept
+			event("", testChecksum("lost"), "2020-01-01T12:34:56Z"),
+		}},
+	}}
+
+	if fixes := RepairRepo(boffin); len(fixes) != 8 {
+		t.Errorf("RepairRepo: expected 8 fixes, got %d: %v", len(fixes), fixes)
+	}
+
+	expected := [][]*FileEvent{
+		ok.History,
+		{
+			event("no-path", testChecksum("no-path-1"), "2020-01-01T12:34:56Z"),
+			event("no-path", testChecksum("no-path"), "2020-01-02T12:34:56Z"),
+			deleted("no-path", "2020-01-03T12:34:56Z"),
+		},
+		{
+			event("starts-deleted", testChecksum("starts-deleted"), "2020-01-02T12:34:56Z"),
+			deleted("starts-deleted", "2020-01-03T12:34:56Z"),
+		},
+		{
+			event("old-path", testChecksum("moved"), "2020-01-01T12:34:56Z"),
+			deleted("old-path", "2020-01-02T12:34:56Z"),
+		},
+		{
+			event("", testChecksum("lost"), "2020-01-01T12:34:56Z"),
+		},
+	}
+	actual := [][]*FileEvent{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.History)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("RepairRepo:\n%s", diff)
+	}
+	if shared.Path != "" {
+		t.Errorf("RepairRepo: event shared with other files was changed")
+	}
+	if repaired := boffin.GetFiles()[3]; repaired.LastVerified == nil || !repaired.LastVerified.Equal(verified) {
+		t.Errorf("RepairRepo: expected last verification time to be kept, got %v", repaired.LastVerified)
+	}
+	if fixes := RepairRepo(boffin); len(fixes) != 0 {
+		t.Errorf("RepairRepo: expected no fixes on repaired repo, got %v", fixes)
+	}
+}
+
+func TestValidateChecksums(t *testing.T) {
+	files := []*FileInfo{
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("a")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "a-copy", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("a")},
+			},
+		},
+		{
+			History: []*FileEvent{
+				&FileEvent{Path: "deleted", Size: 20, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("a")},
+				&FileEvent{Path: "deleted", Time: parseTime("2020-01-02T12:34:56Z")},
+			},
+		},
+	}
+	if err := ValidateChecksums(files); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	files = append(files, &FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "corrupted", Size: 12, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("a")},
+		},
+	})
+	err := ValidateChecksums(files)
+	if err == nil || !strings.Contains(err.Error(), "'corrupted'") {
+		t.Errorf("expected error listing 'corrupted', got: %v", err)
+	}
+
+	dir := t.TempDir()
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetFiles(files)
+	if err = Update(boffin, nil); err == nil {
+		t.Errorf("expected Update to refuse repository with checksum collision")
+	}
+}
@@ -1,12 +1,33 @@
 package lib
 
 import (
+	"fmt"
+	"path/filepath"
 	"sort"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // DiffAction interface receives events when diffing two boffin repos. You can
 // implement all or only those events handlers you are interested in.
+//
+// LocalChanged and RemoteChanged receive moved flag, which is set if the file
+// was also moved or renamed, i.e. the current paths of the two files differ.
+//
+// BothDeleted is triggered for files that share history, but were deleted in
+// both repos, possibly at different times; see FileInfo.DeletedTime.
+//
+// ReAdded is triggered instead of LocalDeleted when the remote file was
+// created after the local file was deleted, i.e. the same contents were added
+// again, rather than the remote file never being deleted.
+//
+// DivergedFromAncestor is triggered when a single local and a single remote
+// file share a past version, but both changed since; see CommonAncestor.
+//
+// ConflictCase is triggered for files whose paths differ only in case within
+// one repo, together with files of the other repo at the same path, when paths
+// are matched ignoring case; see DiffOptions. Their contents may differ.
 type DiffAction interface {
 	Unchanged(localFile, remoteFile *FileInfo)
 	MetaDataChanged(localFile, remoteFile *FileInfo)
@@ -17,36 +38,136 @@ type DiffAction interface {
 	RemoteOld(remoteFile *FileInfo)
 	LocalDeleted(localFile, remoteFile *FileInfo)
 	RemoteDeleted(localFile, remoteFile *FileInfo)
-	LocalChanged(localFile, remoteFile *FileInfo)
-	RemoteChanged(localFile, remoteFile *FileInfo)
+	BothDeleted(localFile, remoteFile *FileInfo)
+	ReAdded(localFile, remoteFile *FileInfo)
+	LocalChanged(localFile, remoteFile *FileInfo, moved bool)
+	RemoteChanged(localFile, remoteFile *FileInfo, moved bool)
+	DivergedFromAncestor(localFile, remoteFile *FileInfo)
 	ConflictHash(localFile, remoteFile []*FileInfo)
 	ConflictPath(localFile, remoteFile *FileInfo)
+	ConflictCase(localFiles, remoteFiles []*FileInfo)
+}
+
+// DiffOptions control how files are matched by Diff. Zero value matches the
+// behaviour of Diff.
+type DiffOptions struct {
+	// LocalPrefix and RemotePrefix are stripped from the local and remote
+	// paths respectively before files are matched by path. This allows
+	// comparing repos that keep the same content at different depths.
+	LocalPrefix  string
+	RemotePrefix string
+	// CaseInsensitive matches paths ignoring case, e.g. when syncing with a
+	// repo on a case-insensitive file system. Files in the same repo whose
+	// paths differ only in case are reported as a conflict.
+	CaseInsensitive bool
+}
+
+func stripPathPrefix(path, prefix string) string {
+	if prefix == "" {
+		return path
+	}
+	prefix = filepath.Clean(prefix) + string(filepath.Separator)
+	return strings.TrimPrefix(path, prefix)
+}
+
+func (o *DiffOptions) localPath(file *FileInfo) string {
+	if o == nil {
+		return file.Path()
+	}
+	return o.normalizePath(stripPathPrefix(file.Path(), o.LocalPrefix))
+}
+
+func (o *DiffOptions) remotePath(file *FileInfo) string {
+	if o == nil {
+		return file.Path()
+	}
+	return o.normalizePath(stripPathPrefix(file.Path(), o.RemotePrefix))
+}
+
+func (o *DiffOptions) normalizePath(path string) string {
+	if o.CaseInsensitive {
+		return foldCase(path)
+	}
+	return path
+}
+
+// foldCase returns the same key for all paths that are equal under simple
+// Unicode case folding, i.e. for which strings.EqualFold returns true.
+func foldCase(path string) string {
+	return strings.Map(func(r rune) rune {
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < folded {
+				folded = f
+			}
+		}
+		return folded
+	}, path)
 }
 
 // Diff will compare two boffin repos, 'local' and 'remote' ones, and will
 // trigger DiffAction events for all files.
 func Diff(local, remote Boffin, action DiffAction) error {
+	return DiffWithOptions(local, remote, action, nil)
+}
+
+// DiffAgainst compares the repo, as the local one, with the remote repo; see
+// Diff.
+func (db *db) DiffAgainst(remote Boffin, action DiffAction) error {
+	return Diff(db, remote, action)
+}
+
+// DiffAgainstWithOptions is the same as DiffAgainst, but allows controlling
+// how files are matched; see DiffWithOptions.
+func (db *db) DiffAgainstWithOptions(remote Boffin, action DiffAction, opts *DiffOptions) error {
+	return DiffWithOptions(db, remote, action, opts)
+}
+
+// DiffWithOptions is the same as Diff, but allows controlling how files are
+// matched. nil options are the same as calling Diff.
+func DiffWithOptions(local, remote Boffin, action DiffAction, opts *DiffOptions) error {
 	localFiles := local.GetFiles()
 	remoteFiles := remote.GetFiles()
 	var err error
 
+	if err = validateDiffFiles(localFiles, "local"); err != nil {
+		return err
+	}
+	if err = validateDiffFiles(remoteFiles, "remote"); err != nil {
+		return err
+	}
+
+	if opts != nil && opts.CaseInsensitive {
+		localFiles, remoteFiles, _ =
+			matchCaseCollisions(localFiles, remoteFiles, action, opts)
+	}
+	// files colliding on their path would otherwise be matched arbitrarily
+	if _, err = filesToPathMapFunc(localFiles, opts.localPath); err != nil {
+		return fmt.Errorf("local: %v", err)
+	}
+	if _, err = filesToPathMapFunc(remoteFiles, opts.remotePath); err != nil {
+		return fmt.Errorf("remote: %v", err)
+	}
 	localFiles, remoteFiles, _ =
-		matchRemoteToLocalUsingPathAndCurrentHashes(localFiles, remoteFiles, action)
+		matchRemoteToLocalUsingPathAndCurrentHashes(localFiles, remoteFiles, action, opts)
 		// equal
+	localFiles, remoteFiles, _ =
+		matchSwappedPaths(localFiles, remoteFiles, action, opts)
+		// moved/renamed
 	localFiles, remoteFiles, _ =
 		matchRemoteToLocalUsingCurrentHashes(localFiles, remoteFiles, action)
 		// moved/renamed
 	localFiles, remoteFiles, _ =
-		matchCurrentRemoteToHistoricalLocalUsingHashes(localFiles, remoteFiles, action)
+		matchCurrentRemoteToHistoricalLocalUsingHashes(localFiles, remoteFiles, action, opts)
 		// moved/renamed and changed; conflict if multiple matches
 	localFiles, remoteFiles, _ =
-		matchCurrentLocalToHistoricalRemoteUsingHashed(localFiles, remoteFiles, action)
+		matchCurrentLocalToHistoricalRemoteUsingHashed(localFiles, remoteFiles, action, opts)
 		// moved/renamed and changed; conflict if multiple matches
 	localFiles, remoteFiles, _ =
 		matchUsingHistoricalHashes(localFiles, remoteFiles, action)
 		// conflict
 	localFiles, remoteFiles, _ =
-		matchUsingPath(localFiles, remoteFiles, action)
+		matchUsingPath(localFiles, remoteFiles, action, opts)
 		// conflict
 
 	for _, file := range localFiles {
@@ -67,15 +188,35 @@ func Diff(local, remote Boffin, action DiffAction) error {
 	return err
 }
 
+// validateDiffFiles makes sure that every file has a history to be matched
+// on, as hand edited or partially written repos could otherwise make the diff
+// panic. Which is either "local" or "remote", and is used in the error.
+func validateDiffFiles(files []*FileInfo, which string) error {
+	for i, file := range files {
+		if file == nil {
+			return fmt.Errorf("%s file %d is missing", which, i)
+		}
+		if len(file.History) == 0 {
+			return fmt.Errorf("%s file %d has no history", which, i)
+		}
+		for j, event := range file.History {
+			if event == nil {
+				return fmt.Errorf("%s file %d has missing event %d", which, i, j)
+			}
+		}
+	}
+	return nil
+}
+
 // Match all files that have identical paths and current hashes and report them
 // as equal/unchanged.
-func matchRemoteToLocalUsingPathAndCurrentHashes(local, remote []*FileInfo, action DiffAction) (newLocal, newRemote []*FileInfo, err error) {
+func matchRemoteToLocalUsingPathAndCurrentHashes(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
 	// sort by path to merge lists easily
 	sort.Slice(local, func(i, j int) bool {
-		return local[i].Path() < local[j].Path()
+		return opts.localPath(local[i]) < opts.localPath(local[j])
 	})
 	sort.Slice(remote, func(i, j int) bool {
-		return remote[i].Path() < remote[j].Path()
+		return opts.remotePath(remote[i]) < opts.remotePath(remote[j])
 	})
 	newLocal = make([]*FileInfo, 0, len(local))
 	newRemote = make([]*FileInfo, 0, len(remote))
@@ -83,7 +224,7 @@ func matchRemoteToLocalUsingPathAndCurrentHashes(local, remote []*FileInfo, acti
 	i, j := 0, 0
 	if len(local) > 0 && len(remote) > 0 {
 		for {
-			cmp := strings.Compare(local[i].Path(), remote[j].Path())
+			cmp := strings.Compare(opts.localPath(local[i]), opts.remotePath(remote[j]))
 			// if paths are different just mark them for further processing
 			if cmp < 0 {
 				newLocal = append(newLocal, local[i])
@@ -99,8 +240,8 @@ func matchRemoteToLocalUsingPathAndCurrentHashes(local, remote []*FileInfo, acti
 				}
 			} else {
 				// if paths match, are not deleted and checksums match, mark them equal
-				if !local[i].IsDeleted() && !remote[j].IsDeleted() && local[i].Checksum() == remote[j].Checksum() {
-					if local[i].Time() != remote[j].Time() {
+				if !local[i].IsDeleted() && !remote[j].IsDeleted() && local[i].checksumKey() == remote[j].checksumKey() {
+					if !local[i].Time().Equal(remote[j].Time()) {
 						action.MetaDataChanged(local[i], remote[j])
 					} else {
 						action.Unchanged(local[i], remote[j])
@@ -127,6 +268,69 @@ func matchRemoteToLocalUsingPathAndCurrentHashes(local, remote []*FileInfo, acti
 	return newLocal, newRemote, nil
 }
 
+// Match pairs of files that swapped paths, i.e. local files at paths A and B
+// whose contents are found in remote at paths B and A respectively, and report
+// each as moved. Matching on both paths keeps the pair apart from other copies
+// of the same contents, which would otherwise turn the swap into conflicts.
+func matchSwappedPaths(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
+	localByPath, err := filesToPathMapFunc(local, opts.localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteByPath, err := filesToPathMapFunc(remote, opts.remotePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteByHash := FilesToHashMap(remote)
+	for _, files := range remoteByHash {
+		sort.Slice(files, func(i, j int) bool {
+			return opts.remotePath(files[i]) < opts.remotePath(files[j])
+		})
+	}
+
+	matched := map[*FileInfo]bool{}
+	for _, pathA := range sortedKeys(localByPath) {
+		localA := localByPath[pathA]
+		remoteA, found := remoteByPath[pathA]
+		if !found || matched[localA] || matched[remoteA] {
+			continue
+		}
+		if localA.checksumKey() == remoteA.checksumKey() {
+			continue
+		}
+		for _, remoteB := range remoteByHash[localA.checksumKey()] {
+			pathB := opts.remotePath(remoteB)
+			if pathB == pathA || matched[remoteB] {
+				continue
+			}
+			localB, found := localByPath[pathB]
+			if !found || matched[localB] || localB.checksumKey() != remoteA.checksumKey() {
+				continue
+			}
+			action.Moved(localA, remoteB)
+			action.Moved(localB, remoteA)
+			matched[localA], matched[localB] = true, true
+			matched[remoteA], matched[remoteB] = true, true
+			break
+		}
+	}
+
+	newLocal = make([]*FileInfo, 0, len(local))
+	for _, file := range local {
+		if !matched[file] {
+			newLocal = append(newLocal, file)
+		}
+	}
+	newRemote = make([]*FileInfo, 0, len(remote))
+	for _, file := range remote {
+		if !matched[file] {
+			newRemote = append(newRemote, file)
+		}
+	}
+
+	return newLocal, newRemote, nil
+}
+
 // Match all files that have identical current hashes but different current
 // paths, and mark them as moved/renamed. In case of multiple matches, report
 // them as conflict.
@@ -149,7 +353,8 @@ func matchRemoteToLocalUsingCurrentHashes(local, remote []*FileInfo, action Diff
 	localByHash := FilesToHashMap(local)
 	remoteByHash := FilesToHashMap(remote)
 
-	for hash, localFiles := range localByHash {
+	for _, hash := range sortedKeys(localByHash) {
+		localFiles := localByHash[hash]
 		remoteFiles, match := remoteByHash[hash]
 		if match {
 			if len(localFiles) == 1 && len(remoteFiles) == 1 {
@@ -165,8 +370,8 @@ func matchRemoteToLocalUsingCurrentHashes(local, remote []*FileInfo, action Diff
 		}
 	}
 
-	for _, remoteFiles := range remoteByHash {
-		newRemote = append(newRemote, remoteFiles...)
+	for _, hash := range sortedKeys(remoteByHash) {
+		newRemote = append(newRemote, remoteByHash[hash]...)
 	}
 
 	return newLocal, newRemote, nil
@@ -176,7 +381,7 @@ func matchRemoteToLocalUsingCurrentHashes(local, remote []*FileInfo, action Diff
 // historical local hash, and mark remote file as a changed version of the local
 // file. In case that the same hash appears multiple times on either remote or
 // local side, mark them as conflicts.
-func matchCurrentRemoteToHistoricalLocalUsingHashes(local, remote []*FileInfo, action DiffAction) (newLocal, newRemote []*FileInfo, err error) {
+func matchCurrentRemoteToHistoricalLocalUsingHashes(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
 	// copy all deleted files as we will not be handling them
 	newLocal = make([]*FileInfo, 0, len(local))
 
@@ -190,14 +395,22 @@ func matchCurrentRemoteToHistoricalLocalUsingHashes(local, remote []*FileInfo, a
 	localByHash := filesToHistoricHashMap(local)
 	remoteByHash := FilesToHashMap(remote)
 
-	for remoteHash, remoteFiles := range remoteByHash {
+	for _, remoteHash := range sortedKeys(remoteByHash) {
+		remoteFiles := remoteByHash[remoteHash]
 		localFileIndices, ok := localByHash[remoteHash]
 		if ok {
 			if len(localFileIndices) == 1 && len(remoteFiles) == 1 {
-				if local[localFileIndices[0]].IsDeleted() {
-					action.LocalDeleted(local[localFileIndices[0]], remoteFiles[0])
+				if localFile := local[localFileIndices[0]]; localFile.IsDeleted() {
+					if remoteFiles[0].Time().After(localFile.DeletedTime()) {
+						// re-added files are matched by historical hashes
+						newRemote = append(newRemote, remoteFiles...)
+						continue
+					}
+					action.LocalDeleted(localFile, remoteFiles[0])
 				} else {
-					action.LocalChanged(local[localFileIndices[0]], remoteFiles[0])
+					localFile := local[localFileIndices[0]]
+					moved := opts.localPath(localFile) != opts.remotePath(remoteFiles[0])
+					action.LocalChanged(localFile, remoteFiles[0], moved)
 				}
 				local[localFileIndices[0]] = nil
 			} else {
@@ -226,7 +439,7 @@ func matchCurrentRemoteToHistoricalLocalUsingHashes(local, remote []*FileInfo, a
 // historical remote hash, and mark local file as a changed version of the
 // remote file. In case that the same hash appears multiple times on either
 // remote or local side, mark them as conflicts.
-func matchCurrentLocalToHistoricalRemoteUsingHashed(local, remote []*FileInfo, action DiffAction) (newLocal, newRemote []*FileInfo, err error) {
+func matchCurrentLocalToHistoricalRemoteUsingHashed(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
 	// copy all deleted files as we will not be handling them
 	newLocal = make([]*FileInfo, 0, len(local))
 	for _, file := range local {
@@ -240,14 +453,17 @@ func matchCurrentLocalToHistoricalRemoteUsingHashed(local, remote []*FileInfo, a
 	localByHash := FilesToHashMap(local)
 	remoteByHash := filesToHistoricHashMap(remote)
 
-	for localHash, localFiles := range localByHash {
+	for _, localHash := range sortedKeys(localByHash) {
+		localFiles := localByHash[localHash]
 		remoteFileIndices, ok := remoteByHash[localHash]
 		if ok {
 			if len(remoteFileIndices) == 1 && len(localFiles) == 1 {
 				if remote[remoteFileIndices[0]].IsDeleted() {
 					action.RemoteDeleted(localFiles[0], remote[remoteFileIndices[0]])
 				} else {
-					action.RemoteChanged(localFiles[0], remote[remoteFileIndices[0]])
+					remoteFile := remote[remoteFileIndices[0]]
+					moved := opts.localPath(localFiles[0]) != opts.remotePath(remoteFile)
+					action.RemoteChanged(localFiles[0], remoteFile, moved)
 				}
 				remote[remoteFileIndices[0]] = nil
 			} else {
@@ -272,6 +488,14 @@ func matchCurrentLocalToHistoricalRemoteUsingHashed(local, remote []*FileInfo, a
 	return newLocal, newRemote, nil
 }
 
+// Match all files that share any historical hash with files in the other repo
+// and report them as conflicts. Files linked through several hashes are
+// reported together, so every file is in exactly one group. Groups are
+// reported in order of their first hash, with files in their original order.
+// Groups of a single live local and remote file have diverged from their
+// common ancestor, as neither current hash appears in the other history.
+// A single deleted local file and a live remote file created after the
+// deletion were added again.
 func matchUsingHistoricalHashes(local, remote []*FileInfo, action DiffAction) (newLocal, newRemote []*FileInfo, err error) {
 	newLocal = make([]*FileInfo, 0, len(local))
 	newRemote = make([]*FileInfo, 0, len(remote))
@@ -279,38 +503,89 @@ func matchUsingHistoricalHashes(local, remote []*FileInfo, action DiffAction) (n
 	localByHash := filesToHistoricHashMap(local)
 	remoteByHash := filesToHistoricHashMap(remote)
 
-	for localHash, localFileIndices := range localByHash {
-		remoteFileIndices, ok := remoteByHash[localHash]
-		if ok {
-			if len(localFileIndices) == 1 && len(remoteFileIndices) == 1 {
-				localFileIndex := localFileIndices[0]
-				remoteFileIndex := remoteFileIndices[0]
-				if local[localFileIndex].IsDeleted() && remote[remoteFileIndex].IsDeleted() {
-					action.Unchanged(local[localFileIndex], remote[remoteFileIndex])
-					local[localFileIndex] = nil
-					remote[remoteFileIndex] = nil
-					continue
-				}
-			}
+	// join files sharing a hash into groups; remote files are numbered after
+	// the local ones
+	groups := newDisjointSet(len(local) + len(remote))
+	hashes := []string{}
+	for _, hash := range sortedKeys(localByHash) {
+		remoteFileIndices, ok := remoteByHash[hash]
+		if !ok {
+			continue
+		}
+		hashes = append(hashes, hash)
+		first := localByHash[hash][0]
+		for _, localFileIndex := range localByHash[hash] {
+			groups.union(first, localFileIndex)
+		}
+		for _, remoteFileIndex := range remoteFileIndices {
+			groups.union(first, len(local)+remoteFileIndex)
+		}
+	}
 
-			localFiles := make([]*FileInfo, 0, len(localFileIndices))
-			for _, localFileIndex := range localFileIndices {
-				if local[localFileIndex] != nil {
-					localFiles = append(localFiles, local[localFileIndex])
-					local[localFileIndex] = nil
-				}
-			}
+	// collect members of each group; group order is decided by the first hash
+	// seen, and file indices are added in increasing order
+	order := []int{}
+	localMembers := map[int][]int{}
+	remoteMembers := map[int][]int{}
+	for _, hash := range hashes {
+		root := groups.find(localByHash[hash][0])
+		if _, seen := localMembers[root]; !seen {
+			order = append(order, root)
+			localMembers[root] = []int{}
+		}
+	}
+	for i := range local {
+		if root := groups.find(i); groups.size(root) > 1 {
+			localMembers[root] = append(localMembers[root], i)
+		}
+	}
+	for j := range remote {
+		if root := groups.find(len(local) + j); groups.size(root) > 1 {
+			remoteMembers[root] = append(remoteMembers[root], j)
+		}
+	}
 
-			remoteFiles := make([]*FileInfo, 0, len(remoteFileIndices))
-			for _, remoteFileIndex := range remoteFileIndices {
-				if remote[remoteFileIndex] != nil {
-					remoteFiles = append(remoteFiles, remote[remoteFileIndex])
-					remote[remoteFileIndex] = nil
-				}
+	for _, root := range order {
+		localFileIndices := localMembers[root]
+		remoteFileIndices := remoteMembers[root]
+
+		if len(localFileIndices) == 1 && len(remoteFileIndices) == 1 {
+			localFileIndex := localFileIndices[0]
+			remoteFileIndex := remoteFileIndices[0]
+			if local[localFileIndex].IsDeleted() && remote[remoteFileIndex].IsDeleted() {
+				action.BothDeleted(local[localFileIndex], remote[remoteFileIndex])
+				local[localFileIndex] = nil
+				remote[remoteFileIndex] = nil
+				continue
+			}
+			if !local[localFileIndex].IsDeleted() && !remote[remoteFileIndex].IsDeleted() {
+				action.DivergedFromAncestor(local[localFileIndex], remote[remoteFileIndex])
+				local[localFileIndex] = nil
+				remote[remoteFileIndex] = nil
+				continue
 			}
+			if local[localFileIndex].IsDeleted() && !remote[remoteFileIndex].IsDeleted() &&
+				remote[remoteFileIndex].Time().After(local[localFileIndex].DeletedTime()) {
+				action.ReAdded(local[localFileIndex], remote[remoteFileIndex])
+				local[localFileIndex] = nil
+				remote[remoteFileIndex] = nil
+				continue
+			}
+		}
+
+		localFiles := make([]*FileInfo, 0, len(localFileIndices))
+		for _, localFileIndex := range localFileIndices {
+			localFiles = append(localFiles, local[localFileIndex])
+			local[localFileIndex] = nil
+		}
 
-			action.ConflictHash(localFiles, remoteFiles)
+		remoteFiles := make([]*FileInfo, 0, len(remoteFileIndices))
+		for _, remoteFileIndex := range remoteFileIndices {
+			remoteFiles = append(remoteFiles, remote[remoteFileIndex])
+			remote[remoteFileIndex] = nil
 		}
+
+		action.ConflictHash(localFiles, remoteFiles)
 	}
 
 	for _, localFile := range local {
@@ -327,7 +602,7 @@ func matchUsingHistoricalHashes(local, remote []*FileInfo, action DiffAction) (n
 	return newLocal, newRemote, nil
 }
 
-func matchUsingPath(local, remote []*FileInfo, action DiffAction) (newLocal, newRemote []*FileInfo, err error) {
+func matchUsingPath(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
 	newLocal = make([]*FileInfo, 0, len(local))
 	for _, file := range local {
 		if file.IsDeleted() {
@@ -342,10 +617,17 @@ func matchUsingPath(local, remote []*FileInfo, action DiffAction) (newLocal, new
 		}
 	}
 
-	localByPath := filesToPathMap(local)
-	remoteByPath := filesToPathMap(remote)
+	localByPath, err := filesToPathMapFunc(local, opts.localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteByPath, err := filesToPathMapFunc(remote, opts.remotePath)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for localPath, localFile := range localByPath {
+	for _, localPath := range sortedKeys(localByPath) {
+		localFile := localByPath[localPath]
 		remoteFile, ok := remoteByPath[localPath]
 		if ok {
 			action.ConflictPath(localFile, remoteFile)
@@ -357,23 +639,146 @@ func matchUsingPath(local, remote []*FileInfo, action DiffAction) (newLocal, new
 	}
 
 	// pass through any unmatched files
-	for _, remoteFile := range remoteByPath {
-		newRemote = append(newRemote, remoteFile)
+	for _, remotePath := range sortedKeys(remoteByPath) {
+		newRemote = append(newRemote, remoteByPath[remotePath])
+	}
+
+	return newLocal, newRemote, nil
+}
+
+// Find files whose paths differ only in case within the same repo, and report
+// them, together with files from the other repo with the same path, as case
+// conflict. Otherwise they would collide when matching by path.
+func matchCaseCollisions(local, remote []*FileInfo, action DiffAction, opts *DiffOptions) (newLocal, newRemote []*FileInfo, err error) {
+	localByPath := map[string][]*FileInfo{}
+	for _, file := range local {
+		if !file.IsDeleted() {
+			path := opts.localPath(file)
+			localByPath[path] = append(localByPath[path], file)
+		}
+	}
+	remoteByPath := map[string][]*FileInfo{}
+	for _, file := range remote {
+		if !file.IsDeleted() {
+			path := opts.remotePath(file)
+			remoteByPath[path] = append(remoteByPath[path], file)
+		}
+	}
+
+	collisions := []string{}
+	for path, files := range localByPath {
+		if len(files) > 1 {
+			collisions = append(collisions, path)
+		}
+	}
+	for path, files := range remoteByPath {
+		if len(files) > 1 && len(localByPath[path]) <= 1 {
+			collisions = append(collisions, path)
+		}
+	}
+	sort.Strings(collisions)
+
+	conflicting := map[*FileInfo]bool{}
+	for _, path := range collisions {
+		for _, file := range localByPath[path] {
+			conflicting[file] = true
+		}
+		for _, file := range remoteByPath[path] {
+			conflicting[file] = true
+		}
+		action.ConflictCase(localByPath[path], remoteByPath[path])
+	}
+
+	newLocal = make([]*FileInfo, 0, len(local))
+	for _, file := range local {
+		if !conflicting[file] {
+			newLocal = append(newLocal, file)
+		}
+	}
+	newRemote = make([]*FileInfo, 0, len(remote))
+	for _, file := range remote {
+		if !conflicting[file] {
+			newRemote = append(newRemote, file)
+		}
 	}
 
 	return newLocal, newRemote, nil
 }
 
+// sortedKeys returns keys of the map in sorted order, so that files are
+// matched, and actions triggered, in the same order every time.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func filesToPathMap(files []*FileInfo) map[string]*FileInfo {
+	// only distinct paths can collide, so there is never an error
+	fileMap, _ := filesToPathMapFunc(files, (*FileInfo).Path)
+	return fileMap
+}
+
+// filesToPathMapFunc maps live files by the path returned by the function.
+// Returns an error if two files with different paths map to the same one,
+// e.g. when a stripped prefix makes them collide, as one of them would be
+// lost otherwise. Files with the same path are tolerated, and the last one
+// is kept, as hand edited or older repos can contain them.
+func filesToPathMapFunc(files []*FileInfo, path func(*FileInfo) string) (map[string]*FileInfo, error) {
 	fileMap := make(map[string]*FileInfo)
 
 	for _, file := range files {
-		if !file.IsDeleted() {
-			fileMap[file.Path()] = file
+		if file.IsDeleted() {
+			continue
+		}
+		key := path(file)
+		if other, found := fileMap[key]; found && other.Path() != file.Path() {
+			return nil, fmt.Errorf("'%s' and '%s' both match files as '%s'", other.Path(), file.Path(), key)
 		}
+		fileMap[key] = file
 	}
 
-	return fileMap
+	return fileMap, nil
+}
+
+// checksumKey identifies the contents of the file. Checksums calculated with
+// different algorithms, including partial and full hashes, never match. Keys
+// of files using the default algorithm are just the checksums. Metadata-only
+// checksums do not identify the contents, so they match only at the same path.
+func (e *FileEvent) checksumKey() string {
+	switch e.HashAlgorithm() {
+	case DefaultHashAlgorithm:
+		return e.Checksum
+	case HashMetadataOnly:
+		return string(e.Algorithm) + ":" + e.Path + ":" + e.Checksum
+	}
+	return string(e.Algorithm) + ":" + e.Checksum
+}
+
+func (fi *FileInfo) checksumKey() string {
+	return fi.History[len(fi.History)-1].checksumKey()
+}
+
+// CommonAncestor returns the most recent version of the local file whose
+// contents also appear in the history of the remote file, or nil if the two
+// files share no history.
+func CommonAncestor(localFile, remoteFile *FileInfo) *FileEvent {
+	remoteKeys := map[string]bool{}
+	for _, event := range remoteFile.History {
+		if event.Checksum != "" {
+			remoteKeys[event.checksumKey()] = true
+		}
+	}
+	for i := len(localFile.History) - 1; i >= 0; i-- {
+		event := localFile.History[i]
+		if event.Checksum != "" && remoteKeys[event.checksumKey()] {
+			return event
+		}
+	}
+	return nil
 }
 
 // FilesToHashMap ...
@@ -382,11 +787,12 @@ func FilesToHashMap(files []*FileInfo) map[string][]*FileInfo {
 
 	for _, file := range files {
 		if !file.IsDeleted() {
-			fi, found := fileMap[file.Checksum()]
+			key := file.checksumKey()
+			fi, found := fileMap[key]
 			if found {
-				fileMap[file.Checksum()] = append(fi, file)
+				fileMap[key] = append(fi, file)
 			} else {
-				fileMap[file.Checksum()] = []*FileInfo{file}
+				fileMap[key] = []*FileInfo{file}
 			}
 		}
 	}
@@ -401,7 +807,8 @@ func filesToHistoricHashMap(files []*FileInfo) map[string][]int {
 	for fileIndex, file := range files {
 		for _, event := range file.History {
 			if event.Checksum != "" {
-				fi, found := fileMap[event.Checksum]
+				key := event.checksumKey()
+				fi, found := fileMap[key]
 				// does the checksum exist in the list
 				if found {
 					found = false
@@ -413,10 +820,10 @@ func filesToHistoricHashMap(files []*FileInfo) map[string][]int {
 						}
 					}
 					if !found {
-						fileMap[event.Checksum] = append(fi, fileIndex)
+						fileMap[key] = append(fi, fileIndex)
 					}
 				} else {
-					fileMap[event.Checksum] = []int{fileIndex}
+					fileMap[key] = []int{fileIndex}
 				}
 			}
 		}
@@ -424,3 +831,264 @@ func filesToHistoricHashMap(files []*FileInfo) map[string][]int {
 
 	return fileMap
 }
+
+// HistoricDuplicate is a live file whose content was in the past also held by
+// other files.
+type HistoricDuplicate struct {
+	File    *FileInfo
+	Matches []*FileInfo
+}
+
+// FindHistoricDuplicates returns live files whose current content matches a
+// past version of any other file, which usually means a duplicate was added
+// again. Files whose current content matches are not reported, as they are
+// plain duplicates. Result is sorted by path.
+func FindHistoricDuplicates(files []*FileInfo) []HistoricDuplicate {
+	var retval []HistoricDuplicate
+
+	historic := filesToHistoricHashMap(files)
+	for fileIndex, file := range files {
+		if file.IsDeleted() {
+			continue
+		}
+		key := file.checksumKey()
+		var matches []*FileInfo
+		for _, otherIndex := range historic[key] {
+			other := files[otherIndex]
+			if otherIndex != fileIndex && (other.IsDeleted() || other.checksumKey() != key) {
+				matches = append(matches, other)
+			}
+		}
+		if len(matches) > 0 {
+			retval = append(retval, HistoricDuplicate{File: file, Matches: matches})
+		}
+	}
+
+	sort.Slice(retval, func(i, j int) bool {
+		return retval[i].File.Path() < retval[j].File.Path()
+	})
+	return retval
+}
+
+// DiffPair is a local file and the remote file it was matched with. Moved is
+// set for changed files that were also moved; see DiffAction.
+type DiffPair struct {
+	Local  *FileInfo
+	Remote *FileInfo
+	Moved  bool
+}
+
+// DiffConflict holds all local and remote files that share the same checksum
+// and could not be matched one to one.
+type DiffConflict struct {
+	Local  []*FileInfo
+	Remote []*FileInfo
+}
+
+// DiffReport holds the results of Diff, categorized by the DiffAction method
+// that would have received them.
+type DiffReport struct {
+	Unchanged       []*DiffPair
+	MetaDataChanged []*DiffPair
+	Moved           []*DiffPair
+	LocalOnly       []*FileInfo
+	LocalOld        []*FileInfo
+	RemoteOnly      []*FileInfo
+	RemoteOld       []*FileInfo
+	LocalDeleted    []*DiffPair
+	RemoteDeleted   []*DiffPair
+	BothDeleted     []*DiffPair
+	ReAdded         []*DiffPair
+	LocalChanged    []*DiffPair
+	RemoteChanged   []*DiffPair
+	Diverged        []*DiffPair
+	ConflictHash    []*DiffConflict
+	ConflictPath    []*DiffPair
+	ConflictCase    []*DiffConflict
+}
+
+// CollectDiff compares two boffin repos, same as Diff, and returns all results
+// at once instead of reporting them one by one.
+func CollectDiff(local, remote Boffin) (*DiffReport, error) {
+	return CollectDiffWithOptions(local, remote, nil)
+}
+
+// CollectDiffWithOptions is the same as CollectDiff, but allows controlling
+// how files are matched; see DiffWithOptions.
+func CollectDiffWithOptions(local, remote Boffin, opts *DiffOptions) (*DiffReport, error) {
+	action := &collectAction{
+		report: &DiffReport{},
+	}
+	if err := DiffWithOptions(local, remote, action, opts); err != nil {
+		return nil, err
+	}
+	return action.report, nil
+}
+
+// collectAction is DiffAction that collects all results into a DiffReport.
+type collectAction struct {
+	report *DiffReport
+}
+
+func (a *collectAction) Unchanged(localFile, remoteFile *FileInfo) {
+	a.report.Unchanged = append(a.report.Unchanged, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) MetaDataChanged(localFile, remoteFile *FileInfo) {
+	a.report.MetaDataChanged = append(a.report.MetaDataChanged, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) Moved(localFile, remoteFile *FileInfo) {
+	a.report.Moved = append(a.report.Moved, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) LocalOnly(localFile *FileInfo) {
+	a.report.LocalOnly = append(a.report.LocalOnly, localFile)
+}
+
+func (a *collectAction) LocalOld(localFile *FileInfo) {
+	a.report.LocalOld = append(a.report.LocalOld, localFile)
+}
+
+func (a *collectAction) RemoteOnly(remoteFile *FileInfo) {
+	a.report.RemoteOnly = append(a.report.RemoteOnly, remoteFile)
+}
+
+func (a *collectAction) RemoteOld(remoteFile *FileInfo) {
+	a.report.RemoteOld = append(a.report.RemoteOld, remoteFile)
+}
+
+func (a *collectAction) LocalDeleted(localFile, remoteFile *FileInfo) {
+	a.report.LocalDeleted = append(a.report.LocalDeleted, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) RemoteDeleted(localFile, remoteFile *FileInfo) {
+	a.report.RemoteDeleted = append(a.report.RemoteDeleted, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) BothDeleted(localFile, remoteFile *FileInfo) {
+	a.report.BothDeleted = append(a.report.BothDeleted, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) ReAdded(localFile, remoteFile *FileInfo) {
+	a.report.ReAdded = append(a.report.ReAdded, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) LocalChanged(localFile, remoteFile *FileInfo, moved bool) {
+	a.report.LocalChanged = append(a.report.LocalChanged, &DiffPair{Local: localFile, Remote: remoteFile, Moved: moved})
+}
+
+func (a *collectAction) RemoteChanged(localFile, remoteFile *FileInfo, moved bool) {
+	a.report.RemoteChanged = append(a.report.RemoteChanged, &DiffPair{Local: localFile, Remote: remoteFile, Moved: moved})
+}
+
+func (a *collectAction) DivergedFromAncestor(localFile, remoteFile *FileInfo) {
+	a.report.Diverged = append(a.report.Diverged, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
+	a.report.ConflictHash = append(a.report.ConflictHash, &DiffConflict{Local: localFiles, Remote: remoteFiles})
+}
+
+func (a *collectAction) ConflictPath(localFile, remoteFile *FileInfo) {
+	a.report.ConflictPath = append(a.report.ConflictPath, &DiffPair{Local: localFile, Remote: remoteFile})
+}
+
+func (a *collectAction) ConflictCase(localFiles, remoteFiles []*FileInfo) {
+	a.report.ConflictCase = append(a.report.ConflictCase, &DiffConflict{Local: localFiles, Remote: remoteFiles})
+}
+
+// NewerOnly wraps the action so that remote versions which are not strictly
+// newer than the local ones are skipped. RemoteChanged, ConflictPath and
+// DivergedFromAncestor are passed on only if the remote file is newer than
+// the local file, and ConflictHash only with remote files newer than all live
+// local files, if there are any. Other events are passed on unchanged.
+func NewerOnly(action DiffAction) DiffAction {
+	return &newerOnlyAction{DiffAction: action}
+}
+
+type newerOnlyAction struct {
+	DiffAction
+}
+
+func (a *newerOnlyAction) RemoteChanged(localFile, remoteFile *FileInfo, moved bool) {
+	if remoteFile.Time().After(localFile.Time()) {
+		a.DiffAction.RemoteChanged(localFile, remoteFile, moved)
+	}
+}
+
+func (a *newerOnlyAction) DivergedFromAncestor(localFile, remoteFile *FileInfo) {
+	if remoteFile.Time().After(localFile.Time()) {
+		a.DiffAction.DivergedFromAncestor(localFile, remoteFile)
+	}
+}
+
+func (a *newerOnlyAction) ConflictPath(localFile, remoteFile *FileInfo) {
+	if remoteFile.Time().After(localFile.Time()) {
+		a.DiffAction.ConflictPath(localFile, remoteFile)
+	}
+}
+
+func (a *newerOnlyAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
+	var newest time.Time
+	for _, file := range localFiles {
+		if !file.IsDeleted() && file.Time().After(newest) {
+			newest = file.Time()
+		}
+	}
+	newer := []*FileInfo{}
+	for _, file := range remoteFiles {
+		if !file.IsDeleted() && file.Time().After(newest) {
+			newer = append(newer, file)
+		}
+	}
+	if len(newer) > 0 {
+		a.DiffAction.ConflictHash(localFiles, newer)
+	}
+}
+
+// disjointSet groups elements identified by their index.
+type disjointSet struct {
+	parent []int
+	sizes  []int
+}
+
+func newDisjointSet(n int) *disjointSet {
+	set := &disjointSet{
+		parent: make([]int, n),
+		sizes:  make([]int, n),
+	}
+	for i := range set.parent {
+		set.parent[i] = i
+		set.sizes[i] = 1
+	}
+	return set
+}
+
+// find returns the representative element of the group containing i.
+func (s *disjointSet) find(i int) int {
+	for s.parent[i] != i {
+		s.parent[i] = s.parent[s.parent[i]]
+		i = s.parent[i]
+	}
+	return i
+}
+
+// union joins groups containing i and j.
+func (s *disjointSet) union(i, j int) {
+	i, j = s.find(i), s.find(j)
+	if i == j {
+		return
+	}
+	if s.sizes[i] < s.sizes[j] {
+		i, j = j, i
+	}
+	s.parent[j] = i
+	s.sizes[i] += s.sizes[j]
+}
+
+// size returns the number of elements in the group whose representative is
+// root.
+func (s *disjointSet) size(root int) int {
+	return s.sizes[root]
+}
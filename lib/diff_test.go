@@ -1,6 +1,8 @@
 package lib
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,6 +13,32 @@ import (
 func TestDiff(t *testing.T) {
 	var local Boffin = &db{
 		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{
+						Path:     "local-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-01T12:34:56Z"),
+						Checksum: "local-changed-same-path-hash-1",
+					},
+					&FileEvent{
+						Path:     "local-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-02T12:34:56Z"),
+						Checksum: "local-changed-same-path-hash-2",
+					},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{
+						Path:     "remote-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-01T12:34:56Z"),
+						Checksum: "remote-changed-same-path-hash-1",
+					},
+				},
+			},
 			{
 				History: []*FileEvent{
 					&FileEvent{
@@ -301,6 +329,32 @@ func TestDiff(t *testing.T) {
 	}
 	var remote Boffin = &db{
 		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{
+						Path:     "local-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-01T12:34:56Z"),
+						Checksum: "local-changed-same-path-hash-1",
+					},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{
+						Path:     "remote-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-01T12:34:56Z"),
+						Checksum: "remote-changed-same-path-hash-1",
+					},
+					&FileEvent{
+						Path:     "remote-changed-same-path",
+						Size:     10,
+						Time:     parseTime("2020-01-02T12:34:56Z"),
+						Checksum: "remote-changed-same-path-hash-2",
+					},
+				},
+			},
 			{
 				History: []*FileEvent{
 					&FileEvent{
@@ -579,20 +633,22 @@ func TestDiff(t *testing.T) {
 	}
 
 	expected := []*result{
-		{Result: "conflict", Local: []string{"both-changed-conflict-1-l"}, Remote: []string{"both-changed-conflict-1-r"}},
 		{Result: "conflict", Local: []string{"both-changed-conflict-2-1-l", "both-changed-conflict-2-2-l"}, Remote: []string{"both-changed-conflict-2-1-r", "both-changed-conflict-2-2-r"}},
 		{Result: "conflict", Local: []string{"local-changed-conflict-l-1-1"}, Remote: []string{"local-changed-conflict-r-1-1", "local-changed-conflict-r-1-2"}},
 		{Result: "conflict", Local: []string{"remote-changed-conflict-l-1-1", "remote-changed-conflict-l-1-2"}, Remote: []string{"remote-changed-conflict-r-1-1"}},
 		{Result: "conflict", Local: []string{"same-name-conflict"}, Remote: []string{"same-name-conflict"}},
-		{Result: "local-changed", Local: []string{"local-changed-l-1-3"}, Remote: []string{"local-changed-r-1-2"}},
-		{Result: "local-changed", Local: []string{"local-changed-l-2-3"}, Remote: []string{"local-changed-r-2-1"}},
+		{Result: "diverged", Local: []string{"both-changed-conflict-1-l"}, Remote: []string{"both-changed-conflict-1-r"}},
+		{Result: "local-changed", Local: []string{"local-changed-l-1-3"}, Remote: []string{"local-changed-r-1-2"}, Moved: true},
+		{Result: "local-changed", Local: []string{"local-changed-l-2-3"}, Remote: []string{"local-changed-r-2-1"}, Moved: true},
+		{Result: "local-changed", Local: []string{"local-changed-same-path"}, Remote: []string{"local-changed-same-path"}},
 		{Result: "local-deleted", Local: []string{"local-deleted-l"}, Remote: []string{"local-deleted-r"}},
 		{Result: "local-old", Local: []string{"hanging-delete-local"}},
 		{Result: "local-only", Local: []string{"added-local"}},
 		{Result: "local-only", Local: []string{"added-local2"}},
 		{Result: "moved", Local: []string{"renamed-local"}, Remote: []string{"renamed-remote"}},
-		{Result: "remote-changed", Local: []string{"remote-changed-l-1-2"}, Remote: []string{"remote-changed-r-1-3"}},
-		{Result: "remote-changed", Local: []string{"remote-changed-l-2-1"}, Remote: []string{"remote-changed-r-2-3"}},
+		{Result: "remote-changed", Local: []string{"remote-changed-l-1-2"}, Remote: []string{"remote-changed-r-1-3"}, Moved: true},
+		{Result: "remote-changed", Local: []string{"remote-changed-l-2-1"}, Remote: []string{"remote-changed-r-2-3"}, Moved: true},
+		{Result: "remote-changed", Local: []string{"remote-changed-same-path"}, Remote: []string{"remote-changed-same-path"}},
 		{Result: "remote-deleted", Local: []string{"remote-deleted-l"}, Remote: []string{"remote-deleted-r"}},
 		{Result: "remote-old", Remote: []string{"hanging-delete-remote"}},
 		{Result: "remote-only", Remote: []string{"added-remote"}},
@@ -618,3 +674,754 @@ func TestDiff(t *testing.T) {
 		t.Errorf("Diff:\n%s", diff)
 	}
 }
+
+func TestDiffWithPrefix(t *testing.T) {
+	newLocal := func() Boffin {
+		return &db{
+			files: []*FileInfo{
+				{
+					History: []*FileEvent{
+						&FileEvent{Path: "photos/equal", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "equal-hash"},
+					},
+				},
+				{
+					History: []*FileEvent{
+						&FileEvent{Path: "photos/same-name-conflict", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "conflict-hash-l"},
+					},
+				},
+			},
+		}
+	}
+	newRemote := func() Boffin {
+		return &db{
+			files: []*FileInfo{
+				{
+					History: []*FileEvent{
+						&FileEvent{Path: "backup/equal", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "equal-hash"},
+					},
+				},
+				{
+					History: []*FileEvent{
+						&FileEvent{Path: "backup/same-name-conflict", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "conflict-hash-r"},
+					},
+				},
+			},
+		}
+	}
+
+	{ // without prefixes the paths do not match
+		expected := []*result{
+			{Result: "local-only", Local: []string{"photos/same-name-conflict"}},
+			{Result: "moved", Local: []string{"photos/equal"}, Remote: []string{"backup/equal"}},
+			{Result: "remote-only", Remote: []string{"backup/same-name-conflict"}},
+		}
+
+		var actual testAction
+		if err := Diff(newLocal(), newRemote(), &actual); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		actual.Sort()
+
+		if diff := cmp.Diff(expected, actual.Result); diff != "" {
+			t.Errorf("Diff:\n%s", diff)
+		}
+	}
+
+	{ // stripping prefixes aligns the paths
+		expected := []*result{
+			{Result: "conflict", Local: []string{"photos/same-name-conflict"}, Remote: []string{"backup/same-name-conflict"}},
+			{Result: "unchanged", Local: []string{"photos/equal"}, Remote: []string{"backup/equal"}},
+		}
+
+		var actual testAction
+		opts := &DiffOptions{
+			LocalPrefix:  "photos",
+			RemotePrefix: "backup/",
+		}
+		if err := DiffWithOptions(newLocal(), newRemote(), &actual, opts); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		actual.Sort()
+
+		if diff := cmp.Diff(expected, actual.Result); diff != "" {
+			t.Errorf("Diff:\n%s", diff)
+		}
+	}
+
+	{ // files colliding once the prefix is stripped are reported
+		local := newLocal().(*db)
+		local.files = append(local.files, &FileInfo{
+			History: []*FileEvent{
+				&FileEvent{Path: "equal", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "other-hash"},
+			},
+		})
+
+		var actual testAction
+		err := DiffWithOptions(local, newRemote(), &actual, &DiffOptions{LocalPrefix: "photos"})
+		if err == nil || !strings.Contains(err.Error(), "'photos/equal' and 'equal'") {
+			t.Errorf("expected collision error, got: %v", err)
+		}
+	}
+}
+
+type bothDeletedAction struct {
+	testAction
+	deletedTimes [][2]time.Time
+}
+
+func (t *bothDeletedAction) BothDeleted(localFile, remoteFile *FileInfo) {
+	t.testAction.BothDeleted(localFile, remoteFile)
+	t.deletedTimes = append(t.deletedTimes, [2]time.Time{localFile.DeletedTime(), remoteFile.DeletedTime()})
+}
+
+func TestDiffMalformed(t *testing.T) {
+	valid := &FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-a"},
+		},
+	}
+	tests := []struct {
+		name          string
+		local, remote []*FileInfo
+		expected      string
+	}{
+		{"empty local history", []*FileInfo{valid, {}}, []*FileInfo{valid}, "local file 1 has no history"},
+		{"empty remote history", []*FileInfo{valid}, []*FileInfo{{History: []*FileEvent{}}}, "remote file 0 has no history"},
+		{"missing file", []*FileInfo{nil}, []*FileInfo{valid}, "local file 0 is missing"},
+		{"missing event", []*FileInfo{valid}, []*FileInfo{{History: []*FileEvent{valid.History[0], nil}}}, "remote file 0 has missing event 1"},
+	}
+	for _, test := range tests {
+		var actual testAction
+		err := Diff(&db{files: test.local}, &db{files: test.remote}, &actual)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.expected, err)
+		}
+		if len(actual.Result) != 0 {
+			t.Errorf("%s: expected no actions, got %v", test.name, actual.Result)
+		}
+	}
+}
+
+func TestDiffBothDeleted(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "both-deleted", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "both-deleted-hash"},
+					&FileEvent{Path: "both-deleted", Time: parseTime("2020-01-02T12:34:56Z")},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "both-deleted-r", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "both-deleted-hash"},
+					&FileEvent{Path: "both-deleted-r", Time: parseTime("2020-06-01T12:34:56Z")},
+				},
+			},
+		},
+	}
+
+	var actual bothDeletedAction
+	if err := Diff(local, remote, &actual); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	expected := []*result{
+		{Result: "both-deleted", Local: []string{"both-deleted"}, Remote: []string{"both-deleted-r"}},
+	}
+	if diff := cmp.Diff(expected, actual.Result); diff != "" {
+		t.Errorf("Diff:\n%s", diff)
+	}
+
+	expectedTimes := [][2]time.Time{
+		{parseTime("2020-01-02T12:34:56Z"), parseTime("2020-06-01T12:34:56Z")},
+	}
+	if diff := cmp.Diff(expectedTimes, actual.deletedTimes); diff != "" {
+		t.Errorf("DeletedTime:\n%s", diff)
+	}
+}
+
+func TestDiffReAdded(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "re-added", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "re-added-hash"},
+					&FileEvent{Path: "re-added", Time: parseTime("2020-01-02T12:34:56Z")},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "local-deleted", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "local-deleted-hash"},
+					&FileEvent{Path: "local-deleted", Time: parseTime("2020-01-02T12:34:56Z")},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "re-added-r", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "re-added-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "local-deleted", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "local-deleted-hash"},
+				},
+			},
+		},
+	}
+
+	var actual testAction
+	if err := Diff(local, remote, &actual); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	actual.Sort()
+
+	expected := []*result{
+		{Result: "local-deleted", Local: []string{"local-deleted"}, Remote: []string{"local-deleted"}},
+		{Result: "re-added", Local: []string{"re-added"}, Remote: []string{"re-added-r"}},
+	}
+	if diff := cmp.Diff(expected, actual.Result); diff != "" {
+		t.Errorf("Diff:\n%s", diff)
+	}
+}
+
+func TestDiffSwap(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-b"},
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-a"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "b", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-a"},
+					&FileEvent{Path: "b", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-b"},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-b"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "b", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-a"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "c", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-a"},
+				},
+			},
+		},
+	}
+
+	var actual testAction
+	if err := Diff(local, remote, &actual); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	actual.Sort()
+
+	expected := []*result{
+		{Result: "moved", Local: []string{"a"}, Remote: []string{"b"}},
+		{Result: "moved", Local: []string{"b"}, Remote: []string{"a"}},
+		{Result: "remote-only", Remote: []string{"c"}},
+	}
+	if diff := cmp.Diff(expected, actual.Result); diff != "" {
+		t.Errorf("Diff:\n%s", diff)
+	}
+}
+
+func TestDiffDiverged(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash1"},
+					&FileEvent{Path: "a", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash2"},
+					&FileEvent{Path: "a", Size: 12, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-local"},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "a", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash1"},
+					&FileEvent{Path: "a", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash2"},
+					&FileEvent{Path: "b", Size: 13, Time: parseTime("2020-01-04T12:34:56Z"), Checksum: "hash-remote"},
+				},
+			},
+		},
+	}
+
+	report, err := CollectDiff(local, remote)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(report.Diverged) != 1 || len(report.ConflictHash) != 0 {
+		t.Fatalf("expected single diverged file, got %d diverged and %d conflicts", len(report.Diverged), len(report.ConflictHash))
+	}
+
+	ancestor := CommonAncestor(report.Diverged[0].Local, report.Diverged[0].Remote)
+	if ancestor == nil || ancestor.Checksum != "hash2" {
+		t.Errorf("CommonAncestor: expected 'hash2', got %v", ancestor)
+	}
+	if ancestor := CommonAncestor(local.files[0], &FileInfo{History: []*FileEvent{{Path: "c", Checksum: "other"}}}); ancestor != nil {
+		t.Errorf("CommonAncestor: expected nil, got %v", ancestor)
+	}
+}
+
+func TestDiffHashKinds(t *testing.T) {
+	partial := PartialHash(HashSHA256, 1024)
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "same-path", Size: 10000, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "same-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "moved", Size: 10000, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "moved-hash", Algorithm: partial},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "same-path", Size: 10000, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "same-hash", Algorithm: partial},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "moved-r", Size: 10000, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "moved-hash", Algorithm: partial},
+				},
+			},
+		},
+	}
+
+	var actual testAction
+	if err := Diff(local, remote, &actual); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	expected := []*result{
+		{Result: "conflict", Local: []string{"same-path"}, Remote: []string{"same-path"}},
+		{Result: "moved", Local: []string{"moved"}, Remote: []string{"moved-r"}},
+	}
+	sortResults := cmpopts.SortSlices(func(a, b *result) bool { return a.Result < b.Result })
+	if diff := cmp.Diff(expected, actual.Result, sortResults); diff != "" {
+		t.Errorf("Diff:\n%s", diff)
+	}
+}
+
+func TestCollectDiff(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "equal", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "equal-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "moved", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "moved-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "local-only", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "local-only-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "remote-changed", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "remote-changed-hash-1"},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "equal", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "equal-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "moved-r", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "moved-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "remote-only", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "remote-only-hash"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "remote-changed", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "remote-changed-hash-1"},
+					&FileEvent{Path: "remote-changed-r", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "remote-changed-hash-2"},
+				},
+			},
+		},
+	}
+
+	report, err := CollectDiff(local, remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := func(pairs []*DiffPair) [][2]string {
+		retval := [][2]string{}
+		for _, pair := range pairs {
+			retval = append(retval, [2]string{pair.Local.Path(), pair.Remote.Path()})
+		}
+		return retval
+	}
+
+	if diff := cmp.Diff([][2]string{{"equal", "equal"}}, paths(report.Unchanged)); diff != "" {
+		t.Errorf("Unchanged:\n%s", diff)
+	}
+	if diff := cmp.Diff([][2]string{{"moved", "moved-r"}}, paths(report.Moved)); diff != "" {
+		t.Errorf("Moved:\n%s", diff)
+	}
+	if diff := cmp.Diff([][2]string{{"remote-changed", "remote-changed-r"}}, paths(report.RemoteChanged)); diff != "" {
+		t.Errorf("RemoteChanged:\n%s", diff)
+	}
+	if len(report.RemoteChanged) == 1 && !report.RemoteChanged[0].Moved {
+		t.Errorf("RemoteChanged: expected moved")
+	}
+	if len(report.LocalOnly) != 1 || report.LocalOnly[0].Path() != "local-only" {
+		t.Errorf("LocalOnly: unexpected %v", report.LocalOnly)
+	}
+	if len(report.RemoteOnly) != 1 || report.RemoteOnly[0].Path() != "remote-only" {
+		t.Errorf("RemoteOnly: unexpected %v", report.RemoteOnly)
+	}
+	if len(report.ConflictHash) != 0 || len(report.ConflictPath) != 0 {
+		t.Errorf("unexpected conflicts")
+	}
+}
+
+func TestDiffCaseInsensitive(t *testing.T) {
+	file := func(path, checksum string) *FileInfo {
+		return &FileInfo{
+			History: []*FileEvent{
+				&FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: checksum},
+			},
+		}
+	}
+	local := &db{
+		files: []*FileInfo{
+			file("Same.txt", "same-hash"),
+			file("Ärger.txt", "umlaut-hash"),
+			file("Photo.JPG", "photo-hash-1"),
+			file("dup.txt", "dup-hash-1"),
+			file("DUP.txt", "dup-hash-2"),
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			file("same.txt", "same-hash"),
+			file("äRGER.txt", "umlaut-hash"),
+			file("photo.jpg", "photo-hash-2"),
+			file("dup.TXT", "dup-hash-3"),
+		},
+	}
+
+	report, err := CollectDiffWithOptions(local, remote, &DiffOptions{CaseInsensitive: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pairs := func(pairs []*DiffPair) [][2]string {
+		retval := [][2]string{}
+		for _, pair := range pairs {
+			retval = append(retval, [2]string{pair.Local.Path(), pair.Remote.Path()})
+		}
+		return retval
+	}
+	paths := func(files []*FileInfo) []string {
+		retval := []string{}
+		for _, file := range files {
+			retval = append(retval, file.Path())
+		}
+		return retval
+	}
+
+	if diff := cmp.Diff([][2]string{{"Same.txt", "same.txt"}, {"Ärger.txt", "äRGER.txt"}}, pairs(report.Unchanged)); diff != "" {
+		t.Errorf("Unchanged:\n%s", diff)
+	}
+	if diff := cmp.Diff([][2]string{{"Photo.JPG", "photo.jpg"}}, pairs(report.ConflictPath)); diff != "" {
+		t.Errorf("ConflictPath:\n%s", diff)
+	}
+	if len(report.ConflictHash) != 0 {
+		t.Errorf("ConflictHash: expected no conflicts, got %d", len(report.ConflictHash))
+	}
+	if len(report.ConflictCase) != 1 {
+		t.Fatalf("ConflictCase: expected 1 conflict, got %d", len(report.ConflictCase))
+	}
+	if diff := cmp.Diff([]string{"dup.txt", "DUP.txt"}, paths(report.ConflictCase[0].Local)); diff != "" {
+		t.Errorf("ConflictCase local:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"dup.TXT"}, paths(report.ConflictCase[0].Remote)); diff != "" {
+		t.Errorf("ConflictCase remote:\n%s", diff)
+	}
+	if len(report.LocalOnly) != 0 || len(report.RemoteOnly) != 0 {
+		t.Errorf("unexpected local-only %v or remote-only %v files", paths(report.LocalOnly), paths(report.RemoteOnly))
+	}
+}
+
+func TestDiffDeterministicOrder(t *testing.T) {
+	file := func(path, checksum string) *FileInfo {
+		return &FileInfo{
+			History: []*FileEvent{
+				&FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: checksum},
+			},
+		}
+	}
+	newRepos := func() (local, remote *db) {
+		local, remote = &db{}, &db{}
+		for i := 0; i < 20; i++ {
+			local.files = append(local.files, file(fmt.Sprintf("moved-%d", i), fmt.Sprintf("moved-hash-%d", i)))
+			remote.files = append(remote.files, file(fmt.Sprintf("moved-r-%d", i), fmt.Sprintf("moved-hash-%d", i)))
+			local.files = append(local.files, file(fmt.Sprintf("conflict-%d-1", i), fmt.Sprintf("conflict-hash-%d", i)))
+			local.files = append(local.files, file(fmt.Sprintf("conflict-%d-2", i), fmt.Sprintf("conflict-hash-%d", i)))
+			remote.files = append(remote.files, file(fmt.Sprintf("conflict-r-%d", i), fmt.Sprintf("conflict-hash-%d", i)))
+			local.files = append(local.files, file(fmt.Sprintf("path-%d", i), fmt.Sprintf("local-hash-%d", i)))
+			remote.files = append(remote.files, file(fmt.Sprintf("path-%d", i), fmt.Sprintf("remote-hash-%d", i)))
+			local.files = append(local.files, file(fmt.Sprintf("local-only-%d", i), fmt.Sprintf("local-only-hash-%d", i)))
+			remote.files = append(remote.files, file(fmt.Sprintf("remote-only-%d", i), fmt.Sprintf("remote-only-hash-%d", i)))
+		}
+		return local, remote
+	}
+
+	var first []*result
+	for run := 0; run < 10; run++ {
+		local, remote := newRepos()
+		action := &testAction{}
+		if err := Diff(local, remote, action); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if run == 0 {
+			first = action.Result
+			continue
+		}
+		if diff := cmp.Diff(first, action.Result); diff != "" {
+			t.Fatalf("Diff: run %d reported actions in different order:\n%s", run, diff)
+		}
+	}
+}
+
+func TestFindHistoricDuplicates(t *testing.T) {
+	event := func(path, checksum, time string) *FileEvent {
+		return &FileEvent{Path: path, Size: 10, Time: parseTime(time), Checksum: checksum}
+	}
+	files := []*FileInfo{
+		// content re-added after it was changed in another file
+		{History: []*FileEvent{event("changed", "hash-1", "2020-01-01T12:34:56Z"), event("changed", "hash-2", "2020-01-02T12:34:56Z")}},
+		{History: []*FileEvent{event("readded", "hash-1", "2020-01-03T12:34:56Z")}},
+		// content re-added after the file was deleted
+		{History: []*FileEvent{event("deleted", "hash-3", "2020-01-01T12:34:56Z"), {Path: "deleted", Time: parseTime("2020-01-02T12:34:56Z")}}},
+		{History: []*FileEvent{event("copy", "hash-3", "2020-01-03T12:34:56Z")}},
+		// plain duplicates are not historic duplicates
+		{History: []*FileEvent{event("dup-1", "hash-4", "2020-01-01T12:34:56Z")}},
+		{History: []*FileEvent{event("dup-2", "hash-4", "2020-01-01T12:34:56Z")}},
+		// own history does not count
+		{History: []*FileEvent{event("reverted", "hash-5", "2020-01-01T12:34:56Z"), event("reverted", "hash-6", "2020-01-02T12:34:56Z"), event("reverted", "hash-5", "2020-01-03T12:34:56Z")}},
+	}
+
+	expected := map[string][]string{
+		"copy":    {"deleted"},
+		"readded": {"changed"},
+	}
+	actual := map[string][]string{}
+	var order []string
+	for _, duplicate := range FindHistoricDuplicates(files) {
+		order = append(order, duplicate.File.Path())
+		for _, match := range duplicate.Matches {
+			actual[duplicate.File.Path()] = append(actual[duplicate.File.Path()], match.Path())
+		}
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("FindHistoricDuplicates:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"copy", "readded"}, order); diff != "" {
+		t.Errorf("FindHistoricDuplicates: unexpected order:\n%s", diff)
+	}
+}
+
+func TestDiffOverlappingHistoricalHashes(t *testing.T) {
+	file := func(path string, checksums ...string) *FileInfo {
+		file := &FileInfo{}
+		for i, checksum := range checksums {
+			file.History = append(file.History, &FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z").Add(time.Duration(i) * time.Hour), Checksum: checksum})
+		}
+		return file
+	}
+
+	// remote files link both local files through different past versions
+	expected := []*result{
+		{Result: "conflict", Local: []string{"local-a", "local-b"}, Remote: []string{"remote-x", "remote-y"}},
+	}
+	for run := 0; run < 10; run++ {
+		local := &db{files: []*FileInfo{
+			file("local-a", "hash-1", "hash-a"),
+			file("local-b", "hash-2", "hash-b"),
+		}}
+		remote := &db{files: []*FileInfo{
+			file("remote-x", "hash-1", "hash-2", "hash-x"),
+			file("remote-y", "hash-2", "hash-y"),
+		}}
+		if run%2 == 1 {
+			local.files[0], local.files[1] = local.files[1], local.files[0]
+			remote.files[0], remote.files[1] = remote.files[1], remote.files[0]
+		}
+
+		action := &testAction{}
+		if err := Diff(local, remote, action); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(expected, action.Result); diff != "" {
+			t.Fatalf("Diff: run %d:\n%s", run, diff)
+		}
+	}
+}
+
+func TestDiffNewerOnly(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "newer-remote", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "newer-1"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "older-remote", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "older-1"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "conflict-newer", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "conflict-newer-l"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "conflict-older", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "conflict-older-l"},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "newer-remote", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "newer-1"},
+					&FileEvent{Path: "newer-remote", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "newer-2"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "older-remote", Size: 10, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "older-1"},
+					&FileEvent{Path: "older-remote", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "older-2"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "conflict-newer", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "conflict-newer-r"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "conflict-older", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "conflict-older-r"},
+				},
+			},
+		},
+	}
+
+	var actual testAction
+	if err := Diff(local, remote, NewerOnly(&actual)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	actual.Sort()
+
+	expected := []*result{
+		{Result: "conflict", Local: []string{"conflict-newer"}, Remote: []string{"conflict-newer"}},
+		{Result: "remote-changed", Local: []string{"newer-remote"}, Remote: []string{"newer-remote"}},
+	}
+	if diff := cmp.Diff(expected, actual.Result); diff != "" {
+		t.Errorf("Diff:\n%s", diff)
+	}
+}
+
+func TestDiffAgainst(t *testing.T) {
+	file := func(path string, checksums ...string) *FileInfo {
+		file := &FileInfo{}
+		for i, checksum := range checksums {
+			file.History = append(file.History, &FileEvent{Path: path, Size: 10, Time: parseTime("2020-01-01T12:34:56Z").Add(time.Duration(i) * time.Hour), Checksum: checksum})
+		}
+		return file
+	}
+	newRepos := func() (Boffin, Boffin) {
+		local := &db{
+			files: []*FileInfo{
+				file("unchanged", "unchanged-hash"),
+				file("moved", "moved-hash"),
+				file("changed", "changed-hash-1", "changed-hash-2"),
+				file("local-only", "local-only-hash"),
+			},
+		}
+		remote := &db{
+			files: []*FileInfo{
+				file("sub/unchanged", "unchanged-hash"),
+				file("sub/moved-r", "moved-hash"),
+				file("sub/changed", "changed-hash-1"),
+				file("sub/remote-only", "remote-only-hash"),
+			},
+		}
+		return local, remote
+	}
+
+	for _, opts := range []*DiffOptions{nil, {RemotePrefix: "sub"}} {
+		local, remote := newRepos()
+		var expected testAction
+		if err := DiffWithOptions(local, remote, &expected, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		local, remote = newRepos()
+		var actual testAction
+		var err error
+		if opts == nil {
+			err = local.DiffAgainst(remote, &actual)
+		} else {
+			err = local.DiffAgainstWithOptions(remote, &actual, opts)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(expected.Result) == 0 {
+			t.Fatalf("Diff: no results")
+		}
+		if diff := cmp.Diff(expected.Result, actual.Result); diff != "" {
+			t.Errorf("DiffAgainst: %+v:\n%s", opts, diff)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// KeepPolicy decides which one of duplicate files is kept.
+type KeepPolicy string
+
+const (
+	// KeepOldest keeps the file whose content was recorded first.
+	KeepOldest KeepPolicy = "oldest"
+	// KeepNewest keeps the file whose content was recorded last.
+	KeepNewest KeepPolicy = "newest"
+	// KeepShortestPath keeps the file with the shortest path.
+	KeepShortestPath KeepPolicy = "shortest-path"
+	// KeepFirstAlphabetical keeps the file whose path sorts first.
+	KeepFirstAlphabetical KeepPolicy = "first-alphabetical"
+)
+
+// ParseKeepPolicy returns the policy with the given name.
+func ParseKeepPolicy(name string) (KeepPolicy, error) {
+	switch policy := KeepPolicy(name); policy {
+	case KeepOldest, KeepNewest, KeepShortestPath, KeepFirstAlphabetical:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown keep policy '%s'; use one of %s, %s, %s or %s",
+		name, KeepOldest, KeepNewest, KeepShortestPath, KeepFirstAlphabetical)
+}
+
+// SelectDuplicates splits duplicate files into the one to keep according to
+// the policy and the rest. Ties are broken by path, so the choice does not
+// depend on the order of files.
+func SelectDuplicates(files []*FileInfo, policy KeepPolicy) (keep *FileInfo, others []*FileInfo) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]*FileInfo, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch policy {
+		case KeepOldest:
+			if !a.Time().Equal(b.Time()) {
+				return a.Time().Before(b.Time())
+			}
+		case KeepNewest:
+			if !a.Time().Equal(b.Time()) {
+				return a.Time().After(b.Time())
+			}
+		case KeepShortestPath:
+			if len(a.Path()) != len(b.Path()) {
+				return len(a.Path()) < len(b.Path())
+			}
+		}
+		return a.Path() < b.Path()
+	})
+
+	return sorted[0], sorted[1:]
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestSelectDuplicates(t *testing.T) {
+	file := func(path, time string) *FileInfo {
+		return &FileInfo{
+			History: []*FileEvent{
+				&FileEvent{Path: path, Size: 10, Time: parseTime(time), Checksum: "hash"},
+			},
+		}
+	}
+	files := []*FileInfo{
+		file("b/newest.ext", "2020-01-03T12:34:56Z"),
+		file("c/oldest/file.ext", "2020-01-01T12:34:56Z"),
+		file("a/middle.ext", "2020-01-02T12:34:56Z"),
+		file("short.ext", "2020-01-02T12:34:56Z"),
+	}
+
+	tests := []struct {
+		policy string
+		keep   string
+	}{
+		{"oldest", "c/oldest/file.ext"},
+		{"newest", "b/newest.ext"},
+		{"shortest-path", "short.ext"},
+		{"first-alphabetical", "a/middle.ext"},
+	}
+	for _, test := range tests {
+		policy, err := ParseKeepPolicy(test.policy)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keep, others := SelectDuplicates(files, policy)
+		if keep.Path() != test.keep {
+			t.Errorf("%s: expected to keep '%s', got '%s'", test.policy, test.keep, keep.Path())
+		}
+		if len(others) != len(files)-1 {
+			t.Errorf("%s: expected %d others, got %d", test.policy, len(files)-1, len(others))
+		}
+		for _, other := range others {
+			if other == keep {
+				t.Errorf("%s: kept file is also in others", test.policy)
+			}
+		}
+	}
+
+	// ties are broken by path regardless of order
+	a := file("a.ext", "2020-01-01T12:34:56Z")
+	b := file("b.ext", "2020-01-01T12:34:56Z")
+	for _, files := range [][]*FileInfo{{a, b}, {b, a}} {
+		keep, _ := SelectDuplicates(files, KeepOldest)
+		if keep != a {
+			t.Errorf("SelectDuplicates: expected tie to be broken by path, kept '%s'", keep.Path())
+		}
+	}
+
+	if _, err := ParseKeepPolicy("random"); err == nil {
+		t.Errorf("ParseKeepPolicy: expected error for unknown policy")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Export writes all repository data to w, in the same format as the repo
+// file. Files are sorted by path and times are in UTC, so exporting the same
+// repository always gives the same output.
+func (db *db) Export(w io.Writer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	files := make([]*FileInfo, len(db.files))
+	copy(files, db.files)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Path() < files[j].Path()
+	})
+
+	timezone := ""
+	if db.timezone != nil {
+		timezone = db.timezone.String()
+	}
+
+	rawJSON := &jsonStruct{
+		V2: &v2Struct{
+			BaseDir:    db.baseDir,
+			ImportDir:  db.importDir,
+			Roots:      db.roots,
+			Ignore:     db.ignore.getPatternSlice(),
+			AppendOnly: db.appendOnly,
+			Timezone:   timezone,
+			Backups:    db.backups,
+			Origin:     db.origin,
+			Compress:   db.compress,
+			Files:      files,
+		},
+	}
+	integrity, err := rawJSON.integrityChecksum()
+	if err != nil {
+		return err
+	}
+	rawJSON.Integrity = integrity
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rawJSON)
+}
+
+// ImportDb creates a repository in dbDir for files in baseDir, from the data
+// written by Export. An existing repository in dbDir is replaced only if force
+// is set.
+func ImportDb(r io.Reader, dbDir, baseDir string, force bool) (Boffin, error) {
+	rawJSON, err := decodeRepoJSON(r, "export")
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir, err = cleanPath(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' does not exist", baseDir)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", baseDir)
+	}
+
+	dbDir, err = cleanPath(dbDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(dbDir); err == nil {
+		if !force {
+			return nil, fmt.Errorf("'%s' already exists", dbDir)
+		}
+		for _, filename := range []string{filesFilename, journalFilename} {
+			filename = filepath.Join(dbDir, filename)
+			if err = os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	} else if err = os.Mkdir(dbDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := newDbFromJSON(dbDir, rawJSON)
+	if err != nil {
+		return nil, err
+	}
+	db.baseDir = relBaseDir(dbDir, baseDir)
+	if err = db.resolveDirs(); err != nil {
+		return nil, err
+	}
+
+	if err = db.Save(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher reads files of a repository, which is not necessarily on this
+// machine.
+type Fetcher interface {
+	// Open returns contents of the file at the path relative to the base dir.
+	Open(relPath string) (io.ReadCloser, error)
+}
+
+type localFetcher struct {
+	baseDir string
+}
+
+// NewLocalFetcher returns Fetcher reading files from the local base dir. The
+// returned reader is *os.File.
+func NewLocalFetcher(baseDir string) Fetcher {
+	return &localFetcher{baseDir: baseDir}
+}
+
+func (f *localFetcher) Open(relPath string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.baseDir, relPath))
+}
+
+type repoFetcher struct {
+	repo Boffin
+}
+
+// NewRepoFetcher returns Fetcher reading files of a local repository, from its
+// base dir or any of its roots. The returned reader is *os.File.
+func NewRepoFetcher(repo Boffin) Fetcher {
+	return &repoFetcher{repo: repo}
+}
+
+func (f *repoFetcher) Open(relPath string) (io.ReadCloser, error) {
+	return os.Open(f.repo.ResolvePath(relPath))
+}
+
+type sshFetcher struct {
+	host    string
+	port    string
+	baseDir string
+}
+
+// NewSSHFetcher returns Fetcher reading files from base dir on the host, using
+// the ssh command. Host can include the user name, i.e. user@host. Empty port
+// means the default one.
+func NewSSHFetcher(host, port, baseDir string) Fetcher {
+	return &sshFetcher{host: host, port: port, baseDir: baseDir}
+}
+
+func (f *sshFetcher) Open(relPath string) (io.ReadCloser, error) {
+	return sshCat(f.host, f.port, path.Join(f.baseDir, filepath.ToSlash(relPath)))
+}
+
+// sshReader streams output of a remote command. Errors of the command are
+// reported when the reader is closed.
+type sshReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	path   string
+}
+
+func (r *sshReader) Close() error {
+	_ = r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == sshMissingExitCode {
+			return &os.PathError{Op: "open", Path: r.path, Err: os.ErrNotExist}
+		}
+		return fmt.Errorf("%s: %v: %s", strings.Join(r.cmd.Args, " "), err, strings.TrimSpace(r.stderr.String()))
+	}
+	return nil
+}
+
+// sshMissingExitCode is returned by the remote command if the file does not
+// exist, to tell it apart from other failures of cat or ssh.
+const sshMissingExitCode = 66
+
+// sshCat streams the remote file. Missing file is reported on close as an
+// error satisfying os.IsNotExist.
+func sshCat(host, port, remotePath string) (io.ReadCloser, error) {
+	// anything starting with a dash would be taken by ssh as an option
+	if host == "" || strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid ssh host '%s'", host)
+	}
+	args := []string{}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	quoted := shellQuote(remotePath)
+	args = append(args, "--", host, fmt.Sprintf("test -e %s || exit %d; exec cat -- %s", quoted, sshMissingExitCode, quoted))
+	cmd := exec.Command("ssh", args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sshReader{ReadCloser: stdout, cmd: cmd, stderr: stderr, path: remotePath}, nil
+}
+
+// shellQuote quotes s so that the remote shell passes it as a single argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// IsSSHURL returns true if the repository is given as ssh://[user@]host/path.
+func IsSSHURL(repo string) bool {
+	return strings.HasPrefix(repo, "ssh://")
+}
+
+// LoadSSHBoffin copies repo files of the repository with the db dir named
+// dbName in the base dir given by the ssh:// URL into tmpDir and loads it.
+// Files of the repository must be read with the returned Fetcher, as base dir
+// of the loaded repository is not on this machine.
+func LoadSSHBoffin(rawURL, dbName, tmpDir string) (Boffin, Fetcher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "ssh" || u.Host == "" || u.Path == "" {
+		return nil, nil, fmt.Errorf("'%s' is not a valid ssh://[user@]host/path URL", rawURL)
+	}
+	host := u.Hostname()
+	if strings.HasPrefix(host, "-") {
+		return nil, nil, fmt.Errorf("'%s' has invalid host '%s'", rawURL, host)
+	}
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	port := u.Port()
+	remoteDbDir := path.Join(u.Path, dbName)
+
+	dbDir := filepath.Join(tmpDir, dbName)
+	if err = os.MkdirAll(dbDir, os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+	err = copySSHFile(host, port, path.Join(remoteDbDir, filesFilename), filepath.Join(dbDir, filesFilename))
+	if err != nil {
+		return nil, nil, fmt.Errorf("'%s' is not a boffin repository: %v", rawURL, err)
+	}
+	// the journal exists only if the repository was saved since it was last
+	// compacted; any other failure would load the repository without the
+	// changes recorded in it
+	err = copySSHFile(host, port, path.Join(remoteDbDir, journalFilename), filepath.Join(dbDir, journalFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("error copying journal of '%s': %v", rawURL, err)
+	}
+
+	repo, err := LoadBoffin(dbDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseDir := repo.GetRelBaseDir()
+	if !path.IsAbs(baseDir) {
+		baseDir = path.Join(remoteDbDir, filepath.ToSlash(baseDir))
+	}
+	return repo, NewSSHFetcher(host, port, baseDir), nil
+}
+
+// copySSHFile copies the file from the host to the local path. Local file is
+// removed if the copy fails.
+func copySSHFile(host, port, remotePath, localPath string) error {
+	in, err := sshCat(host, port, remotePath)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(localPath)
+	if err != nil {
+		_ = in.Close()
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(localPath)
+	}
+	return err
+}
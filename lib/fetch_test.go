@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSSHBoffin(t *testing.T) {
+	// fake ssh logs its options and runs the command locally, ignoring the
+	// host; commands mentioning $SSH_FAIL fail as if the connection dropped
+	bin := t.TempDir()
+	argsLog := filepath.Join(bin, "args")
+	script := `#!/bin/sh
+while [ "$1" != "--" ]; do echo "$1" >> "$SSH_ARGS"; shift; done
+shift 2
+case "$1" in *"$SSH_FAIL"*) [ -n "$SSH_FAIL" ] && exit 255;; esac
+exec sh -c "$1"
+`
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_ARGS", argsLog)
+	t.Setenv("SSH_FAIL", "")
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "it's a file.ext"), "contents")
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	remote, fetcher, err := LoadSSHBoffin("ssh://user@host"+dir, DefaultDbDirName, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remote.GetFileByPath("it's a file.ext") == nil {
+		t.Errorf("LoadSSHBoffin: file missing from the remote repo")
+	}
+
+	reader, err := fetcher.Open("it's a file.ext")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = reader.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if string(contents) != "contents" {
+		t.Errorf("Open: expected 'contents', got '%s'", contents)
+	}
+
+	// errors of the remote command are reported on close
+	reader, err = fetcher.Open("missing.ext")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = io.ReadAll(reader)
+	if err = reader.Close(); err == nil {
+		t.Errorf("Close: expected error for missing file")
+	}
+
+	if _, _, err = LoadSSHBoffin("ssh://host"+t.TempDir(), DefaultDbDirName, t.TempDir()); err == nil {
+		t.Errorf("LoadSSHBoffin: expected error for missing repo")
+	}
+
+	// changes saved to the journal must be loaded too, and the port passed
+	// as an option rather than as part of the host
+	writeTestFile(t, filepath.Join(dir, "new.ext"), "new")
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Remove(argsLog); err != nil && !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote, _, err = LoadSSHBoffin("ssh://host:2222"+dir, DefaultDbDirName, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remote.GetFileByPath("new.ext") == nil {
+		t.Errorf("LoadSSHBoffin: file saved to the journal missing from the remote repo")
+	}
+	args, err := os.ReadFile(argsLog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(args) != "-p\n2222\n-p\n2222\n" {
+		t.Errorf("ssh: expected port option, got %q", args)
+	}
+
+	// journal that can not be copied must not be skipped
+	t.Setenv("SSH_FAIL", journalFilename)
+	if _, _, err = LoadSSHBoffin("ssh://host"+dir, DefaultDbDirName, t.TempDir()); err == nil {
+		t.Errorf("LoadSSHBoffin: expected error when the journal can not be copied")
+	}
+	t.Setenv("SSH_FAIL", "")
+
+	// host must not be taken as an ssh option
+	marker := filepath.Join(t.TempDir(), "marker")
+	for _, rawURL := range []string{
+		"ssh://-oProxyCommand=touch%20" + marker + dir,
+		"ssh://-oProxyCommand=x@host" + dir,
+	} {
+		if _, _, err = LoadSSHBoffin(rawURL, DefaultDbDirName, t.TempDir()); err == nil {
+			t.Errorf("LoadSSHBoffin: expected error for '%s'", rawURL)
+		}
+	}
+	if _, err = os.Stat(marker); err == nil {
+		t.Errorf("LoadSSHBoffin: command in the host was run")
+	}
+	if _, err = NewSSHFetcher("-oProxyCommand=x", "", dir).Open("new.ext"); err == nil {
+		t.Errorf("Open: expected error for host starting with '-'")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFilename is the name of the file in the base directory listing
+// gitignore-style patterns of files that should not be tracked.
+const ignoreFilename = ".boffinignore"
+
+// trackFilename is the name of the file in the base directory listing
+// gitignore-style patterns of files that are tracked by their metadata only;
+// see HashMetadataOnly.
+const trackFilename = ".boffintrack"
+
+// loadIgnoreFile reads ignore patterns from the given file. Missing file is
+// not an error, it simply means that nothing is ignored.
+func loadIgnoreFile(path string) (ignore, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	patterns := ignore{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if pattern, ok := parseIgnorePattern(scanner.Text()); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// parseIgnorePattern parses a line of an ignore file. Returns false for
+// comments, blank lines and invalid patterns.
+func parseIgnorePattern(pattern string) (ignorePattern, bool) {
+	rule := ignorePattern{}
+
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule, false
+	}
+	rule.pattern = pattern
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, false
+	}
+
+	// patterns with a slash are relative to the base dir, others match the
+	// name at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = "^"
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(pattern) + "$")
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp converts glob pattern to regular expression, where * and ?
+// do not match path separator, and ** matches any number of directories.
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(glob[i+1:], ']'); end >= 0 {
+				class := glob[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				re.WriteString("[" + class + "]")
+				i += end + 1
+			} else {
+				re.WriteString(regexp.QuoteMeta("["))
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// compileGlob compiles glob pattern matching whole paths relative to the base
+// dir, e.g. "photos/2021/**".
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	return regexp.Compile("^" + globToRegexp(filepath.ToSlash(glob)) + "$")
+}
+
+// match returns true if the path, relative to the base dir, should be
+// ignored.
+func (rules ignore) match(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	ignored := false
+	for _, rule := range rules {
+		// invalid patterns from the repo file are kept, but match nothing
+		if rule.re == nil || rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(relPath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchFile returns true if the file, or any directory it is in, matches the
+// rules. Unlike ignored directories, which are not scanned at all, directory
+// patterns must be applied to each file found in them.
+func (rules ignore) matchFile(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for i := 0; i < len(relPath); i++ {
+		if relPath[i] == '/' && rules.match(relPath[:i], true) {
+			return true
+		}
+	}
+	return rules.match(relPath, false)
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := ignore{}
+	for _, pattern := range []string{
+		"# comment",
+		"",
+		"*.tmp",
+		"!keep.tmp",
+		"cache/",
+		"/top.ext",
+		"docs/*.txt",
+		"**/build/*.o",
+	} {
+		if rule, ok := parseIgnorePattern(pattern); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"file.tmp", false, true},
+		{"sub/deep/file.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"sub/keep.tmp", false, false},
+		{"file.ext", false, false},
+		{"cache", true, true},
+		{"sub/cache", true, true},
+		{"cache", false, false},
+		{"top.ext", false, true},
+		{"sub/top.ext", false, false},
+		{"docs/readme.txt", false, true},
+		{"docs/sub/readme.txt", false, false},
+		{"build/main.o", false, true},
+		{"sub/build/main.o", false, true},
+	}
+	for _, test := range tests {
+		if actual := rules.match(test.path, test.isDir); actual != test.expected {
+			t.Errorf("%s (dir=%t): %t != %t", test.path, test.isDir, test.expected, actual)
+		}
+	}
+}
+
+func TestUpdateIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, ignoreFilename), strings.Join([]string{
+		"*.tmp",
+		"!keep.tmp",
+		"cache/",
+	}, "\n"))
+	writeTestFile(t, filepath.Join(dir, "photo.jpg"), "photo")
+	writeTestFile(t, filepath.Join(dir, "temp.tmp"), "temp")
+	writeTestFile(t, filepath.Join(dir, "keep.tmp"), "keep")
+	writeTestFile(t, filepath.Join(dir, "album", "photo.jpg"), "album photo")
+	writeTestFile(t, filepath.Join(dir, "album", "temp.tmp"), "album temp")
+	writeTestFile(t, filepath.Join(dir, "album", "cache", "thumb.jpg"), "thumb")
+	writeTestFile(t, filepath.Join(dir, "cache", "thumb.jpg"), "thumb")
+	writeTestFile(t, filepath.Join(dir, "cache", "keep.tmp"), "pruned with the directory")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		ignoreFilename,
+		"album/photo.jpg",
+		"keep.tmp",
+		"photo.jpg",
+	}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	sort.Strings(actual)
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
+
+func TestUpdateRepoIgnorePatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, ignoreFilename), "*.bak\n")
+	writeTestFile(t, filepath.Join(dir, "photo.jpg"), "photo")
+	writeTestFile(t, filepath.Join(dir, "temp.tmp"), "temp")
+	writeTestFile(t, filepath.Join(dir, "photo.bak"), "backup")
+	writeTestFile(t, filepath.Join(dir, "cache", "thumb.jpg"), "thumb")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// patterns in the repo file are regular expressions; paths matched by
+	// either them or the ignore file are ignored
+	boffin.(*db).ignore = compileIgnorePatterns([]string{`\.tmp$`, `^cache$`})
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		ignoreFilename,
+		"photo.jpg",
+	}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	sort.Strings(actual)
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
@@ -0,0 +1,433 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Rather than rewriting the whole repo file on every save, changes are
+// appended to a journal which is replayed on top of the repo file when
+// loading. Compact folds the journal back into the repo file.
+//
+// Files have no identity of their own, so journal records refer to a file by
+// the key of its history. Keys are chained over events, which allows finding
+// the file by the history it had before new events were appended.
+
+const journalFilename = "events.log"
+
+// journal record operations
+const (
+	// first record of every journal; File is the integrity of the repo file
+	// the journal applies to
+	journalSnapshot = "snapshot"
+	// Events is the full history of a newly added file
+	journalAdd = "add"
+	// Events are appended to the history of the file identified by File
+	journalAppend = "append"
+	// file identified by File is removed from the repository
+	journalRemove = "remove"
+	// file identified by File was last verified at Time
+	journalVerified = "verified"
+)
+
+// errStaleJournal is returned when replaying a journal that belongs to another
+// version of the repo file, e.g. one compacted before the journal was removed.
+var errStaleJournal = errors.New("journal is stale")
+
+type journalRecord struct {
+	Op     string       `json:"op"`
+	File   string       `json:"file,omitempty"`
+	Events []*FileEvent `json:"events,omitempty"`
+	Time   *time.Time   `json:"time,omitempty"`
+	// Integrity is chained over all records up to this one; see
+	// chainIntegrity
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// chainIntegrity returns the integrity of the record, following a record with
+// the given integrity. As each record covers all records before it, changing,
+// removing or reordering any of them is detected.
+func (r *journalRecord) chainIntegrity(prev string) (string, error) {
+	record := *r
+	record.Integrity = ""
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(prev))
+	hash.Write(data)
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// historyKeys returns a key for each prefix of the history; the last key
+// identifies the whole history.
+func historyKeys(history []*FileEvent) []string {
+	keys := make([]string, len(history))
+	var prev []byte
+	for i, event := range history {
+		// key must not depend on the timezone used when saving
+		e := *event
+		e.Time = e.Time.UTC()
+		data, err := json.Marshal(&e)
+		if err != nil {
+			// a plain struct of strings, numbers and time; can't fail
+			panic(err)
+		}
+		hash := sha256.New()
+		hash.Write(prev)
+		hash.Write(data)
+		prev = hash.Sum(nil)
+		keys[i] = base64.StdEncoding.EncodeToString(prev)
+	}
+	return keys
+}
+
+func historyKey(history []*FileEvent) string {
+	if len(history) == 0 {
+		return ""
+	}
+	return historyKeys(history)[len(history)-1]
+}
+
+// persistedKeys returns how many files have each history.
+func persistedKeys(files []*FileInfo) map[string]int {
+	persisted := make(map[string]int, len(files))
+	for _, file := range files {
+		persisted[historyKey(file.History)]++
+	}
+	return persisted
+}
+
+// markPersisted records the current files as saved.
+func (db *db) markPersisted() {
+	db.persisted = persistedKeys(db.files)
+	db.persistedVerified = map[string]time.Time{}
+	for _, file := range db.files {
+		if file.LastVerified != nil {
+			db.persistedVerified[historyKey(file.History)] = *file.LastVerified
+		}
+	}
+}
+
+// journalChanges returns records which turn the persisted files into the
+// current ones.
+func (db *db) journalChanges() []*journalRecord {
+	remaining := make(map[string]int, len(db.persisted))
+	for key, n := range db.persisted {
+		remaining[key] = n
+	}
+
+	// unchanged files first, so that their history is not used as a base of
+	// a changed file that happens to have the same past
+	var changed []*FileInfo
+	var changedKeys [][]string
+	for _, file := range db.files {
+		keys := historyKeys(file.History)
+		if len(keys) > 0 && remaining[keys[len(keys)-1]] > 0 {
+			remaining[keys[len(keys)-1]]--
+			continue
+		}
+		changed = append(changed, file)
+		changedKeys = append(changedKeys, keys)
+	}
+
+	var records []*journalRecord
+	for i, file := range changed {
+		keys := changedKeys[i]
+		base := len(keys) - 2
+		for ; base >= 0 && remaining[keys[base]] == 0; base-- {
+		}
+		if base >= 0 {
+			remaining[keys[base]]--
+			records = append(records, &journalRecord{
+				Op:     journalAppend,
+				File:   keys[base],
+				Events: file.History[base+1:],
+			})
+		} else {
+			records = append(records, &journalRecord{
+				Op:     journalAdd,
+				Events: file.History,
+			})
+		}
+	}
+
+	removed := make([]string, 0, len(remaining))
+	for key, n := range remaining {
+		for ; n > 0; n-- {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		records = append(records, &journalRecord{
+			Op:   journalRemove,
+			File: key,
+		})
+	}
+
+	// verification does not change the history; refer to the file by the
+	// history it has once the records above are applied
+	for _, file := range db.files {
+		if file.LastVerified == nil || len(file.History) == 0 {
+			continue
+		}
+		key := historyKey(file.History)
+		if saved, ok := db.persistedVerified[key]; !ok || !saved.Equal(*file.LastVerified) {
+			records = append(records, &journalRecord{
+				Op:   journalVerified,
+				File: key,
+				Time: file.LastVerified,
+			})
+		}
+	}
+
+	return records
+}
+
+// appendJournal writes changes since the last save to the journal.
+func (db *db) appendJournal() error {
+	if err := db.checkUnchanged(); err != nil {
+		return err
+	}
+	records := db.journalChanges()
+	if len(records) == 0 {
+		return nil
+	}
+	if err := db.rotateBackups(false); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	filename := filepath.Join(db.dbDir, journalFilename)
+	integrity := db.journalIntegrity
+	if db.journalChecksum == "" {
+		records = append([]*journalRecord{{Op: journalSnapshot, File: db.integrity}}, records...)
+		integrity = ""
+	}
+	for _, record := range records {
+		if db.timezone != nil {
+			events := make([]*FileEvent, 0, len(record.Events))
+			for _, event := range record.Events {
+				e := *event
+				e.Time = e.Time.In(db.timezone)
+				events = append(events, &e)
+			}
+			record.Events = events
+		}
+		var err error
+		if record.Integrity, err = record.chainIntegrity(integrity); err != nil {
+			return err
+		}
+		integrity = record.Integrity
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if fi, err := os.Stat(filename); err == nil {
+		if err = os.Chmod(filename, fi.Mode()|0200); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// write all records at once, so that an interrupted save does not leave
+	// half of the changes behind
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(filename, 0444); err != nil {
+		return err
+	}
+
+	checksum, err := CalculateChecksum(filename)
+	if err != nil {
+		return err
+	}
+	db.journalChecksum = checksum
+	db.journalIntegrity = integrity
+	db.markPersisted()
+	return nil
+}
+
+// replayJournal applies the journal in filename, if any, to the loaded files.
+// Unless the repo file must be rewritten on next save, it also records the
+// files as persisted.
+func (db *db) replayJournal(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		if db.integrity != "" {
+			db.markPersisted()
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+	decoder := json.NewDecoder(io.TeeReader(file, hash))
+	decoder.DisallowUnknownFields()
+
+	byKey := map[string][]*FileInfo{}
+	for _, file := range db.files {
+		key := historyKey(file.History)
+		byKey[key] = append(byKey[key], file)
+	}
+	take := func(key string) (*FileInfo, error) {
+		files := byKey[key]
+		if len(files) == 0 {
+			return nil, fmt.Errorf("'%s' refers to a file not in the repository", filename)
+		}
+		byKey[key] = files[1:]
+		return files[0], nil
+	}
+
+	var removed []*FileInfo
+	integrity := ""
+	for i := 0; ; i++ {
+		record := &journalRecord{}
+		if err := decoder.Decode(record); err == io.EOF {
+			break
+		} else if err != nil {
+			return &corruptedError{fmt.Errorf("'%s' is corrupted: %v", filename, err)}
+		}
+		if expected, err := record.chainIntegrity(integrity); err != nil {
+			return err
+		} else if record.Integrity != expected {
+			return fmt.Errorf("'%s' is corrupted or was modified by hand; integrity checksum of record %d does not match", filename, i+1)
+		}
+		integrity = record.Integrity
+
+		if i == 0 {
+			if record.Op != journalSnapshot {
+				return &corruptedError{fmt.Errorf("'%s' is corrupted: missing snapshot record", filename)}
+			}
+			if record.File != db.integrity {
+				checksum, err := CalculateChecksum(filename)
+				if err != nil {
+					return err
+				}
+				db.journalChecksum = checksum
+				return fmt.Errorf("ignoring '%s' as it does not apply to the repo file: %w", filename, errStaleJournal)
+			}
+			continue
+		}
+
+		if db.timezone != nil {
+			// keep times in memory in UTC, same as when loading the repo file
+			for _, event := range record.Events {
+				event.Time = event.Time.UTC()
+			}
+		}
+
+		switch record.Op {
+		case journalAdd:
+			file := &FileInfo{History: record.Events}
+			db.files = append(db.files, file)
+			key := historyKey(file.History)
+			byKey[key] = append(byKey[key], file)
+		case journalAppend:
+			file, err := take(record.File)
+			if err != nil {
+				return err
+			}
+			file.History = append(file.History, record.Events...)
+			key := historyKey(file.History)
+			byKey[key] = append(byKey[key], file)
+		case journalRemove:
+			file, err := take(record.File)
+			if err != nil {
+				return err
+			}
+			removed = append(removed, file)
+		case journalVerified:
+			file, err := take(record.File)
+			if err != nil {
+				return err
+			}
+			if record.Time != nil {
+				verified := record.Time.UTC()
+				file.LastVerified = &verified
+			}
+			byKey[record.File] = append(byKey[record.File], file)
+		default:
+			return &corruptedError{fmt.Errorf("'%s' is corrupted: unknown operation '%s'", filename, record.Op)}
+		}
+	}
+
+	if len(removed) > 0 {
+		gone := make(map[*FileInfo]bool, len(removed))
+		for _, file := range removed {
+			gone[file] = true
+		}
+		files := db.files[:0]
+		for _, file := range db.files {
+			if !gone[file] {
+				files = append(files, file)
+			}
+		}
+		db.files = files
+	}
+
+	sort.Slice(db.files, func(i, j int) bool {
+		return db.files[i].Path() < db.files[j].Path()
+	})
+	db.journalChecksum = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	db.journalIntegrity = integrity
+	db.markPersisted()
+	return nil
+}
+
+// Compact writes all files into the repo file and removes the journal.
+func (db *db) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.dbDir == "" {
+		return fmt.Errorf("repository was not loaded from a db dir and can not be saved")
+	}
+
+	return db.saveSnapshot()
+}
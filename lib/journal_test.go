@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.SetTimezone("Europe/Belgrade"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot, err := os.ReadFile(filepath.Join(dbDir, filesFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	event := func(path, checksum, time string) *FileEvent {
+		return &FileEvent{Path: path, Size: 10, Time: parseTime(time), Checksum: checksum}
+	}
+	boffin.SetFiles([]*FileInfo{
+		&FileInfo{History: []*FileEvent{event("changed.ext", "hash-1", "2020-01-01T12:34:56Z")}},
+		&FileInfo{History: []*FileEvent{event("deleted.ext", "hash-2", "2020-01-01T12:34:56Z")}},
+		&FileInfo{History: []*FileEvent{event("removed.ext", "hash-3", "2020-01-01T12:34:56Z")}},
+		&FileInfo{History: []*FileEvent{event("rewritten.ext", "hash-4", "2020-01-01T12:34:56Z")}},
+		// two files with the same history must be kept apart
+		&FileInfo{History: []*FileEvent{event("same.ext", "hash-5", "2020-01-01T12:34:56Z")}},
+		&FileInfo{History: []*FileEvent{event("same.ext", "hash-5", "2020-01-01T12:34:56Z")}},
+	})
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := boffin.GetFiles()
+	files[0].History = append(files[0].History, event("changed.ext", "hash-6", "2020-01-02T12:34:56Z"))
+	files[1].MarkDeleted()
+	files[3].History = []*FileEvent{event("rewritten.ext", "hash-7", "2020-01-03T12:34:56Z")}
+	files[5].History = append(files[5].History, event("moved.ext", "hash-5", "2020-01-01T12:34:56Z"))
+	files = append(files[:2], files[3:]...)
+	files = append(files, &FileInfo{History: []*FileEvent{event("new.ext", "hash-8", "2020-01-04T12:34:56Z")}})
+	boffin.SetFiles(files)
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := boffin.GetFiles()
+
+	current, err := os.ReadFile(filepath.Join(dbDir, filesFilename))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(current) != string(snapshot) {
+		t.Errorf("Save: repo file was rewritten instead of appending to the journal")
+	}
+
+	reloaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, reloaded.GetFiles()); diff != "" {
+		t.Errorf("LoadBoffin: journal was not replayed correctly:\n%s", diff)
+	}
+
+	if err = reloaded.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dbDir, journalFilename)); !os.IsNotExist(err) {
+		t.Errorf("Compact: journal was not removed")
+	}
+	reloaded, err = LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, reloaded.GetFiles()); diff != "" {
+		t.Errorf("Compact: files changed:\n%s", diff)
+	}
+}
+
+func TestJournalIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := ConstuctDbPath(dir)
+
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetFiles([]*FileInfo{
+		&FileInfo{History: []*FileEvent{
+			&FileEvent{Path: "a.ext", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"},
+		}},
+	})
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = LoadBoffin(dbDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	filename := filepath.Join(dbDir, journalFilename)
+	journal, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	modified := strings.Replace(string(journal), "hash-1", "hash-2", 1)
+	if modified == string(journal) {
+		t.Fatalf("journal does not contain the file")
+	}
+	if err = os.WriteFile(filename, []byte(modified), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = LoadBoffin(dbDir); err == nil || !strings.Contains(err.Error(), "integrity") {
+		t.Errorf("LoadBoffin: expected integrity error for modified journal, got: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Logger receives messages from Update and other operations that change many
+// files. Info messages report the changes, e.g. files added or deleted, debug
+// messages report progress of single files, while warnings and errors report
+// problems that did not stop the operation. Messages do not end with a new
+// line.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// DefaultLogger is used if no Logger is given; info messages are written to
+// stdout.
+var DefaultLogger = NewLogger(os.Stdout)
+
+// NewLogger returns Logger that writes info messages to out, one per line,
+// and debug messages, warnings and errors to the standard logger, i.e. to
+// stderr unless redirected with log.SetOutput. Warnings and errors are
+// prefixed with their level. Info messages are discarded if out is nil.
+func NewLogger(out io.Writer) Logger {
+	if out == nil {
+		out = io.Discard
+	}
+	return &stdLogger{out: out}
+}
+
+type stdLogger struct {
+	out io.Writer
+}
+
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	fmt.Fprintf(l.out, format+"\n", args...)
+}
+
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	log.Printf("warning: "+format, args...)
+}
+
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
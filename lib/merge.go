@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+// MergeReport holds the results of MergeRepos.
+type MergeReport struct {
+	// Merged is the number of local files whose history was merged with the
+	// history of a remote file.
+	Merged int
+	// Added is the number of remote files added to the local repo.
+	Added int
+	// Conflicts are remote files that could not be matched to a single local
+	// file; they are left out of the local repo.
+	Conflicts []*DiffConflict
+}
+
+// MergeRepos folds the metadata of the remote repo into the local one. Remote
+// files which share history with a local file, as matched by Diff, have their
+// histories merged, see MergeHistory, so the file ends up with the newest
+// version known to either repo. Remote files not known locally are added.
+// Files which could not be matched unambiguously are reported as conflicts and
+// not merged. Deletions are not merged into append-only repos. No contents
+// are copied; the local repo is modified, but not saved.
+func MergeRepos(local, remote Boffin) (*MergeReport, error) {
+	diff, err := CollectDiff(local, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MergeReport{}
+	merge := func(pairs []*DiffPair) {
+		for _, pair := range pairs {
+			history := MergeHistory(pair.Local.History, pair.Remote.History)
+			if local.IsAppendOnly() && !pair.Local.IsDeleted() && history[len(history)-1].Checksum == "" {
+				continue
+			}
+			if len(history) != len(pair.Local.History) {
+				pair.Local.History = history
+				report.Merged++
+			}
+		}
+	}
+	merge(diff.Unchanged)
+	merge(diff.MetaDataChanged)
+	merge(diff.Moved)
+	merge(diff.LocalDeleted)
+	merge(diff.RemoteDeleted)
+	merge(diff.BothDeleted)
+	merge(diff.ReAdded)
+	merge(diff.LocalChanged)
+	merge(diff.RemoteChanged)
+
+	for _, files := range [][]*FileInfo{diff.RemoteOnly, diff.RemoteOld} {
+		for _, file := range files {
+			local.AddFile(file.clone())
+			report.Added++
+		}
+	}
+
+	report.Conflicts = append(diff.ConflictHash, diff.ConflictCase...)
+	for _, pairs := range [][]*DiffPair{diff.Diverged, diff.ConflictPath} {
+		for _, pair := range pairs {
+			report.Conflicts = append(report.Conflicts, &DiffConflict{
+				Local:  []*FileInfo{pair.Local},
+				Remote: []*FileInfo{pair.Remote},
+			})
+		}
+	}
+
+	return report, nil
+}
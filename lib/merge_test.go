@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeRepos(t *testing.T) {
+	local := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "shared", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "shared-1"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "diverged", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "diverged-1"},
+					&FileEvent{Path: "diverged", Size: 11, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "diverged-local"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "local-only", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "local-only"},
+				},
+			},
+		},
+	}
+	remote := &db{
+		files: []*FileInfo{
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "shared", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "shared-1"},
+					&FileEvent{Path: "shared-renamed", Size: 12, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "shared-2"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "diverged", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "diverged-1"},
+					&FileEvent{Path: "diverged", Size: 12, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "diverged-remote"},
+				},
+			},
+			{
+				History: []*FileEvent{
+					&FileEvent{Path: "remote-only", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "remote-only"},
+				},
+			},
+		},
+	}
+
+	report, err := MergeRepos(local, remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Merged != 1 || report.Added != 1 || len(report.Conflicts) != 1 {
+		t.Errorf("MergeRepos: expected 1 merged, 1 added and 1 conflict, got %d, %d and %d",
+			report.Merged, report.Added, len(report.Conflicts))
+	}
+
+	paths := []string{}
+	for _, file := range local.GetFiles() {
+		paths = append(paths, file.Path())
+	}
+	sort.Strings(paths)
+	if diff := cmp.Diff([]string{"diverged", "local-only", "remote-only", "shared-renamed"}, paths); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+	if shared := local.GetFileByPath("shared-renamed"); len(shared.History) != 2 || shared.Checksum() != "shared-2" {
+		t.Errorf("shared-renamed: expected merged history, got %v", shared.History)
+	}
+	if diverged := local.GetFileByPath("diverged"); diverged.Checksum() != "diverged-local" {
+		t.Errorf("diverged: expected local version to be kept, got %v", diverged.History)
+	}
+
+	// remote repo is not modified by changes to the local one
+	local.GetFileByPath("remote-only").History[0].Note = "changed"
+	if remote.GetFileByPath("remote-only").History[0].Note != "" {
+		t.Errorf("remote-only: remote file was modified")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// readLimiter limits the rate of reads when calculating checksums; nil means
+// unlimited. See SetMaxReadRate.
+var readLimiter *rateLimiter
+
+// SetMaxReadRate limits reading files when calculating checksums to the given
+// number of bytes per second, shared by all files hashed in parallel, so that
+// verify or update do not saturate the disk. Rate of 0 or less removes the
+// limit. It should be called before any checksums are calculated.
+func SetMaxReadRate(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		readLimiter = nil
+		return
+	}
+	readLimiter = &rateLimiter{rate: bytesPerSecond}
+}
+
+// rateLimiter spreads reads over time so that on average no more than rate
+// bytes are read per second. Every read is scheduled after the previous ones,
+// so concurrent readers share the rate.
+type rateLimiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time // when the reads done so far are paid off
+}
+
+// wait blocks until reading n more bytes stays within the rate.
+func (l *rateLimiter) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader is a reader limited by rateLimiter.
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
+
+// throttle returns the reader limited to the rate set by SetMaxReadRate.
+func throttle(r io.Reader) io.Reader {
+	if readLimiter == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: readLimiter}
+}
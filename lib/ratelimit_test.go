@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetMaxReadRate(t *testing.T) {
+	defer SetMaxReadRate(0)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, strings.Repeat("0123456789", 20000))
+
+	start := time.Now()
+	expected, err := CalculateChecksum(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlimited := time.Since(start)
+
+	// 200kB at 1MB/s takes at least 200ms
+	SetMaxReadRate(1000000)
+	start = time.Now()
+	checksum, err := CalculateChecksum(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	limited := time.Since(start)
+
+	if checksum != expected {
+		t.Errorf("CalculateChecksum: %s != %s", expected, checksum)
+	}
+	if limited < 190*time.Millisecond {
+		t.Errorf("CalculateChecksum: read was not throttled, took %v", limited)
+	}
+	if limited < unlimited {
+		t.Errorf("CalculateChecksum: throttled read took %v, unlimited %v", limited, unlimited)
+	}
+}
+
+func TestSaveIgnoresMaxReadRate(t *testing.T) {
+	defer SetMaxReadRate(0)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "file")
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the repo file is checked for changes before it is replaced, which
+	// would be slow at this rate
+	SetMaxReadRate(10)
+	start := time.Now()
+	boffin.SetCompressed(true)
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Save: throttled by max read rate, took %v", elapsed)
+	}
+}
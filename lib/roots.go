@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Besides the base dir, a repository can track files in additional named
+// roots, e.g. a library split across several drives. Paths of files in a root
+// are prefixed with the root name and RootSeparator, e.g. 'drive2:photos/a.jpg',
+// while files in the base dir keep plain relative paths.
+
+// RootSeparator separates the root name from the path of the file in the root.
+const RootSeparator = ":"
+
+type rootStruct struct {
+	Name string `json:"name"`
+	// relative to the db dir, unless absolute; same as base dir
+	Dir string `json:"dir"`
+}
+
+// GetRoots returns absolute directories of additional roots by their names.
+func (db *db) GetRoots() map[string]string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	roots := map[string]string{}
+	for name, dir := range db.absRoots {
+		roots[name] = dir
+	}
+	return roots
+}
+
+// AddRoot adds a directory as an additional root with the given name. Files
+// in it are picked up by the next update.
+func (db *db) AddRoot(name, dir string) error {
+	if name == "" || strings.Contains(name, RootSeparator) || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid root name '%s'", name)
+	}
+	dir, err := cleanPath(dir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("'%s' does not exist", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	dbDir, err := cleanPath(db.dbDir)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, root := range db.roots {
+		if root.Name == name {
+			return fmt.Errorf("root '%s' already exists", name)
+		}
+	}
+	if overlaps(dir, db.absBaseDir) {
+		return fmt.Errorf("'%s' overlaps with the base dir '%s'", dir, db.absBaseDir)
+	}
+	for other, otherDir := range db.absRoots {
+		if overlaps(dir, otherDir) {
+			return fmt.Errorf("'%s' overlaps with root '%s'", dir, other)
+		}
+	}
+
+	db.roots = append(db.roots, &rootStruct{
+		Name: name,
+		Dir:  relBaseDir(dbDir, dir),
+	})
+	return db.resolveDirs()
+}
+
+// overlaps returns true if either dir is inside the other, in which case files
+// would be tracked twice.
+func overlaps(a, b string) bool {
+	inside := func(dir, parent string) bool {
+		rel, err := filepath.Rel(parent, dir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return inside(a, b) || inside(b, a)
+}
+
+// ResolvePath returns the absolute path of the file with the given repository
+// path, in the base dir or in the root its path is prefixed with.
+func (db *db) ResolvePath(path string) string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if name, rel, ok := strings.Cut(path, RootSeparator); ok {
+		if dir, ok := db.absRoots[name]; ok {
+			return filepath.Join(dir, rel)
+		}
+	}
+	return filepath.Join(db.absBaseDir, path)
+}
+
+// scanRoot is a directory scanned by update, with the prefix of paths of the
+// files found in it.
+type scanRoot struct {
+	dir    string
+	prefix string
+}
+
+// scanRoots returns the base dir followed by additional roots sorted by name.
+func scanRoots(repo Boffin) []scanRoot {
+	roots := []scanRoot{{dir: repo.GetBaseDir()}}
+	names := []string{}
+	dirs := repo.GetRoots()
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		roots = append(roots, scanRoot{dir: dirs[name], prefix: name + RootSeparator})
+	}
+	return roots
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"base", "drive2", "base/sub"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	dbDir := filepath.Join(dir, "base", DefaultDbDirName)
+
+	boffin, err := InitDbDir(dbDir, filepath.Join(dir, "base"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, rootDir := range map[string]string{
+		"":        filepath.Join(dir, "drive2"),
+		"a:b":     filepath.Join(dir, "drive2"),
+		"a/b":     filepath.Join(dir, "drive2"),
+		"missing": filepath.Join(dir, "missing"),
+		"nested":  filepath.Join(dir, "base", "sub"),
+		"parent":  dir,
+	} {
+		if err = boffin.AddRoot(name, rootDir); err == nil {
+			t.Errorf("AddRoot: expected error for '%s' in '%s'", name, rootDir)
+		}
+	}
+
+	if err = boffin.AddRoot("drive2", filepath.Join(dir, "drive2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.AddRoot("drive2", filepath.Join(dir, "drive2")); err == nil {
+		t.Errorf("AddRoot: expected error for duplicate root")
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"drive2": filepath.Join(dir, "drive2")}, loaded.GetRoots()); diff != "" {
+		t.Errorf("GetRoots:\n%s", diff)
+	}
+	for path, expected := range map[string]string{
+		"a.jpg":            filepath.Join(dir, "base", "a.jpg"),
+		"drive2:a.jpg":     filepath.Join(dir, "drive2", "a.jpg"),
+		"drive2:sub/b.jpg": filepath.Join(dir, "drive2", "sub", "b.jpg"),
+		"unknown:c.jpg":    filepath.Join(dir, "base", "unknown:c.jpg"),
+		"sub/drive2:d.jpg": filepath.Join(dir, "base", "sub", "drive2:d.jpg"),
+	} {
+		if resolved := loaded.ResolvePath(path); resolved != expected {
+			t.Errorf("ResolvePath(%s): expected '%s', got '%s'", path, expected, resolved)
+		}
+	}
+}
+
+func TestUpdateRoots(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base")
+	drive2 := filepath.Join(dir, "drive2")
+	writeTestFile(t, filepath.Join(base, "a.jpg"), "a")
+	writeTestFile(t, filepath.Join(base, "moving.jpg"), "moving")
+	writeTestFile(t, filepath.Join(drive2, "b.jpg"), "b")
+	writeTestFile(t, filepath.Join(drive2, "sub", "c.jpg"), "c")
+
+	boffin, err := InitDbDir(ConstuctDbPath(base), base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.AddRoot("drive2", drive2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := func() []string {
+		paths := []string{}
+		for _, file := range boffin.GetFiles() {
+			if !file.IsDeleted() {
+				paths = append(paths, file.Path())
+			}
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"a.jpg", "drive2:b.jpg", filepath.Join("drive2:sub", "c.jpg"), "moving.jpg"}
+	if diff := cmp.Diff(expected, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	// moving between roots keeps the history of the file
+	if err = os.Rename(filepath.Join(base, "moving.jpg"), filepath.Join(drive2, "moving.jpg")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moved := boffin.GetFileByPath("drive2:moving.jpg")
+	if moved == nil || len(moved.History) != 2 || moved.History[0].Path != "moving.jpg" {
+		t.Errorf("drive2:moving.jpg: expected to be moved from moving.jpg, got %v", moved)
+	}
+
+	// missing root fails the update instead of deleting its files
+	if err = os.Rename(drive2, drive2+".unplugged"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err == nil {
+		t.Errorf("Update: expected error for missing root")
+	}
+	expected = []string{"a.jpg", "drive2:b.jpg", "drive2:moving.jpg", filepath.Join("drive2:sub", "c.jpg")}
+	if diff := cmp.Diff(expected, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+// RepoStats holds summary metrics of a repository.
+type RepoStats struct {
+	// Files is the number of live files; deleted ones are not included.
+	Files   int
+	Deleted int
+	// Bytes is the total size of live files.
+	Bytes int64
+	// Checksums is the number of distinct contents among live files.
+	Checksums int
+	// DuplicateGroups is the number of contents shared by more than one live
+	// file, and DuplicateFiles the number of files in those groups.
+	DuplicateGroups int
+	DuplicateFiles  int
+	// Events is the total number of events in the history of all files.
+	Events           int
+	MaxHistoryLength int
+	OldestEvent      time.Time
+	NewestEvent      time.Time
+}
+
+// AverageHistoryLength returns the average number of events per file,
+// including deleted files.
+func (s *RepoStats) AverageHistoryLength() float64 {
+	if s.Files+s.Deleted == 0 {
+		return 0
+	}
+	return float64(s.Events) / float64(s.Files+s.Deleted)
+}
+
+// CollectStats calculates metrics of all files in the repository.
+func CollectStats(repo Boffin) *RepoStats {
+	stats := &RepoStats{}
+	files := repo.GetFiles()
+
+	for _, file := range files {
+		if file.IsDeleted() {
+			stats.Deleted++
+		} else {
+			stats.Files++
+			stats.Bytes += file.Size()
+		}
+
+		stats.Events += len(file.History)
+		if len(file.History) > stats.MaxHistoryLength {
+			stats.MaxHistoryLength = len(file.History)
+		}
+		for _, event := range file.History {
+			if stats.OldestEvent.IsZero() || event.Time.Before(stats.OldestEvent) {
+				stats.OldestEvent = event.Time
+			}
+			if event.Time.After(stats.NewestEvent) {
+				stats.NewestEvent = event.Time
+			}
+		}
+	}
+
+	byHash := FilesToHashMap(files)
+	stats.Checksums = len(byHash)
+	for _, group := range byHash {
+		if len(group) > 1 {
+			stats.DuplicateGroups++
+			stats.DuplicateFiles += len(group)
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCollectStats(t *testing.T) {
+	boffin := &db{
+		files: []*FileInfo{
+			{History: []*FileEvent{
+				&FileEvent{Path: "file", Size: 10, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-1"},
+				&FileEvent{Path: "file", Size: 20, Time: parseTime("2020-01-03T12:34:56Z"), Checksum: "hash-2"},
+			}},
+			{History: []*FileEvent{
+				&FileEvent{Path: "dup-1", Size: 5, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-3"},
+			}},
+			{History: []*FileEvent{
+				&FileEvent{Path: "dup-2", Size: 5, Time: parseTime("2020-01-04T12:34:56Z"), Checksum: "hash-3"},
+			}},
+			{History: []*FileEvent{
+				&FileEvent{Path: "deleted", Size: 7, Time: parseTime("2020-01-02T12:34:56Z"), Checksum: "hash-4"},
+				&FileEvent{Path: "deleted", Time: parseTime("2020-01-05T12:34:56Z")},
+			}},
+		},
+	}
+
+	expected := &RepoStats{
+		Files:            3,
+		Deleted:          1,
+		Bytes:            30,
+		Checksums:        2,
+		DuplicateGroups:  1,
+		DuplicateFiles:   2,
+		Events:           6,
+		MaxHistoryLength: 2,
+		OldestEvent:      parseTime("2020-01-01T12:34:56Z"),
+		NewestEvent:      parseTime("2020-01-05T12:34:56Z"),
+	}
+	stats := CollectStats(boffin)
+	if diff := cmp.Diff(expected, stats); diff != "" {
+		t.Errorf("CollectStats:\n%s", diff)
+	}
+	if stats.AverageHistoryLength() != 1.5 {
+		t.Errorf("AverageHistoryLength: 1.5 != %v", stats.AverageHistoryLength())
+	}
+}
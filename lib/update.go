@@ -18,11 +18,18 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // FilterFunc is function type, that determines if a file should be processed or
@@ -45,26 +52,373 @@ func ForceCheck(info os.FileInfo, local *FileInfo) bool {
 	return true
 }
 
+// SampleCheck returns a FilterFunc which checks files whose metadata changed,
+// same as CheckIfMetaChanged, and also a random fraction of the unchanged
+// files, to catch silent corruption without hashing everything. Files are
+// picked in the order they are scanned, so the same seed picks the same files
+// as long as the files did not change; seed 0 picks a new sample every time.
+func SampleCheck(fraction float64, seed int64) FilterFunc {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var mu sync.Mutex
+	random := rand.New(rand.NewSource(seed))
+
+	return func(info os.FileInfo, localFile *FileInfo) bool {
+		if CheckIfMetaChanged(info, localFile) {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return random.Float64() < fraction
+	}
+}
+
+// UpdateOptions control how Update scans the files and records changes.
+type UpdateOptions struct {
+	// Filter decides which files should have their checksum recalculated.
+	// Defaults to CheckIfMetaChanged.
+	Filter FilterFunc
+	// Note is recorded with every event created by the update, e.g. to
+	// describe why or from where the files were added.
+	Note string
+	// Preview only prints the events that would be recorded, without making
+	// any changes to the repo.
+	Preview bool
+	// HashAlgorithm is used to calculate checksums of new files. Files already
+	// in the repo keep the algorithm they were hashed with. Defaults to
+	// DefaultHashAlgorithm.
+	HashAlgorithm HashAlgorithm
+	// PartialHashSize, if set, enables partial hashing of new files larger
+	// than twice the size; only the first and the last PartialHashSize bytes
+	// are hashed. This is much faster for large files, but changes in the
+	// middle of such files will not be detected. See PartialHash.
+	PartialHashSize int64
+	// Workers is the number of files hashed in parallel. Defaults to the
+	// number of CPUs.
+	Workers int
+	// Progress, if set, is called every time a file is scanned or hashed.
+	Progress ProgressFunc
+	// FollowSymlinks makes the update descend into symlinked directories and
+	// record symlinked files with the size, time and contents of their
+	// target. Otherwise symlinked directories are not scanned.
+	FollowSymlinks bool
+	// KeepPartial records the changes found before the update was canceled.
+	// Files that were not scanned or hashed yet are kept as they were.
+	// Otherwise a canceled update does not change the repo.
+	KeepPartial bool
+	// MinSize, if set, skips files smaller than this many bytes, so that they
+	// are not added to the repo. Files already in the repo are kept as they
+	// are, even if they become smaller.
+	MinSize int64
+	// Paths, if set, limit the update to these files or directories, given
+	// as repository paths. Files outside of them are not scanned and are kept
+	// as they are, instead of being marked deleted.
+	Paths []string
+	// SkipImportDir treats the import directory as a staging area; new files
+	// in it are not added until they are moved out of it. Files already in the
+	// repo are kept as they are while they exist, so that moving them out is
+	// recorded as a move.
+	SkipImportDir bool
+	// Logger receives the changes as they are recorded, and any warnings.
+	// Defaults to DefaultLogger.
+	Logger Logger
+	// DetectCorruption treats files whose contents changed, but size and
+	// modification time did not, as corrupted rather than changed; they are
+	// kept in the repo as they were, and *CorruptedError is returned once all
+	// other changes are recorded. Meant to be used with SampleCheck, as
+	// otherwise such files are never hashed.
+	DetectCorruption bool
+}
+
+// updateScope holds repository paths the update is limited to; empty scope
+// covers the whole repository.
+type updateScope []string
+
+func newUpdateScope(paths []string) updateScope {
+	scope := updateScope{}
+	for _, path := range paths {
+		if name, rel, ok := strings.Cut(path, RootSeparator); ok && filepath.Clean(rel) == "." {
+			// whole root
+			scope = append(scope, name+RootSeparator)
+			continue
+		}
+		path = filepath.Clean(path)
+		if path == "." {
+			return updateScope{}
+		}
+		scope = append(scope, path)
+	}
+	return scope
+}
+
+// contains returns true if the file or directory is inside the scope.
+func (s updateScope) contains(relPath string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, path := range s {
+		if relPath == path || strings.HasPrefix(relPath, path+string(filepath.Separator)) ||
+			(strings.HasSuffix(path, RootSeparator) && strings.HasPrefix(relPath, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// leadsTo returns true if the directory is inside the scope, or contains a
+// part of it, and so must be walked.
+func (s updateScope) leadsTo(relDir string) bool {
+	if s.contains(relDir) {
+		return true
+	}
+	for _, path := range s {
+		if strings.HasPrefix(path, relDir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateProgress holds counts of work done so far by Update.
+type UpdateProgress struct {
+	// Scanned is the number of files found in the base directory.
+	Scanned int
+	// Hashed is the number of files whose checksum was calculated.
+	Hashed int
+	// Bytes is the total size of the hashed files.
+	Bytes int64
+	// Done is set on the last call, once all files are scanned and hashed.
+	Done bool
+}
+
+// ProgressFunc is called by Update to report progress. Calls are never made
+// concurrently, even though files are hashed in parallel.
+type ProgressFunc func(progress UpdateProgress)
+
+// progressTracker accumulates progress from the walk and the hash workers and
+// reports it to the callback, if any.
+type progressTracker struct {
+	mu       sync.Mutex
+	progress UpdateProgress
+	callback ProgressFunc
+}
+
+func (p *progressTracker) scanned() {
+	if p.callback == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Scanned++
+	p.callback(p.progress)
+}
+
+func (p *progressTracker) hashed(size int64) {
+	if p.callback == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Hashed++
+	p.progress.Bytes += size
+	p.callback(p.progress)
+}
+
+func (p *progressTracker) done() {
+	if p.callback == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Done = true
+	p.callback(p.progress)
+}
+
 // Update will compare the boffin repo with the files in the monitored directory
 // and update the repo with any changes.
 func Update(repo Boffin, filter FilterFunc) error {
+	return UpdateWithOptions(repo, &UpdateOptions{
+		Filter: filter,
+	})
+}
+
+// UpdateContext is the same as Update, but stops when ctx is canceled, in
+// which case ctx.Err() is returned and the repo is not changed.
+func UpdateContext(ctx context.Context, repo Boffin, filter FilterFunc) error {
+	return UpdateWithOptionsContext(ctx, repo, &UpdateOptions{
+		Filter: filter,
+	})
+}
+
+// UpdateWithOptions is the same as Update, but allows more control over the
+// update. nil options are the same as calling Update with nil filter.
+//
+// Files and directories that can not be read are skipped and the rest of the
+// changes are recorded, in which case *UnreadableError is returned. Same goes
+// for files found corrupted with DetectCorruption; see *CorruptedError.
+func UpdateWithOptions(repo Boffin, opts *UpdateOptions) error {
+	return UpdateWithOptionsContext(context.Background(), repo, opts)
+}
+
+// UpdateWithOptionsContext is the same as UpdateWithOptions, but stops when
+// ctx is canceled and returns ctx.Err(). Changes found so far are recorded
+// only if KeepPartial option is set.
+func UpdateWithOptionsContext(ctx context.Context, repo Boffin, opts *UpdateOptions) error {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	logger := opts.logger()
+
+	originals := repo.GetFiles()
+	local, checkedFiles, scanErr := scanBaseDir(ctx, repo, originals, opts, !opts.Preview)
+	if scanErr != nil && !isSkippedFilesError(scanErr) {
+		if scanErr != ctx.Err() || !opts.KeepPartial {
+			return scanErr
+		}
+	}
+
+	if opts.Preview {
+		err := Diff(local, checkedFiles, &previewAction{
+			repo:   repo,
+			note:   opts.Note,
+			logger: logger,
+		})
+		if err != nil {
+			return err
+		}
+		return scanErr
+	}
+
+	err := Diff(local, checkedFiles, &updateAction{
+		repo:   repo,
+		local:  local,
+		note:   opts.Note,
+		logger: logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	// files added or removed while the update was running are kept that way
+	if repo, ok := repo.(*db); ok {
+		repo.applyUpdate(originals, local.files)
+	} else {
+		repo.SetFiles(local.files)
+	}
+	return scanErr
+}
+
+// Status scans the base directory the same way as Update, but instead of
+// recording any changes, returns them as a diff of the repo against the files
+// found. The repo, and its checksum cache, are not modified. Only Filter, HashAlgorithm,
+// PartialHashSize, Workers, Progress, FollowSymlinks, MinSize and Paths options
+// are used. Same as Update, the
+// report is returned together with *UnreadableError if some files could not
+// be read.
+func Status(repo Boffin, opts *UpdateOptions) (*DiffReport, error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+
+	local, checkedFiles, scanErr := scanBaseDir(context.Background(), repo, repo.GetFiles(), opts, false)
+	if scanErr != nil && !isSkippedFilesError(scanErr) {
+		return nil, scanErr
+	}
+
+	action := &collectAction{
+		report: &DiffReport{},
+	}
+	if err := Diff(local, checkedFiles, action); err != nil {
+		return nil, err
+	}
+	return action.report, scanErr
+}
+
+func (o *UpdateOptions) logger() Logger {
+	if o.Logger == nil {
+		return DefaultLogger
+	}
+	return o.Logger
+}
+
+// scanBaseDir walks the base directory and returns a copy of the given files
+// of the repo, in the same order, and files found in the base directory. Files that were not checked
+// because of the filter are shared between the two. If ctx is canceled, files
+// found so far are returned together with ctx.Err(); files that were not
+// scanned or hashed are then returned as they were in the repo. The checksum
+// cache is saved only if saveCache is set, so that scans which record nothing
+// leave the db dir untouched.
+func scanBaseDir(ctx context.Context, repo Boffin, files []*FileInfo, opts *UpdateOptions, saveCache bool) (local, checkedFiles *db, err error) {
+	filter := opts.Filter
 	if filter == nil {
 		filter = CheckIfMetaChanged
 	}
+	logger := opts.logger()
+	hashAlgorithm := opts.HashAlgorithm
+	if hashAlgorithm == "" {
+		hashAlgorithm = DefaultHashAlgorithm
+	}
+	if _, err := newHash(hashAlgorithm); err != nil {
+		return nil, nil, err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// a missing root must not be mistaken for all of its files being deleted
+	roots := scanRoots(repo)
+	for _, root := range roots {
+		what := "base directory"
+		if root.prefix != "" {
+			what = fmt.Sprintf("root '%s'", strings.TrimSuffix(root.prefix, RootSeparator))
+		}
+		info, err := os.Stat(root.dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s '%s' does not exist", what, root.dir)
+		}
+		if !info.IsDir() {
+			return nil, nil, fmt.Errorf("%s '%s' is not a directory", what, root.dir)
+		}
+	}
 
-	dir := repo.GetBaseDir()
+	// work on a copy of the files, so that the repository can be safely read
+	// while the update is running
+	files = append([]*FileInfo{}, files...)
+	for i, file := range files {
+		files[i] = file.clone()
+	}
+	// colliding checksums would make Diff record wrong moves and changes
+	if err := ValidateChecksums(files); err != nil {
+		return nil, nil, err
+	}
+	local = &db{
+		files: files,
+	}
 
-	info, err := os.Stat(dir)
-	if err != nil {
-		return fmt.Errorf("base directory '%s' does not exist", dir)
+	// every root has its own ignore file, while patterns in the repo file
+	// apply to paths in the repo; paths matched by either are ignored
+	var repoIgnore ignore
+	if repo, ok := repo.(*db); ok {
+		repoIgnore = repo.ignore
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("base directory '%s' is not a directory", dir)
+	ignores := make([]ignore, len(roots))
+	for i, root := range roots {
+		if ignores[i], err = loadIgnoreFile(filepath.Join(root.dir, ignoreFilename)); err != nil {
+			return nil, nil, fmt.Errorf("error reading ignore file: %v", err)
+		}
+	}
+	tracks := make([]ignore, len(roots))
+	for i, root := range roots {
+		if tracks[i], err = loadIgnoreFile(filepath.Join(root.dir, trackFilename)); err != nil {
+			return nil, nil, fmt.Errorf("error reading track file: %v", err)
+		}
 	}
 
-	localByPath := filesToPathMap(repo.GetFiles())
+	localByPath := filesToPathMap(files)
 
-	checkedFiles := &db{
+	checkedFiles = &db{
 		dbDir:        repo.GetDbDir(),
 		absBaseDir:   repo.GetBaseDir(),
 		absImportDir: repo.GetImportDir(),
@@ -73,82 +427,467 @@ func Update(repo Boffin, filter FilterFunc) error {
 		files:        []*FileInfo{},
 	}
 
+	// files that need to be hashed are handed over to a pool of workers; jobs
+	// are kept in walk order, so the results do not depend on which worker
+	// finishes first
+	progress := &progressTracker{callback: opts.Progress}
+	cache := loadChecksumCache(repo.GetDbDir(), logger)
+	scope := newUpdateScope(opts.Paths)
+	unreadable := &unreadableTracker{}
+	var skippedDirs []string
+	jobs := []*hashJob{}
+	pending := make(chan *hashJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range pending {
+				if ctx.Err() != nil {
+					// canceled; drain the remaining jobs without hashing
+					continue
+				}
+				if err := job.run(); err != nil {
+					unreadable.add(err)
+					continue
+				}
+				progress.hashed(job.info.Size())
+			}
+		}()
+	}
+
 	// # get list of files that should be checked
 	// - for each file on the file system
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if os.IsPermission(err) {
-				log.Printf("%s: permission denied", path)
+	absDbDir, err := cleanPath(repo.GetDbDir())
+	if err != nil {
+		return nil, nil, err
+	}
+	var absImportDir, importPrefix string
+	if opts.SkipImportDir {
+		absImportDir = repo.GetImportDir()
+	}
+	for i, root := range roots {
+		dir, prefix, ignored, metadataOnly := root.dir, root.prefix, ignores[i], tracks[i]
+		err = walk(dir, opts.FollowSymlinks, logger, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				if path == dir {
+					return fmt.Errorf("%s: error reading base directory: %s", path, err)
+				}
+				// skip what can not be read, but keep scanning the rest
+				unreadable.add(err)
+				if info != nil && info.IsDir() {
+					skippedDirs = append(skippedDirs, prefix+path[len(dir)+1:])
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if info.Name() == DefaultDbDirName || path == absDbDir { // skip DB directory
+					// fmt.Printf("skip %s\n", path)
+					return filepath.SkipDir
+				} else if strings.HasPrefix(info.Name(), ".") {
+					// fmt.Printf("skip %s\n", path)
+					return filepath.SkipDir
+				} else if path != dir && path == absImportDir {
+					importPrefix = prefix + path[len(dir)+1:]
+					return filepath.SkipDir
+				} else if path != dir && (ignored.match(path[len(dir)+1:], true) || repoIgnore.match(prefix+path[len(dir)+1:], true)) {
+					return filepath.SkipDir
+				} else if path != dir && !scope.leadsTo(prefix+path[len(dir)+1:]) {
+					return filepath.SkipDir
+				}
+				// fmt.Printf("dir %s\n", path)
+				return nil
+			}
+
+			// sanity check which has never fired
+			root := path[:len(dir)]
+			if dir != root {
+				// this should never happen
+				log.Panicf("unexpected error; root mismatch '%s' != '%s'", dir, root)
+			}
+
+			if ignored.match(path[len(dir)+1:], false) || repoIgnore.match(prefix+path[len(dir)+1:], false) {
+				return nil
+			}
+			// leftovers of an interrupted import; see FindTempFiles
+			if isTempFile(info.Name()) {
+				return nil
+			}
+			relPath := prefix + path[len(dir)+1:]
+			if !scope.contains(relPath) {
+				return nil
+			}
+
+			localFile, ok := localByPath[relPath]
+			if isSpecialFile(info) {
+				// reading pipes or devices could block or never end; keep
+				// whatever was recorded at this path before
+				logger.Warnf("%s: skipping special file", relPath)
+				if ok && !localFile.IsDeleted() {
+					delete(localByPath, relPath)
+					jobs = append(jobs, &hashJob{relPath: relPath, info: info, local: localFile, file: localFile})
+				}
+				return nil
+			}
+			progress.scanned()
+
+			if opts.MinSize > 0 && info.Size() < opts.MinSize {
+				if ok && !localFile.IsDeleted() {
+					delete(localByPath, relPath)
+					jobs = append(jobs, &hashJob{relPath: relPath, info: info, local: localFile, file: localFile})
+				}
+				return nil
+			}
+			var checkFile bool
+			algorithm := hashAlgorithm
+			isMetadataOnly := metadataOnly.matchFile(path[len(dir)+1:])
+			if isMetadataOnly {
+				algorithm = HashMetadataOnly
+			} else if opts.PartialHashSize > 0 && info.Size() > 2*opts.PartialHashSize {
+				algorithm = PartialHash(hashAlgorithm, opts.PartialHashSize)
+			}
+			if ok {
+				delete(localByPath, relPath)
+				checkFile = filter(info, localFile)
+				if !localFile.IsDeleted() {
+					if isMetadataOnly != (localFile.HashAlgorithm() == HashMetadataOnly) {
+						// file was added to or removed from the track file;
+						// record it again with the new algorithm
+						checkFile = true
+					} else if !isMetadataOnly {
+						// keep the algorithm, otherwise the checksum would not match
+						algorithm = localFile.HashAlgorithm()
+					}
+				}
 			} else {
-				return fmt.Errorf("%s: error getting file info: %s", path, err)
+				checkFile = true
 			}
-		}
-		if info.IsDir() {
-			if info.Name() == defaultDbDir { // skip DB directory
-				// fmt.Printf("skip %s\n", path)
-				return filepath.SkipDir
-			} else if strings.HasPrefix(info.Name(), ".") {
-				// fmt.Printf("skip %s\n", path)
-				return filepath.SkipDir
+
+			job := &hashJob{
+				path:      path,
+				relPath:   relPath,
+				info:      info,
+				algorithm: algorithm,
+				note:      opts.Note,
+				local:     localFile,
+				cache:     cache,
+				logger:    logger,
+				// metadata matching the repo means the filter asked for the
+				// contents to be checked, which the cache must not short-cut
+				useCache: CheckIfMetaChanged(info, localFile),
+			}
+			if !checkFile { // no need to check, assume identical
+				// fmt.Printf("==%s\n", localFile.Path())
+				job.file = localFile
+				cache.keep(relPath)
+				jobs = append(jobs, job)
+				return nil
 			}
-			// fmt.Printf("dir %s\n", path)
+
+			pending <- job
+			jobs = append(jobs, job)
 			return nil
+		})
+		if err != nil {
+			break
 		}
-
-		// sanity check which has never fired
-		root := path[:len(dir)]
-		if dir != root {
-			// this should never happen
-			log.Panicf("unexpected error; root mismatch '%s' != '%s'", dir, root)
+	}
+	close(pending)
+	wg.Wait()
+	cache.keepIf(func(relPath string) bool {
+		return !scope.contains(relPath)
+	})
+	if saveCache {
+		if err := cache.save(); err != nil {
+			logger.Warnf("failed to save checksum cache: %v", err)
 		}
+	}
+	canceled := ctx.Err()
+	if err != nil && err != canceled {
+		return nil, nil, err
+	}
+	progress.done()
 
-		relPath := path[len(dir)+1:]
-
-		localFile, ok := localByPath[relPath]
-		var checkFile bool
-		if ok {
-			delete(localByPath, relPath)
-			checkFile = filter(info, localFile)
-		} else {
-			checkFile = true
+	corrupted := []string{}
+	for _, job := range jobs {
+		if opts.DetectCorruption && job.isCorrupted() {
+			logger.Errorf("%s: contents changed, but size and modification time did not; possibly corrupted", job.relPath)
+			corrupted = append(corrupted, job.relPath)
+			job.file = job.local
 		}
-
-		if checkFile {
-			// fmt.Printf("CC%s\n", relPath)
-			hash, err := CalculateChecksum(path)
-			if err != nil {
-				return err
+		if job.file == nil {
+			// file could not be read; keep it as it was
+			if job.local == nil || job.local.IsDeleted() {
+				continue
 			}
-			log.Printf("%s: %s\n", hash, relPath)
-
-			checkedFiles.files = append(checkedFiles.files, &FileInfo{
-				History: []*FileEvent{
-					&FileEvent{
-						Path:     relPath,
-						Time:     info.ModTime(),
-						Size:     info.Size(),
-						Checksum: hash,
-					},
-				},
-			})
-		} else { // no need to check, assume identical
-			// fmt.Printf("==%s\n", localFile.Path())
+			job.file = job.local
+		}
+		checkedFiles.files = append(checkedFiles.files, job.file)
+	}
+	// files in directories that could not be read, or were not scanned
+	// because the update was canceled or they are out of scope, must not be
+	// recorded as deleted
+	for relPath, localFile := range localByPath {
+		if canceled != nil || !scope.contains(relPath) {
 			checkedFiles.files = append(checkedFiles.files, localFile)
+			continue
+		}
+		for _, skipped := range skippedDirs {
+			if strings.HasPrefix(relPath, skipped+string(filepath.Separator)) {
+				checkedFiles.files = append(checkedFiles.files, localFile)
+				break
+			}
+		}
+		if importPrefix != "" && !localFile.IsDeleted() && strings.HasPrefix(relPath, importPrefix+string(filepath.Separator)) {
+			if _, err := os.Lstat(repo.ResolvePath(relPath)); err == nil {
+				checkedFiles.files = append(checkedFiles.files, localFile)
+			}
+		}
+	}
+
+	if canceled != nil {
+		return local, checkedFiles, canceled
+	}
+	if len(corrupted) > 0 {
+		err := &CorruptedError{Paths: corrupted}
+		if unreadableErr := unreadable.err(); unreadableErr != nil {
+			err.Unreadable = unreadableErr.(*UnreadableError)
 		}
+		return local, checkedFiles, err
+	}
+	return local, checkedFiles, unreadable.err()
+}
+
+// isSpecialFile returns true for named pipes, sockets, devices and other
+// files which do not hold contents that could be read and tracked.
+func isSpecialFile(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice|os.ModeIrregular) != 0
+}
+
+// UnreadableError is returned by Update when some files or directories could
+// not be read. They are skipped and kept in the repo as they were, but all
+// other changes are recorded.
+type UnreadableError struct {
+	Errors []error
+}
 
+func (e *UnreadableError) Error() string {
+	lines := []string{fmt.Sprintf("%d paths could not be read:", len(e.Errors))}
+	for _, err := range e.Errors {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CorruptedError is returned by Update with DetectCorruption option when the
+// contents of some files changed, but their size and modification time did
+// not. They are kept in the repo as they were, but all other changes are
+// recorded. Files that could not be read are available with errors.As.
+type CorruptedError struct {
+	Paths      []string
+	Unreadable *UnreadableError
+}
+
+func (e *CorruptedError) Error() string {
+	lines := []string{fmt.Sprintf("%d files are possibly corrupted:", len(e.Paths))}
+	for _, path := range e.Paths {
+		lines = append(lines, "  "+path)
+	}
+	if e.Unreadable != nil {
+		lines = append(lines, e.Unreadable.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *CorruptedError) Unwrap() error {
+	if e.Unreadable == nil {
 		return nil
-	})
+	}
+	return e.Unreadable
+}
+
+// isSkippedFilesError returns true for errors reporting files that were kept
+// as they were, while the rest of the update can be recorded.
+func isSkippedFilesError(err error) bool {
+	var unreadable *UnreadableError
+	var corrupted *CorruptedError
+	return errors.As(err, &unreadable) || errors.As(err, &corrupted)
+}
+
+// unreadableTracker collects errors from the walk and the hash workers.
+type unreadableTracker struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (u *unreadableTracker) add(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.errors = append(u.errors, err)
+}
+
+// err returns nil if all files could be read; it is called once the workers
+// are done.
+func (u *unreadableTracker) err() error {
+	if len(u.errors) == 0 {
+		return nil
+	}
+	return &UnreadableError{Errors: u.errors}
+}
+
+// walk is the same as filepath.Walk, but reads directories concurrently (see
+// parallelWalk), unless followSymlinks is set. Then symlinks are resolved;
+// symlinked directories are walked as if they were under root and symlinked
+// files are reported with the info of their target. To guard against cycles,
+// every directory is walked only once, no matter how many symlinks lead to
+// it.
+func walk(root string, followSymlinks bool, logger Logger, walkFn filepath.WalkFunc) error {
+	if !followSymlinks {
+		return parallelWalk(root, walkFn)
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walkFollowingSymlinks(root, info, map[string]bool{}, logger, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkFollowingSymlinks(path string, info os.FileInfo, visited map[string]bool, logger Logger, walkFn filepath.WalkFunc) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			logger.Warnf("%s: skipping broken symlink: %v", path, err)
+			return nil
+		}
+		info = target
+	}
+	if !info.IsDir() {
+		return walkFn(path, info, nil)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
 	if err != nil {
+		return walkFn(path, info, err)
+	}
+	if visited[resolved] {
+		logger.Warnf("%s: skipping symlink to '%s'; it was already scanned or leads to a cycle", path, resolved)
+		return nil
+	}
+
+	if err := walkFn(path, info, nil); err == filepath.SkipDir {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	visited[resolved] = true
 
-	return Diff(repo, checkedFiles, &updateAction{
-		repo: repo,
-	})
+	dir, err := os.Open(path)
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	names, err := dir.Readdirnames(-1)
+	_ = dir.Close()
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		childInfo, err := os.Lstat(child)
+		if err != nil {
+			if err := walkFn(child, childInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		// directories handle SkipDir themselves, so here it comes from a
+		// file and skips the rest of the directory
+		if err := walkFollowingSymlinks(child, childInfo, visited, logger, walkFn); err == filepath.SkipDir {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashJob is a file found during the update walk. If the file needs to be
+// checked, file is set once its checksum is calculated.
+type hashJob struct {
+	path      string
+	relPath   string
+	info      os.FileInfo
+	algorithm HashAlgorithm
+	note      string
+	local     *FileInfo
+	cache     *checksumCache
+	useCache  bool
+	logger    Logger
+
+	file *FileInfo
+}
+
+// isCorrupted returns true if the file was hashed with the same algorithm as
+// before, and its checksum changed while its size and time did not.
+func (job *hashJob) isCorrupted() bool {
+	if job.file == nil || job.local == nil || job.file == job.local || job.local.IsDeleted() {
+		return false
+	}
+	return job.algorithm == job.local.HashAlgorithm() &&
+		!CheckIfMetaChanged(job.info, job.local) &&
+		job.file.checksumKey() != job.local.checksumKey()
+}
+
+func (job *hashJob) run() error {
+	// fmt.Printf("CC%s\n", job.relPath)
+	hash, ok := "", false
+	if job.useCache {
+		hash, ok = job.cache.get(job.relPath, job.info, job.algorithm)
+	}
+	if !ok {
+		var err error
+		hash, err = calculateChecksum(job.path, job.algorithm)
+		if err != nil {
+			return err
+		}
+		if job.cache != nil {
+			job.cache.put(job.relPath, job.info, job.algorithm, hash)
+		}
+	}
+	job.logger.Debugf("%s: %s", hash, job.relPath)
+
+	event := &FileEvent{
+		Path:     job.relPath,
+		Time:     job.info.ModTime(),
+		Size:     job.info.Size(),
+		Checksum: hash,
+		Mode:     job.info.Mode().Perm(),
+		Note:     job.note,
+	}
+	if job.algorithm != DefaultHashAlgorithm {
+		event.Algorithm = job.algorithm
+	}
+	job.file = &FileInfo{
+		History: []*FileEvent{event},
+	}
+	return nil
 }
 
 type updateAction struct {
-	repo Boffin
+	repo   Boffin
+	local  *db
+	note   string
+	logger Logger
 }
 
 func (a *updateAction) Unchanged(localFile, remoteFile *FileInfo) {
@@ -156,18 +895,30 @@ func (a *updateAction) Unchanged(localFile, remoteFile *FileInfo) {
 }
 
 func (a *updateAction) MetaDataChanged(localFile, remoteFile *FileInfo) {
-	fmt.Printf("M%s\n", localFile.Path())
-	localFile.History = append(localFile.History, remoteFile.History...)
+	a.logger.Infof("M%s", localFile.Path())
+	// content is the same, so only update the latest event instead of adding
+	// a new one; the event is copied as it may be shared with other files
+	last := len(localFile.History) - 1
+	event := *localFile.History[last]
+	event.Size = remoteFile.Size()
+	event.Time = remoteFile.Time()
+	event.Mode = remoteFile.Mode()
+	localFile.History[last] = &event
 }
 
 func (a *updateAction) Moved(localFile, remoteFile *FileInfo) {
-	fmt.Printf("@%s => %s\n", localFile.Path(), remoteFile.Path())
+	a.logger.Infof("@%s => %s", localFile.Path(), remoteFile.Path())
 	localFile.History = append(localFile.History, remoteFile.History...)
 }
 
 func (a *updateAction) LocalOnly(localFile *FileInfo) {
-	fmt.Printf("-%s\n", localFile.Path())
+	if a.repo.IsAppendOnly() {
+		a.logger.Warnf("repository is append-only, not marking as deleted: -%s", localFile.Path())
+		return
+	}
+	a.logger.Infof("-%s", localFile.Path())
 	localFile.MarkDeleted()
+	localFile.History[len(localFile.History)-1].Note = a.note
 }
 
 func (a *updateAction) LocalOld(localFile *FileInfo) {
@@ -175,8 +926,8 @@ func (a *updateAction) LocalOld(localFile *FileInfo) {
 }
 
 func (a *updateAction) RemoteOnly(remoteFile *FileInfo) {
-	fmt.Printf("+%s\n", remoteFile.Path())
-	a.repo.AddFile(remoteFile)
+	a.logger.Infof("+%s", remoteFile.Path())
+	a.local.AddFile(remoteFile)
 }
 
 func (a *updateAction) RemoteOld(remoteFile *FileInfo) {
@@ -184,50 +935,216 @@ func (a *updateAction) RemoteOld(remoteFile *FileInfo) {
 }
 
 func (a *updateAction) LocalDeleted(localFile, remoteFile *FileInfo) {
-	log.Panicf("local deleted; should never happen for updateAction: %s", localFile.Path())
+	// the file on disk is older than the deletion, e.g. restored from a
+	// backup, but it is still back
+	a.ReAdded(localFile, remoteFile)
 }
 
 func (a *updateAction) RemoteDeleted(localFile, remoteFile *FileInfo) {
 	panic("remote deleted should never happen for updateAction")
 }
 
-func (a *updateAction) LocalChanged(localFile, remoteFile *FileInfo) {
+func (a *updateAction) BothDeleted(localFile, remoteFile *FileInfo) {
+	// do nothing
+}
+
+func (a *updateAction) ReAdded(localFile, remoteFile *FileInfo) {
+	a.logger.Infof("+%s", remoteFile.Path())
+	localFile.History = append(localFile.History, &FileEvent{
+		Path:      remoteFile.Path(),
+		Time:      remoteFile.Time(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
+	})
+}
+
+func (a *updateAction) LocalChanged(localFile, remoteFile *FileInfo, moved bool) {
 	// panic("local changed should never happen for updateAction")
-	fmt.Printf("WARNING: Local should not change during update: ~%s => %s\n", localFile.Path(), remoteFile.Path())
+	a.logger.Warnf("local should not change during update: ~%s => %s", localFile.Path(), remoteFile.Path())
 }
 
-func (a *updateAction) RemoteChanged(localFile, remoteFile *FileInfo) {
-	fmt.Printf("~%s => %s\n", localFile.Path(), remoteFile.Path())
+func (a *updateAction) RemoteChanged(localFile, remoteFile *FileInfo, moved bool) {
+	a.logger.Infof("~%s => %s", localFile.Path(), remoteFile.Path())
 	localFile.History = append(localFile.History, &FileEvent{
-		Path:     remoteFile.Path(),
-		Time:     remoteFile.Time(),
-		Size:     remoteFile.Size(),
-		Checksum: remoteFile.Checksum(),
+		Path:      remoteFile.Path(),
+		Time:      remoteFile.Time(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
 	})
 }
 
 func (a *updateAction) ConflictPath(localFile, remoteFile *FileInfo) {
-	fmt.Printf("~%s => %s\n", localFile.Path(), remoteFile.Path())
+	a.logger.Infof("~%s => %s", localFile.Path(), remoteFile.Path())
 	localFile.History = append(localFile.History, &FileEvent{
-		Path:     remoteFile.Path(),
-		Time:     remoteFile.Time(),
-		Size:     remoteFile.Size(),
-		Checksum: remoteFile.Checksum(),
+		Path:      remoteFile.Path(),
+		Time:      remoteFile.Time(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
 	})
 }
 
+func (a *updateAction) DivergedFromAncestor(localFile, remoteFile *FileInfo) {
+	a.ConflictHash([]*FileInfo{localFile}, []*FileInfo{remoteFile})
+}
+
+func (a *updateAction) ConflictCase(localFiles, remoteFiles []*FileInfo) {
+	panic("case conflict should never happen for updateAction, as paths are matched exactly")
+}
+
 func (a *updateAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
 	if len(localFiles) == 1 {
 		for _, remoteFile := range remoteFiles {
-			fmt.Printf("+%s\n", remoteFile.Path())
-			a.repo.AddFile(remoteFile)
+			// the local file is still at its path, it only changed back to
+			// a past version; adding it again would track the path twice
+			if remoteFile.Path() == localFiles[0].Path() {
+				a.ConflictPath(localFiles[0], remoteFile)
+				continue
+			}
+			a.logger.Infof("+%s", remoteFile.Path())
+			a.local.AddFile(remoteFile)
+		}
+	}
+
+	for _, file := range localFiles {
+		a.logger.Infof("!%s", file.Path())
+	}
+	for _, file := range remoteFiles {
+		a.logger.Infof("!%s", file.Path())
+	}
+}
+
+// previewAction reports events that updateAction would record, but does not
+// change anything.
+type previewAction struct {
+	repo   Boffin
+	note   string
+	logger Logger
+}
+
+func (a *previewAction) print(kind string, event *FileEvent) {
+	if event.Note != "" {
+		a.logger.Infof("%-8s %s size=%d checksum=%s note=%q", kind, event.Path, event.Size, event.Checksum, event.Note)
+	} else {
+		a.logger.Infof("%-8s %s size=%d checksum=%s", kind, event.Path, event.Size, event.Checksum)
+	}
+}
+
+func (a *previewAction) Unchanged(localFile, remoteFile *FileInfo) {
+}
+
+func (a *previewAction) MetaDataChanged(localFile, remoteFile *FileInfo) {
+	for _, event := range remoteFile.History {
+		a.print("metadata", event)
+	}
+}
+
+func (a *previewAction) Moved(localFile, remoteFile *FileInfo) {
+	for _, event := range remoteFile.History {
+		a.print("moved", event)
+	}
+}
+
+func (a *previewAction) LocalOnly(localFile *FileInfo) {
+	if a.repo.IsAppendOnly() {
+		a.logger.Warnf("repository is append-only, not marking as deleted: -%s", localFile.Path())
+		return
+	}
+	a.print("deleted", &FileEvent{
+		Path: localFile.Path(),
+		Note: a.note,
+	})
+}
+
+func (a *previewAction) LocalOld(localFile *FileInfo) {
+}
+
+func (a *previewAction) RemoteOnly(remoteFile *FileInfo) {
+	for _, event := range remoteFile.History {
+		a.print("added", event)
+	}
+}
+
+func (a *previewAction) RemoteOld(remoteFile *FileInfo) {
+}
+
+func (a *previewAction) LocalDeleted(localFile, remoteFile *FileInfo) {
+	a.ReAdded(localFile, remoteFile)
+}
+
+func (a *previewAction) RemoteDeleted(localFile, remoteFile *FileInfo) {
+	panic("remote deleted should never happen for previewAction")
+}
+
+func (a *previewAction) BothDeleted(localFile, remoteFile *FileInfo) {
+}
+
+func (a *previewAction) ReAdded(localFile, remoteFile *FileInfo) {
+	a.print("re-added", &FileEvent{
+		Path:      remoteFile.Path(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
+	})
+}
+
+func (a *previewAction) LocalChanged(localFile, remoteFile *FileInfo, moved bool) {
+	a.logger.Warnf("local should not change during update: ~%s => %s", localFile.Path(), remoteFile.Path())
+}
+
+func (a *previewAction) RemoteChanged(localFile, remoteFile *FileInfo, moved bool) {
+	a.print("changed", &FileEvent{
+		Path:      remoteFile.Path(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
+	})
+}
+
+func (a *previewAction) ConflictPath(localFile, remoteFile *FileInfo) {
+	a.print("changed", &FileEvent{
+		Path:      remoteFile.Path(),
+		Size:      remoteFile.Size(),
+		Checksum:  remoteFile.Checksum(),
+		Algorithm: remoteFile.History[len(remoteFile.History)-1].Algorithm,
+		Mode:      remoteFile.Mode(),
+		Note:      a.note,
+	})
+}
+
+func (a *previewAction) DivergedFromAncestor(localFile, remoteFile *FileInfo) {
+	a.ConflictHash([]*FileInfo{localFile}, []*FileInfo{remoteFile})
+}
+
+func (a *previewAction) ConflictCase(localFiles, remoteFiles []*FileInfo) {
+	panic("case conflict should never happen for previewAction, as paths are matched exactly")
+}
+
+func (a *previewAction) ConflictHash(localFiles, remoteFiles []*FileInfo) {
+	if len(localFiles) == 1 {
+		for _, remoteFile := range remoteFiles {
+			for _, event := range remoteFile.History {
+				a.print("added", event)
+			}
 		}
 	}
 
 	for _, file := range localFiles {
-		fmt.Printf("!%s\n", file.Path())
+		a.logger.Infof("!%s", file.Path())
 	}
 	for _, file := range remoteFiles {
-		fmt.Printf("!%s\n", file.Path())
+		a.logger.Infof("!%s", file.Path())
 	}
 }
@@ -1,8 +1,15 @@
 package lib
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +20,15 @@ import (
 func TestUpdate(t *testing.T) {
 	dir := filepath.Join(getTestDir(), "update2", ".boffin")
 
+	// git does not preserve mtimes; make sure the swapped file does not keep
+	// the size and time recorded in the repository, or its stale checksum would
+	// be reused and the swap would go unnoticed
+	swapped := filepath.Join(getTestDir(), "update2", "sub1", "cross-rename-1.ext")
+	swapTime := parseTime("2020-02-25T04:19:14.250535938Z")
+	if err := os.Chtimes(swapped, swapTime, swapTime); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
 	boffin, err := LoadBoffin(dir)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -341,8 +357,1387 @@ func TestUpdate(t *testing.T) {
 	opt1 := cmpopts.EquateApproxTime(margin)
 	opt2 := cmpopts.IgnoreUnexported(FileInfo{})
 	// opt3 := cmpopts.IgnoreFields(FileEvent{}, "Time")
+	// mode of the test files depends on the umask used when checking them out
+	opt4 := cmpopts.IgnoreFields(FileEvent{}, "Mode")
 
-	if diff := cmp.Diff(expected, actual, opt1, opt2); diff != "" {
+	if diff := cmp.Diff(expected, actual, opt1, opt2, opt4); diff != "" {
 		t.Errorf("file.History:\n%s", diff)
 	}
 }
+
+func writeTestFile(t testing.TB, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestUpdateAppendOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "keep.ext"), "keep")
+	writeTestFile(t, filepath.Join(dir, "missing.ext"), "missing")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.SetAppendOnly(true)
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err = LoadBoffin(ConstuctDbPath(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !boffin.IsAppendOnly() {
+		t.Errorf("IsAppendOnly: append-only flag was not persisted")
+	}
+
+	if err = os.Remove(filepath.Join(dir, "missing.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := boffin.GetFiles()
+	if len(files) != 2 {
+		t.Fatalf("GetFiles: 2 != %d", len(files))
+	}
+	for _, file := range files {
+		if file.IsDeleted() {
+			t.Errorf("%s: marked deleted in append-only repository", file.Path())
+		}
+		if len(file.History) != 1 {
+			t.Errorf("%s: expected 1 event but got %d", file.Path(), len(file.History))
+		}
+	}
+}
+
+// recordingLogger keeps info messages and warnings, ignoring the rest.
+type recordingLogger struct {
+	infos    []string
+	warnings []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {}
+
+func TestUpdateLogger(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "added.ext"), "added")
+	writeTestFile(t, filepath.Join(dir, "missing.ext"), "missing")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := &recordingLogger{}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Logger: logger}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"+added.ext", "+missing.ext"}, logger.infos); diff != "" {
+		t.Errorf("Infof:\n%s", diff)
+	}
+
+	boffin.SetAppendOnly(true)
+	if err = os.Remove(filepath.Join(dir, "missing.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger = &recordingLogger{}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Logger: logger}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logger.infos) != 0 {
+		t.Errorf("Infof: unexpected messages: %v", logger.infos)
+	}
+	expected := []string{"repository is append-only, not marking as deleted: -missing.ext"}
+	if diff := cmp.Diff(expected, logger.warnings); diff != "" {
+		t.Errorf("Warnf:\n%s", diff)
+	}
+}
+
+func TestUpdateConcurrentReads(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 50; i++ {
+		writeTestFile(t, filepath.Join(dir, "sub", fmt.Sprintf("file-%d.ext", i)), fmt.Sprintf("contents %d", i))
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, file := range boffin.GetFiles() {
+					_ = file.Path()
+					_ = file.IsDeleted()
+					_ = boffin.GetFileByPath(file.Path())
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("changed-%d.ext", i)), "changed")
+		if i > 0 {
+			if err := os.Remove(filepath.Join(dir, fmt.Sprintf("changed-%d.ext", i-1))); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if err := Update(boffin, ForceCheck); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if len(boffin.GetFiles()) != 51 {
+		t.Errorf("GetFiles: 51 != %d", len(boffin.GetFiles()))
+	}
+}
+
+func TestUpdateNote(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "existing.ext"), "existing")
+	writeTestFile(t, filepath.Join(dir, "deleted.ext"), "deleted")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "added.ext"), "added")
+	if err = os.Remove(filepath.Join(dir, "deleted.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Note: "from sd card"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{
+		"added.ext":    {"from sd card"},
+		"deleted.ext":  {"", "from sd card"},
+		"existing.ext": {""},
+	}
+	actual := map[string][]string{}
+	for _, file := range boffin.GetFiles() {
+		for _, event := range file.History {
+			actual[file.Path()] = append(actual[file.Path()], event.Note)
+		}
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("notes:\n%s", diff)
+	}
+}
+
+func TestUpdatePreview(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "changed.ext"), "original")
+	writeTestFile(t, filepath.Join(dir, "deleted.ext"), "deleted")
+	writeTestFile(t, filepath.Join(dir, "moved.ext"), "moved")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "added.ext"), "added")
+	writeTestFile(t, filepath.Join(dir, "changed.ext"), "changed")
+	if err = os.Remove(filepath.Join(dir, "deleted.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Rename(filepath.Join(dir, "moved.ext"), filepath.Join(dir, "sub", "moved.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	before := []*FileInfo{}
+	for _, file := range boffin.GetFiles() {
+		before = append(before, file.clone())
+	}
+
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Preview: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(before, boffin.GetFiles()); diff != "" {
+		t.Errorf("preview changed the repo:\n%s", diff)
+	}
+}
+
+func TestUpdateAfterImport(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "existing.ext"), "existing")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// simulate import copying a file and recording it the same way import does
+	importedPath := filepath.Join(dir, "import", "imported.ext")
+	writeTestFile(t, importedPath, "imported")
+	modTime := parseTime("2020-01-01T12:34:56Z")
+	if err = os.Chtimes(importedPath, modTime, modTime); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checksum, err := CalculateChecksum(importedPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.AddFile(&FileInfo{
+		History: []*FileEvent{
+			&FileEvent{Path: "remote/imported.ext", Size: 8, Time: modTime, Checksum: checksum},
+			&FileEvent{Path: "import/imported.ext", Size: 8, Time: modTime, Checksum: checksum},
+		},
+	})
+
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	afterFirst := []*FileInfo{}
+	for _, file := range boffin.GetFiles() {
+		afterFirst = append(afterFirst, file.clone())
+	}
+	if len(afterFirst) != 2 {
+		t.Fatalf("GetFiles: 2 != %d", len(afterFirst))
+	}
+	for _, file := range afterFirst {
+		if file.Path() == "import/imported.ext" && len(file.History) != 2 {
+			t.Errorf("%s: imported file was recorded again: %d events", file.Path(), len(file.History))
+		}
+	}
+
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(afterFirst, boffin.GetFiles()); diff != "" {
+		t.Errorf("second update changed the repo:\n%s", diff)
+	}
+}
+
+func TestUpdateSkipImportDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "existing.ext"), "existing")
+	writeTestFile(t, filepath.Join(dir, "import", "tracked.ext"), "tracked")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.(*db).absImportDir = filepath.Join(dir, "import")
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := func() []string {
+		paths := []string{}
+		for _, file := range boffin.GetFiles() {
+			if !file.IsDeleted() {
+				paths = append(paths, file.Path())
+			}
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	opts := &UpdateOptions{SkipImportDir: true}
+
+	// new files in the import dir are not added, tracked ones are kept
+	writeTestFile(t, filepath.Join(dir, "import", "staged.ext"), "staged")
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"existing.ext", filepath.Join("import", "tracked.ext")}
+	if diff := cmp.Diff(expected, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	// moving files out of the import dir adds or moves them
+	for _, name := range []string{"staged.ext", "tracked.ext"} {
+		if err = os.Rename(filepath.Join(dir, "import", name), filepath.Join(dir, name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []string{"existing.ext", "staged.ext", "tracked.ext"}
+	if diff := cmp.Diff(expected, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+	if file := boffin.GetFileByPath("tracked.ext"); file == nil || len(file.History) != 2 {
+		t.Errorf("tracked.ext: expected to be recorded as moved")
+	}
+	if len(boffin.GetFiles()) != 3 {
+		t.Errorf("GetFiles: 3 != %d", len(boffin.GetFiles()))
+	}
+}
+
+func TestUpdateHashAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "legacy.ext"), "legacy")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "new.ext"), "new")
+	opts := &UpdateOptions{
+		HashAlgorithm: HashMD5,
+	}
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err = LoadBoffin(boffin.GetDbDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]HashAlgorithm{
+		"legacy.ext": "",
+		"new.ext":    HashMD5,
+	}
+	for _, file := range boffin.GetFiles() {
+		event := file.History[len(file.History)-1]
+		if event.Algorithm != expected[file.Path()] {
+			t.Errorf("%s: algorithm '%s' != '%s'", file.Path(), expected[file.Path()], event.Algorithm)
+		}
+	}
+
+	report := Verify(boffin, nil)
+	if report.OK != 2 {
+		t.Errorf("Verify: 2 != %d OK files", report.OK)
+	}
+
+	// rehashing existing files must use the algorithm they were recorded with
+	opts.HashAlgorithm = HashSHA512
+	opts.Filter = ForceCheck
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range boffin.GetFiles() {
+		if len(file.History) != 1 {
+			t.Errorf("%s: unexpected change recorded: %d events", file.Path(), len(file.History))
+		}
+	}
+
+	opts.HashAlgorithm = "unknown"
+	if err = UpdateWithOptions(boffin, opts); err == nil {
+		t.Errorf("expected error for unknown hash algorithm")
+	}
+}
+
+func TestRegisterHashAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "file")
+
+	const sha224 HashAlgorithm = "sha224"
+	RegisterHashAlgorithm(sha224, sha256.New224)
+	defer delete(hashAlgorithms, sha224)
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{HashAlgorithm: sha224}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file := boffin.GetFileByPath("file.ext")
+	if file == nil || file.HashAlgorithm() != sha224 {
+		t.Fatalf("file.ext: expected to be hashed with %s", sha224)
+	}
+	if report := Verify(boffin, nil); report.OK != 1 {
+		t.Errorf("Verify: 1 != %d OK files", report.OK)
+	}
+}
+
+func TestUpdateWorkers(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 50; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("sub%d", i%5), fmt.Sprintf("file%d.ext", i)), fmt.Sprintf("contents %d", i))
+	}
+	writeTestFile(t, filepath.Join(dir, ".hidden", "file.ext"), "hidden")
+
+	single, err := InitDbDir(filepath.Join(t.TempDir(), DefaultDbDirName), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(single, &UpdateOptions{Workers: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parallel, err := InitDbDir(filepath.Join(t.TempDir(), DefaultDbDirName), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(parallel, &UpdateOptions{Workers: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(parallel.GetFiles()) != 50 {
+		t.Errorf("GetFiles: 50 != %d", len(parallel.GetFiles()))
+	}
+	// order in memory is not defined, only once saved
+	byPath := cmpopts.SortSlices(func(a, b *FileInfo) bool { return a.Path() < b.Path() })
+	if diff := cmp.Diff(single.GetFiles(), parallel.GetFiles(), byPath); diff != "" {
+		t.Errorf("parallel update differs:\n%s", diff)
+	}
+
+	// a file that cannot be read must fail the whole update
+	if err = os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "sub0", "broken.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := parallel.GetFiles()
+	if err = UpdateWithOptions(parallel, &UpdateOptions{Workers: 8}); err == nil {
+		t.Errorf("expected error for unreadable file")
+	}
+	if diff := cmp.Diff(before, parallel.GetFiles(), byPath); diff != "" {
+		t.Errorf("failed update changed the repo:\n%s", diff)
+	}
+}
+
+func TestUpdateProgress(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.ext"), "a")
+	writeTestFile(t, filepath.Join(dir, "b.ext"), "bb")
+	writeTestFile(t, filepath.Join(dir, "sub", "c.ext"), "ccc")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := []UpdateProgress{}
+	opts := &UpdateOptions{
+		Workers: 2,
+		Progress: func(progress UpdateProgress) {
+			calls = append(calls, progress)
+		},
+	}
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := UpdateProgress{Scanned: 3, Hashed: 3, Bytes: 6, Done: true}
+	if len(calls) != 7 {
+		t.Errorf("Progress: 7 != %d calls", len(calls))
+	} else if diff := cmp.Diff(expected, calls[len(calls)-1]); diff != "" {
+		t.Errorf("Progress:\n%s", diff)
+	}
+
+	// unchanged files are scanned, but not hashed again
+	calls = nil
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = UpdateProgress{Scanned: 3, Done: true}
+	if len(calls) != 4 {
+		t.Errorf("Progress: 4 != %d calls", len(calls))
+	} else if diff := cmp.Diff(expected, calls[len(calls)-1]); diff != "" {
+		t.Errorf("Progress:\n%s", diff)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "moved.ext"), "moved")
+	writeTestFile(t, filepath.Join(dir, "changed.ext"), "changed")
+	writeTestFile(t, filepath.Join(dir, "deleted.ext"), "deleted")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "new.ext"), "new")
+	writeTestFile(t, filepath.Join(dir, "changed.ext"), "changed more")
+	if err = os.Rename(filepath.Join(dir, "moved.ext"), filepath.Join(dir, "moved-after.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Remove(filepath.Join(dir, "deleted.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	before := []*FileInfo{}
+	for _, file := range boffin.GetFiles() {
+		before = append(before, file.clone())
+	}
+
+	report, err := Status(boffin, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.RemoteOnly) != 1 || report.RemoteOnly[0].Path() != "new.ext" {
+		t.Errorf("RemoteOnly: unexpected %v", report.RemoteOnly)
+	}
+	if len(report.RemoteChanged)+len(report.ConflictPath) != 1 {
+		t.Errorf("changed: 1 != %d", len(report.RemoteChanged)+len(report.ConflictPath))
+	}
+	if len(report.Moved) != 1 || report.Moved[0].Remote.Path() != "moved-after.ext" {
+		t.Errorf("Moved: unexpected %v", report.Moved)
+	}
+	if len(report.LocalOnly) != 1 || report.LocalOnly[0].Path() != "deleted.ext" {
+		t.Errorf("LocalOnly: unexpected %v", report.LocalOnly)
+	}
+
+	if diff := cmp.Diff(before, boffin.GetFiles()); diff != "" {
+		t.Errorf("Status changed the repo:\n%s", diff)
+	}
+}
+
+func TestUpdateFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base")
+	incoming := filepath.Join(dir, "incoming")
+	writeTestFile(t, filepath.Join(base, "file.ext"), "file")
+	writeTestFile(t, filepath.Join(incoming, "new.ext"), "new contents")
+	for link, target := range map[string]string{
+		filepath.Join(base, "incoming"):     incoming,
+		filepath.Join(base, "link.ext"):     filepath.Join(base, "file.ext"),
+		filepath.Join(base, "broken.ext"):   filepath.Join(dir, "missing"),
+		filepath.Join(base, "self"):         base,
+		filepath.Join(incoming, "loop"):     incoming,
+		filepath.Join(incoming, "base-dir"): base,
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(base), base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{FollowSymlinks: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"file.ext":         testChecksum("file"),
+		"incoming/new.ext": testChecksum("new contents"),
+		"link.ext":         testChecksum("file"),
+	}
+	actual := map[string]string{}
+	for _, file := range boffin.GetFiles() {
+		actual[file.Path()] = file.Checksum()
+		if file.Path() == "link.ext" && file.Size() != int64(len("file")) {
+			t.Errorf("symlinked file recorded with size %d instead of its target's", file.Size())
+		}
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
+
+func TestUpdateMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err = LoadBoffin(boffin.GetDbDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mode := boffin.GetFileByPath("file.ext").Mode(); mode != 0640 {
+		t.Errorf("Mode: %v != %v", os.FileMode(0640), mode)
+	}
+}
+
+func TestUpdateMetaDataChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// touch the file without changing its contents
+	touched := parseTime("2021-01-01T12:34:56Z")
+	if err = os.Chtimes(path, touched, touched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file := boffin.GetFileByPath("file.ext")
+	if len(file.History) != 1 {
+		t.Errorf("History: expected 1 event, got %d", len(file.History))
+	}
+	if !file.Time().Equal(touched) {
+		t.Errorf("Time: %v != %v", touched, file.Time())
+	}
+}
+
+func TestUpdateCheckContents(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Filter: CheckIfMetaChanged}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original := boffin.GetFileByPath("file.ext").Checksum()
+
+	// change contents, but keep size and modification time
+	writeTestFile(t, path, "CONTENTS")
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Filter: CheckIfMetaChanged}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checksum := boffin.GetFileByPath("file.ext").Checksum(); checksum != original {
+		t.Errorf("CheckIfMetaChanged: file with unchanged metadata was hashed")
+	}
+
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Filter: ForceCheck}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checksum := boffin.GetFileByPath("file.ext").Checksum(); checksum == original {
+		t.Errorf("ForceCheck: changed contents were not detected")
+	}
+}
+
+func TestUpdateMinSize(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "large.ext"), "large contents")
+	writeTestFile(t, filepath.Join(dir, "small.xmp"), "xmp")
+	writeTestFile(t, filepath.Join(dir, "tracked.txt"), "tracked contents")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	boffin.AddFile(&FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "tracked.txt", Size: 16, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: testChecksum("tracked contents")},
+	}})
+	// tracked file becomes small
+	writeTestFile(t, filepath.Join(dir, "tracked.txt"), "txt")
+
+	if err = UpdateWithOptions(boffin, &UpdateOptions{MinSize: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := []string{}
+	for _, file := range boffin.GetFiles() {
+		if !file.IsDeleted() {
+			paths = append(paths, file.Path())
+		}
+	}
+	sort.Strings(paths)
+	if diff := cmp.Diff([]string{"large.ext", "tracked.txt"}, paths); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+	if tracked := boffin.GetFileByPath("tracked.txt"); len(tracked.History) != 1 || tracked.Size() != 16 {
+		t.Errorf("tracked.txt: expected to be kept as it was, got %v", tracked.History)
+	}
+}
+
+func TestUpdateMetadataOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, trackFilename), "*.img\nvms/\n")
+	writeTestFile(t, filepath.Join(dir, "disk.img"), "contents")
+	writeTestFile(t, filepath.Join(dir, "vms", "vm.qcow2"), "vm contents")
+	writeTestFile(t, filepath.Join(dir, "photo.jpg"), "contents")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"disk.img", "vms/vm.qcow2"} {
+		file := boffin.GetFileByPath(path)
+		if file == nil {
+			t.Fatalf("%s: expected to be tracked", path)
+		}
+		if file.HashAlgorithm() != HashMetadataOnly {
+			t.Errorf("%s: expected algorithm %s, got %s", path, HashMetadataOnly, file.HashAlgorithm())
+		}
+		if expected := metadataChecksum(file.Size(), file.Time()); file.Checksum() != expected {
+			t.Errorf("%s: expected checksum %s, got %s", path, expected, file.Checksum())
+		}
+		if !isValidChecksum(file.Checksum(), file.HashAlgorithm()) {
+			t.Errorf("%s: invalid checksum %s", path, file.Checksum())
+		}
+	}
+	// same contents, but only one of them is hashed
+	photo, disk := boffin.GetFileByPath("photo.jpg"), boffin.GetFileByPath("disk.img")
+	if photo.HashAlgorithm() != DefaultHashAlgorithm || photo.Checksum() != testChecksum("contents") {
+		t.Errorf("photo.jpg: expected to be hashed, got %v", photo.History)
+	}
+	if photo.checksumKey() == disk.checksumKey() {
+		t.Errorf("metadata-only file matches hashed file")
+	}
+
+	// size and time do not identify the contents, so a file with the same
+	// metadata at another path is not recorded as a move, even if renamed
+	if err = os.Rename(filepath.Join(dir, "disk.img"), filepath.Join(dir, "renamed.img")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if disk = boffin.GetFileByPath("disk.img"); disk == nil || !disk.IsDeleted() {
+		t.Errorf("disk.img: expected to be deleted, got %v", disk)
+	}
+	renamed := boffin.GetFileByPath("renamed.img")
+	if renamed == nil || len(renamed.History) != 1 {
+		t.Fatalf("renamed.img: expected to be added, got %v", renamed)
+	}
+
+	// files removed from the track file are hashed again
+	writeTestFile(t, filepath.Join(dir, trackFilename), "vms/\n")
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renamed = boffin.GetFileByPath("renamed.img")
+	if renamed.HashAlgorithm() != DefaultHashAlgorithm || renamed.Checksum() != testChecksum("contents") {
+		t.Errorf("renamed.img: expected to be hashed, got %v", renamed.History)
+	}
+}
+
+func TestSampleCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unchanged := &FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "file.ext", Size: info.Size(), Time: info.ModTime(), Checksum: "hash"},
+	}}
+	changed := &FileInfo{History: []*FileEvent{
+		&FileEvent{Path: "file.ext", Size: info.Size() + 1, Time: info.ModTime(), Checksum: "hash"},
+	}}
+
+	if SampleCheck(0, 1)(info, unchanged) {
+		t.Errorf("SampleCheck: unchanged file checked with zero fraction")
+	}
+	if !SampleCheck(0, 1)(info, changed) {
+		t.Errorf("SampleCheck: changed file not checked")
+	}
+	if !SampleCheck(0, 1)(info, nil) {
+		t.Errorf("SampleCheck: new file not checked")
+	}
+
+	sample := func(filter FilterFunc) []bool {
+		picked := make([]bool, 1000)
+		for i := range picked {
+			picked[i] = filter(info, unchanged)
+		}
+		return picked
+	}
+	first := sample(SampleCheck(0.1, 42))
+	if diff := cmp.Diff(first, sample(SampleCheck(0.1, 42))); diff != "" {
+		t.Errorf("SampleCheck: same seed picked different files:\n%s", diff)
+	}
+	count := 0
+	for _, picked := range first {
+		if picked {
+			count++
+		}
+	}
+	if count < 50 || count > 150 {
+		t.Errorf("SampleCheck: picked %d of 1000 files, expected about 100", count)
+	}
+}
+
+func TestUpdateSampleCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+	writeTestFile(t, filepath.Join(dir, "other.ext"), "other")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original := boffin.GetFileByPath("file.ext").Checksum()
+
+	// flip bytes, but keep size and modification time
+	writeTestFile(t, path, "cOntEnts")
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "new.ext"), "new")
+
+	err = UpdateWithOptions(boffin, &UpdateOptions{Filter: SampleCheck(1, 1), DetectCorruption: true})
+	var corrupted *CorruptedError
+	if !errors.As(err, &corrupted) {
+		t.Fatalf("expected CorruptedError, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"file.ext"}, corrupted.Paths); diff != "" {
+		t.Errorf("CorruptedError.Paths:\n%s", diff)
+	}
+	file := boffin.GetFileByPath("file.ext")
+	if len(file.History) != 1 || file.Checksum() != original {
+		t.Errorf("file.ext: expected history to be kept, got %v", file.History)
+	}
+	// other changes are still recorded
+	if boffin.GetFileByPath("new.ext") == nil {
+		t.Errorf("new.ext: expected to be added")
+	}
+}
+
+func TestUpdateUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "unreadable.ext"), "unreadable")
+	writeTestFile(t, filepath.Join(dir, "sub", "file.ext"), "file")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "new.ext"), "new")
+	writeTestFile(t, filepath.Join(dir, "new-unreadable.ext"), "new unreadable")
+
+	defer func() {
+		calculateChecksum = CalculateChecksumWith
+	}()
+	calculateChecksum = func(path string, algorithm HashAlgorithm) (string, error) {
+		if strings.Contains(filepath.Base(path), "unreadable") {
+			return "", &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+		}
+		return CalculateChecksumWith(path, algorithm)
+	}
+	// directories can not be made unreadable for root
+	unreadableDir := os.Getuid() != 0
+	if unreadableDir {
+		if err = os.Chmod(filepath.Join(dir, "sub"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer os.Chmod(filepath.Join(dir, "sub"), 0755)
+	}
+
+	err = Update(boffin, ForceCheck)
+	var unreadable *UnreadableError
+	if !errors.As(err, &unreadable) {
+		t.Fatalf("Update: expected UnreadableError, got: %v", err)
+	}
+	expectedErrors := 2
+	if unreadableDir {
+		expectedErrors++
+	}
+	if len(unreadable.Errors) != expectedErrors {
+		t.Errorf("Update: expected %d errors, got: %v", expectedErrors, unreadable)
+	}
+
+	expected := []string{"new.ext", "sub/file.ext", "unreadable.ext"}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		if file.IsDeleted() {
+			t.Errorf("Update: '%s' marked as deleted", file.Path())
+		}
+		actual = append(actual, file.Path())
+	}
+	sort.Strings(actual)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
+
+func TestUpdateEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "empty.ext"), "")
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "file")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"empty.ext", "file.ext"}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	sort.Strings(actual)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+	if empty := boffin.GetFileByPath("empty.ext"); empty.Size() != 0 || empty.Checksum() != testChecksum("") {
+		t.Errorf("empty.ext: expected empty file with checksum of empty contents, got %v", empty.History)
+	}
+}
+
+func TestUpdateSkipsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "file")
+	writeTestFile(t, filepath.Join(dir, "new.ext.boffin-tmp"), "partial copy")
+	writeTestFile(t, filepath.Join(dir, "sub", "old.ext.boffin-old"), "replaced")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	if diff := cmp.Diff([]string{"file.ext"}, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "new.ext.boffin-tmp"),
+		filepath.Join(dir, "sub", "old.ext.boffin-old"),
+	}
+	if diff := cmp.Diff(expected, FindTempFiles(boffin)); diff != "" {
+		t.Errorf("FindTempFiles:\n%s", diff)
+	}
+}
+
+func TestUpdateReAdded(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "re-added.ext"), "re-added")
+	writeTestFile(t, filepath.Join(dir, "restored.ext"), "restored")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"re-added.ext", "restored.ext"} {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// one file is created again, the other one is restored with its old
+	// modification time
+	writeTestFile(t, filepath.Join(dir, "sub", "re-added.ext"), "re-added")
+	future := time.Now().Add(time.Hour)
+	if err = os.Chtimes(filepath.Join(dir, "sub", "re-added.ext"), future, future); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "restored.ext"), "restored")
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(filepath.Join(dir, "restored.ext"), past, past); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := boffin.GetFiles()
+	if len(files) != 2 {
+		t.Fatalf("GetFiles: 2 != %d", len(files))
+	}
+	for _, file := range files {
+		if file.IsDeleted() {
+			t.Errorf("%s: still marked deleted", file.Path())
+		}
+		if len(file.History) != 3 {
+			t.Errorf("%s: expected 3 events but got %d", file.Path(), len(file.History))
+		}
+	}
+}
+
+func TestUpdateCaseCollision(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "dup.txt"), "lower")
+	writeTestFile(t, filepath.Join(dir, "DUP.txt"), "upper")
+	if contents, err := os.ReadFile(filepath.Join(dir, "dup.txt")); err != nil || string(contents) != "lower" {
+		t.Skip("file system is case-insensitive")
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// paths are matched exactly during update, so both files are tracked
+	// separately and never reported as a case conflict
+	for i := 0; i < 2; i++ {
+		if err = Update(boffin, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for path, contents := range map[string]string{"dup.txt": "lower", "DUP.txt": "upper"} {
+		file := boffin.GetFileByPath(path)
+		if file == nil {
+			t.Fatalf("%s: expected to be tracked", path)
+		}
+		if file.Checksum() != testChecksum(contents) || len(file.History) != 1 {
+			t.Errorf("%s: unexpected history %v", path, file.History)
+		}
+	}
+}
+
+func TestUpdateContext(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.ext"), "a")
+	writeTestFile(t, filepath.Join(dir, "b.ext"), "b")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "c.ext"), "c")
+	writeTestFile(t, filepath.Join(dir, "d.ext"), "d")
+	writeTestFile(t, filepath.Join(dir, "e.ext"), "e")
+	if err = os.Remove(filepath.Join(dir, "b.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := func() []string {
+		var paths []string
+		for _, file := range boffin.GetFiles() {
+			if !file.IsDeleted() {
+				paths = append(paths, file.Path())
+			}
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// canceled update does not change the repo
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = UpdateContext(ctx, boffin, nil); err != context.Canceled {
+		t.Errorf("UpdateContext: expected context.Canceled, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"a.ext", "b.ext"}, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	// cancel after the first file is hashed and keep what was found
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	defer func() {
+		calculateChecksum = CalculateChecksumWith
+	}()
+	calculateChecksum = func(path string, algorithm HashAlgorithm) (string, error) {
+		cancel()
+		return CalculateChecksumWith(path, algorithm)
+	}
+	err = UpdateWithOptionsContext(ctx, boffin, &UpdateOptions{Workers: 1, KeepPartial: true})
+	if err != context.Canceled {
+		t.Errorf("UpdateWithOptionsContext: expected context.Canceled, got %v", err)
+	}
+	// canceled update can not tell if b.ext was deleted, so it is kept
+	if diff := cmp.Diff([]string{"a.ext", "b.ext", "c.ext"}, paths()); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+}
+
+func TestUpdateChecksumCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.ext")
+	writeTestFile(t, path, "contents")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hashed := 0
+	defer func() {
+		calculateChecksum = CalculateChecksumWith
+	}()
+	calculateChecksum = func(path string, algorithm HashAlgorithm) (string, error) {
+		hashed++
+		return CalculateChecksumWith(path, algorithm)
+	}
+
+	// time in the repo no longer matches, but the cache does
+	file := boffin.GetFileByPath("file.ext")
+	file.History[0].Time = file.Time().Add(-time.Second)
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hashed != 0 {
+		t.Errorf("Update: expected cached checksum to be used, file hashed %d times", hashed)
+	}
+
+	// forced check must not use the cache
+	if err = Update(boffin, ForceCheck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hashed != 1 {
+		t.Errorf("Update: expected forced check to hash the file, hashed %d times", hashed)
+	}
+
+	// changed file invalidates the cache
+	hashed = 0
+	writeTestFile(t, path, "changed contents")
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hashed != 1 {
+		t.Errorf("Update: expected changed file to be hashed, hashed %d times", hashed)
+	}
+	if file := boffin.GetFileByPath("file.ext"); file.Checksum() != testChecksum("changed contents") {
+		t.Errorf("Update: expected checksum of changed contents, got %s", file.Checksum())
+	}
+}
+
+func TestChecksumCacheReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "contents")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cache := filepath.Join(boffin.GetDbDir(), cacheFilename)
+
+	if _, err = Status(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("Status: expected no checksum cache, got %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Preview: true, Logger: NewLogger(nil)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("Preview: expected no checksum cache, got %v", err)
+	}
+
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = os.Stat(cache); err != nil {
+		t.Errorf("Update: expected checksum cache to be saved: %v", err)
+	}
+}
+
+func TestUpdatePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "photos", "2021", "a.jpg"), "a")
+	writeTestFile(t, filepath.Join(dir, "photos", "2022", "b.jpg"), "b")
+	writeTestFile(t, filepath.Join(dir, "docs", "c.txt"), "c")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// out of scope changes are not picked up, and missing files outside of
+	// the scope are not marked deleted
+	writeTestFile(t, filepath.Join(dir, "photos", "2021", "new.jpg"), "new")
+	writeTestFile(t, filepath.Join(dir, "photos", "2022", "new.jpg"), "new 2022")
+	if err = os.Remove(filepath.Join(dir, "docs", "c.txt")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Remove(filepath.Join(dir, "photos", "2021", "a.jpg")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = UpdateWithOptions(boffin, &UpdateOptions{Paths: []string{filepath.Join("photos", "2021")}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths := []string{}
+	for _, file := range boffin.GetFiles() {
+		if !file.IsDeleted() {
+			paths = append(paths, file.Path())
+		}
+	}
+	sort.Strings(paths)
+	expected := []string{
+		filepath.Join("docs", "c.txt"),
+		filepath.Join("photos", "2021", "new.jpg"),
+		filepath.Join("photos", "2022", "b.jpg"),
+	}
+	if diff := cmp.Diff(expected, paths); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+	if file := boffin.GetFileByPath(filepath.Join("photos", "2021", "a.jpg")); file == nil || !file.IsDeleted() {
+		t.Errorf("a.jpg: expected to be deleted, got %v", file)
+	}
+}
+
+func TestUpdateRevertedAndCopied(t *testing.T) {
+	dir := t.TempDir()
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	update := func() {
+		t.Helper()
+		if err := Update(boffin, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(dir, "p.txt"), "original")
+	update()
+	writeTestFile(t, filepath.Join(dir, "p.txt"), "changed contents")
+	update()
+	// reverting the file and copying it matches both to its past version
+	writeTestFile(t, filepath.Join(dir, "p.txt"), "original")
+	writeTestFile(t, filepath.Join(dir, "q.txt"), "original")
+	update()
+
+	live := map[string]int{}
+	for _, file := range boffin.GetFiles() {
+		if !file.IsDeleted() {
+			live[file.Path()]++
+		}
+	}
+	if diff := cmp.Diff(map[string]int{"p.txt": 1, "q.txt": 1}, live); diff != "" {
+		t.Errorf("expected each path tracked once:\n%s", diff)
+	}
+	p := boffin.GetFileByPath("p.txt")
+	if len(p.History) != 3 || p.Checksum() != p.History[0].Checksum {
+		t.Errorf("p.txt: expected to be recorded as changed back, got %v", p.History)
+	}
+
+	// the repo must keep working
+	update()
+	if err = boffin.DiffAgainst(boffin, &testAction{}); err != nil {
+		t.Errorf("DiffAgainst: unexpected error: %v", err)
+	}
+
+	// files with the same path, as recorded by older versions, are tolerated
+	boffin.AddFile(&FileInfo{History: []*FileEvent{p.History[len(p.History)-1]}})
+	if err = boffin.DiffAgainst(boffin, &testAction{}); err != nil {
+		t.Errorf("DiffAgainst: unexpected error for duplicate paths: %v", err)
+	}
+	update()
+}
+
+func TestUpdateConcurrentAddFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.ext"), "a")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// imported elsewhere while the update is scanning
+	added := &FileInfo{History: []*FileEvent{{Path: "imported.ext", Size: 10, Time: parseTime("2020-01-01T12:34:56Z"), Checksum: "hash-1"}}}
+	var once sync.Once
+	opts := &UpdateOptions{
+		Progress: func(UpdateProgress) {
+			once.Do(func() { boffin.AddFile(added) })
+		},
+	}
+	if err = UpdateWithOptions(boffin, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if boffin.GetFileByPath("a.ext") == nil {
+		t.Errorf("a.ext: not recorded by update")
+	}
+	if boffin.GetFileByPath("imported.ext") != added {
+		t.Errorf("imported.ext: file added during update was lost")
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.ext"), "file")
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0644); err != nil {
+		t.Skipf("can not create named pipe: %v", err)
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// hashing the pipe would block, so the update would never finish
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"file.ext"}
+	actual := []string{}
+	for _, file := range boffin.GetFiles() {
+		actual = append(actual, file.Path())
+	}
+	sort.Strings(actual)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("GetFiles:\n%s", diff)
+	}
+
+	// tracked file replaced by a special file is kept as it was
+	if err = os.Remove(filepath.Join(dir, "file.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = syscall.Mkfifo(filepath.Join(dir, "file.ext"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, ForceCheck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file := boffin.GetFileByPath("file.ext"); file.IsDeleted() || len(file.History) != 1 {
+		t.Errorf("file.ext: expected to be kept as it was, got %v", file.History)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// VerifyStatus is the outcome of verifying a single file.
+type VerifyStatus int
+
+const (
+	// VerifyOK means that the file content matches the recorded checksum.
+	VerifyOK VerifyStatus = iota
+	// VerifyMismatch means that the file content does not match the recorded
+	// checksum.
+	VerifyMismatch
+	// VerifyError means that the file could not be read.
+	VerifyError
+	// VerifyInFlux means that the file was modified while being verified, so
+	// the result is not reliable and the file was skipped.
+	VerifyInFlux
+	// VerifyMoved means that the file is missing, but other tracked files
+	// with the same checksum exist, so the file was possibly moved.
+	VerifyMoved
+)
+
+// calculateChecksum is used by Verify and Update; replaced in tests to simulate
+// files changing while being read, or not being readable
+var calculateChecksum = CalculateChecksumWith
+
+// VerifyResult is the result of verifying a single file.
+type VerifyResult struct {
+	File   *FileInfo
+	Status VerifyStatus
+	Err    error
+	// MovedTo lists paths of existing tracked files with the same checksum,
+	// if the file is missing.
+	MovedTo []string
+	// MetadataFixed is set if the content matched, but the modification
+	// time did not, and the latest event was updated with the current
+	// metadata.
+	MetadataFixed bool
+
+	fixed    *FileEvent // replaces the latest event once all files are verified
+	verified time.Time
+}
+
+// VerifyReport holds results of verifying all files in a repository.
+type VerifyReport struct {
+	Results    []*VerifyResult
+	OK         int
+	Mismatched int
+	Errors     int
+	InFlux     int
+	Moved      int
+	// MetadataFixed counts OK files whose metadata was updated.
+	MetadataFixed int
+	// UnmatchedPatterns lists patterns that did not match any file.
+	UnmatchedPatterns []string
+}
+
+func (r *VerifyReport) add(result *VerifyResult) {
+	r.Results = append(r.Results, result)
+	switch result.Status {
+	case VerifyOK:
+		r.OK++
+		if result.MetadataFixed {
+			r.MetadataFixed++
+		}
+	case VerifyMismatch:
+		r.Mismatched++
+	case VerifyError:
+		r.Errors++
+	case VerifyInFlux:
+		r.InFlux++
+	case VerifyMoved:
+		r.Moved++
+	}
+}
+
+// ExitCode returns 2 if any file could not be read, 1 if any file did not
+// match its checksum or was possibly moved, 3 if any of the patterns did not
+// match any file, or 0 if all files are OK. Files in flux are ignored.
+func (r *VerifyReport) ExitCode() int {
+	if r.Errors > 0 {
+		return 2
+	}
+	if r.Mismatched > 0 || r.Moved > 0 {
+		return 1
+	}
+	if len(r.UnmatchedPatterns) > 0 {
+		return 3
+	}
+	return 0
+}
+
+// VerifyOptions control how Verify checks the files.
+type VerifyOptions struct {
+	// Workers is the number of files verified in parallel. Defaults to the
+	// number of CPUs.
+	Workers int
+	// Patterns limit verification to files whose path matches any of the
+	// glob patterns, where ** matches any number of directories. All files
+	// are verified if there are no patterns.
+	Patterns []string
+	// FixMetadata updates the latest event of files whose content matches,
+	// but modification time does not, with their current modification time,
+	// size and mode, the same way update does. The checksum stays the same.
+	// The repository is modified, but not saved.
+	FixMetadata bool
+	// RecordVerified sets LastVerified of files whose content matches. The
+	// repository is modified, but not saved.
+	RecordVerified bool
+	// StaleBefore, if set, limits verification to files that were never
+	// verified, or were last verified before this time.
+	StaleBefore time.Time
+	// Progress, if set, is called every time a file is verified, and once
+	// more when all files are done.
+	Progress func(progress VerifyProgress)
+}
+
+// VerifyProgress holds counts of work done so far by Verify. Bytes are
+// counted from the recorded file sizes.
+type VerifyProgress struct {
+	// Checked is the number of files verified so far, out of Total.
+	Checked int
+	Total   int
+	// Bytes is the total size of the verified files, out of TotalBytes.
+	Bytes      int64
+	TotalBytes int64
+	// Current is the path of the file verified last.
+	Current string
+	// Done is set on the last call, once all files are verified.
+	Done bool
+}
+
+// Verify calculates checksums of all files in the repository and compares them
+// with the recorded ones. Deleted files are skipped. Files whose size or
+// modification time changed while they were read are reported as in flux. If
+// not nil, the callback is called with the result for each file as soon as it
+// is verified. Missing files whose content exists at other tracked paths are
+// reported as possibly moved.
+func Verify(repo Boffin, callback func(result *VerifyResult)) *VerifyReport {
+	return VerifyWithOptions(repo, nil, callback)
+}
+
+// VerifyWithOptions is the same as Verify, but allows more control over the
+// verification. Files are verified in parallel, so the callback is called in
+// no particular order, but never concurrently. Results in the report are in
+// the same order as the files in the repository.
+func VerifyWithOptions(repo Boffin, opts *VerifyOptions, callback func(result *VerifyResult)) *VerifyReport {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	patterns := make([]*regexp.Regexp, len(opts.Patterns))
+	for i, pattern := range opts.Patterns {
+		// invalid pattern is left nil and reported as unmatched
+		patterns[i], _ = compileGlob(pattern)
+	}
+	matched := make([]bool, len(patterns))
+
+	results := []*VerifyResult{}
+	for _, file := range repo.GetFiles() {
+		if file.IsDeleted() {
+			continue
+		}
+		if len(patterns) > 0 {
+			match := false
+			for i, pattern := range patterns {
+				if pattern != nil && pattern.MatchString(filepath.ToSlash(file.Path())) {
+					matched[i] = true
+					match = true
+				}
+			}
+			if !match {
+				continue
+			}
+		}
+		if !opts.StaleBefore.IsZero() && file.LastVerified != nil && !file.LastVerified.Before(opts.StaleBefore) {
+			continue
+		}
+		results = append(results, &VerifyResult{
+			File:   file,
+			Status: VerifyOK,
+		})
+	}
+
+	byHash := FilesToHashMap(repo.GetFiles())
+
+	progress := VerifyProgress{Total: len(results)}
+	for _, result := range results {
+		progress.TotalBytes += result.File.Size()
+	}
+
+	pending := make(chan *VerifyResult)
+	var callbackMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range pending {
+				path := repo.ResolvePath(result.File.Path())
+				var info os.FileInfo
+				result.Status, info, result.Err = verifyFile(path, result.File.Checksum(), result.File.HashAlgorithm())
+				result.verified = time.Now().UTC()
+				if result.Status == VerifyOK && opts.FixMetadata && !info.ModTime().Equal(result.File.Time()) {
+					// the event is copied as it may be shared with other files
+					fixed := *result.File.History[len(result.File.History)-1]
+					fixed.Time = info.ModTime()
+					fixed.Size = info.Size()
+					fixed.Mode = info.Mode().Perm()
+					result.fixed = &fixed
+					result.MetadataFixed = true
+				}
+				if result.Status == VerifyError && os.IsNotExist(result.Err) {
+					result.MovedTo = findMoved(repo, result.File, byHash)
+					if len(result.MovedTo) > 0 {
+						result.Status = VerifyMoved
+					}
+				}
+
+				callbackMu.Lock()
+				if callback != nil {
+					callback(result)
+				}
+				if opts.Progress != nil {
+					progress.Checked++
+					progress.Bytes += result.File.Size()
+					progress.Current = result.File.Path()
+					opts.Progress(progress)
+				}
+				callbackMu.Unlock()
+			}
+		}()
+	}
+	for _, result := range results {
+		pending <- result
+	}
+	close(pending)
+	wg.Wait()
+	if opts.Progress != nil {
+		progress.Done = true
+		opts.Progress(progress)
+	}
+
+	report := &VerifyReport{}
+	for _, result := range results {
+		// other workers may have been reading the files until now
+		if result.fixed != nil {
+			result.File.History[len(result.File.History)-1] = result.fixed
+		}
+		if result.Status == VerifyOK && opts.RecordVerified {
+			verified := result.verified
+			result.File.LastVerified = &verified
+		}
+		report.add(result)
+	}
+	for i, pattern := range opts.Patterns {
+		if !matched[i] {
+			report.UnmatchedPatterns = append(report.UnmatchedPatterns, pattern)
+		}
+	}
+	return report
+}
+
+// findMoved returns paths of other tracked files with the same checksum as the
+// file, which exist in the base directory.
+func findMoved(repo Boffin, file *FileInfo, byHash map[string][]*FileInfo) []string {
+	paths := []string{}
+	for _, other := range byHash[file.checksumKey()] {
+		if other == file {
+			continue
+		}
+		if _, err := os.Stat(repo.ResolvePath(other.Path())); err == nil {
+			paths = append(paths, other.Path())
+		}
+	}
+	return paths
+}
+
+// verifyFile returns the status of the file, together with its info if it
+// could be read.
+func verifyFile(path, expected string, algorithm HashAlgorithm) (VerifyStatus, os.FileInfo, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return VerifyError, nil, err
+	}
+
+	checksum, err := calculateChecksum(path, algorithm)
+	if err != nil {
+		return VerifyError, nil, err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return VerifyError, nil, err
+	}
+	if before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+		return VerifyInFlux, after, nil
+	}
+
+	if checksum != expected {
+		return VerifyMismatch, after, nil
+	}
+	return VerifyOK, after, nil
+}
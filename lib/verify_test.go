@@ -0,0 +1,400 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "ok.ext"), "ok")
+	writeTestFile(t, filepath.Join(dir, "corrupt.ext"), "corrupt")
+	writeTestFile(t, filepath.Join(dir, "missing.ext"), "missing")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "corrupt.ext"), "corrupted")
+	if err = os.Remove(filepath.Join(dir, "missing.ext")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statuses := map[string]VerifyStatus{}
+	report := Verify(boffin, func(result *VerifyResult) {
+		statuses[result.File.Path()] = result.Status
+	})
+
+	expected := map[string]VerifyStatus{
+		"ok.ext":      VerifyOK,
+		"corrupt.ext": VerifyMismatch,
+		"missing.ext": VerifyError,
+	}
+	if diff := cmp.Diff(expected, statuses); diff != "" {
+		t.Errorf("Verify:\n%s", diff)
+	}
+	if report.OK != 1 || report.Mismatched != 1 || report.Errors != 1 {
+		t.Errorf("Verify: unexpected counts %d/%d/%d", report.OK, report.Mismatched, report.Errors)
+	}
+	if report.ExitCode() != 2 {
+		t.Errorf("ExitCode: 2 != %d", report.ExitCode())
+	}
+}
+
+func TestVerifyParallel(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%02d.ext", i)), fmt.Sprintf("contents %02d", i))
+	}
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "file07.ext"), "corrupted!!")
+
+	reported := map[string]int{}
+	report := VerifyWithOptions(boffin, &VerifyOptions{Workers: 4}, func(result *VerifyResult) {
+		reported[result.File.Path()]++
+		if result.Status != VerifyOK && result.File.Path() != "file07.ext" {
+			t.Errorf("%s: unexpected status %d", result.File.Path(), result.Status)
+		}
+	})
+
+	if len(reported) != 20 {
+		t.Errorf("Verify: 20 != %d files reported", len(reported))
+	}
+	for path, count := range reported {
+		if count != 1 {
+			t.Errorf("%s: reported %d times", path, count)
+		}
+	}
+	if report.OK != 19 || report.Mismatched != 1 || report.Errors != 0 {
+		t.Errorf("Verify: unexpected counts %d/%d/%d", report.OK, report.Mismatched, report.Errors)
+	}
+	if report.ExitCode() != 1 {
+		t.Errorf("ExitCode: 1 != %d", report.ExitCode())
+	}
+}
+
+func TestVerifyPatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "photos", "2021", "a.jpg"), "a")
+	writeTestFile(t, filepath.Join(dir, "photos", "2021", "05", "b.jpg"), "b")
+	writeTestFile(t, filepath.Join(dir, "photos", "2022", "c.jpg"), "c")
+	writeTestFile(t, filepath.Join(dir, "docs", "d.txt"), "d")
+	writeTestFile(t, filepath.Join(dir, "photos", "2021", "deleted.jpg"), "deleted")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = os.Remove(filepath.Join(dir, "photos", "2021", "deleted.jpg")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	verified := func(patterns ...string) ([]string, *VerifyReport) {
+		paths := []string{}
+		report := VerifyWithOptions(boffin, &VerifyOptions{Patterns: patterns}, func(result *VerifyResult) {
+			paths = append(paths, result.File.Path())
+		})
+		sort.Strings(paths)
+		return paths, report
+	}
+
+	paths, report := verified("photos/2021/**", "docs/*.txt")
+	expected := []string{"docs/d.txt", "photos/2021/05/b.jpg", "photos/2021/a.jpg"}
+	if diff := cmp.Diff(expected, paths); diff != "" {
+		t.Errorf("Verify:\n%s", diff)
+	}
+	if report.ExitCode() != 0 {
+		t.Errorf("ExitCode: 0 != %d", report.ExitCode())
+	}
+
+	paths, report = verified()
+	if len(paths) != 4 {
+		t.Errorf("Verify: 4 != %d files", len(paths))
+	}
+
+	paths, report = verified("photos/2022/*", "videos/**")
+	if diff := cmp.Diff([]string{"photos/2022/c.jpg"}, paths); diff != "" {
+		t.Errorf("Verify:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"videos/**"}, report.UnmatchedPatterns); diff != "" {
+		t.Errorf("UnmatchedPatterns:\n%s", diff)
+	}
+	if report.ExitCode() != 3 {
+		t.Errorf("ExitCode: 3 != %d", report.ExitCode())
+	}
+}
+
+func TestFindBoffinDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{
+		"a/.boffin",
+		"a/nested/.boffin",
+		"b/c/.boffin",
+		".hidden/.boffin",
+		"d/empty",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0777); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	actual, err := FindBoffinDirs(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(actual)
+
+	expected := []string{
+		filepath.Join(root, "a/.boffin"),
+		filepath.Join(root, "b/c/.boffin"),
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("FindBoffinDirs:\n%s", diff)
+	}
+}
+
+func TestVerifyInFlux(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "stable.ext"), "stable")
+	writeTestFile(t, filepath.Join(dir, "changing.ext"), "changing")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// simulate the file being written to while it is being hashed
+	defer func() {
+		calculateChecksum = CalculateChecksumWith
+	}()
+	calculateChecksum = func(path string, algorithm HashAlgorithm) (string, error) {
+		checksum, err := CalculateChecksumWith(path, algorithm)
+		if filepath.Base(path) == "changing.ext" {
+			writeTestFile(t, path, "changing and growing")
+		}
+		return checksum, err
+	}
+
+	statuses := map[string]VerifyStatus{}
+	report := Verify(boffin, func(result *VerifyResult) {
+		statuses[result.File.Path()] = result.Status
+	})
+
+	expected := map[string]VerifyStatus{
+		"stable.ext":   VerifyOK,
+		"changing.ext": VerifyInFlux,
+	}
+	if diff := cmp.Diff(expected, statuses); diff != "" {
+		t.Errorf("Verify:\n%s", diff)
+	}
+	if report.InFlux != 1 || report.Mismatched != 0 {
+		t.Errorf("Verify: unexpected counts %d in flux, %d mismatched", report.InFlux, report.Mismatched)
+	}
+	if report.ExitCode() != 0 {
+		t.Errorf("ExitCode: 0 != %d", report.ExitCode())
+	}
+}
+
+func TestVerifyMoved(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "original.ext"), "content")
+	writeTestFile(t, filepath.Join(dir, "copy.ext"), "content")
+	writeTestFile(t, filepath.Join(dir, "gone.ext"), "gone")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"original.ext", "gone.ext"} {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	results := map[string]*VerifyResult{}
+	report := Verify(boffin, func(result *VerifyResult) {
+		results[result.File.Path()] = result
+	})
+
+	if result := results["original.ext"]; result.Status != VerifyMoved {
+		t.Errorf("original.ext: expected possibly moved, got %v", result.Status)
+	} else if diff := cmp.Diff([]string{"copy.ext"}, result.MovedTo); diff != "" {
+		t.Errorf("MovedTo:\n%s", diff)
+	}
+	if results["gone.ext"].Status != VerifyError {
+		t.Errorf("gone.ext: expected error, got %v", results["gone.ext"].Status)
+	}
+	if report.Moved != 1 || report.Errors != 1 || report.OK != 1 {
+		t.Errorf("Verify: unexpected counts %d moved, %d errors, %d ok", report.Moved, report.Errors, report.OK)
+	}
+}
+
+func TestVerifyFixMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "touched.ext"), "touched")
+	writeTestFile(t, filepath.Join(dir, "untouched.ext"), "untouched")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checksum := boffin.GetFileByPath("touched.ext").Checksum()
+	touched := boffin.GetFileByPath("touched.ext").Time().Add(time.Hour)
+	if err = os.Chtimes(filepath.Join(dir, "touched.ext"), touched, touched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// read-only unless asked to fix
+	report := Verify(boffin, nil)
+	if report.OK != 2 || report.MetadataFixed != 0 || len(boffin.GetFileByPath("touched.ext").History) != 1 {
+		t.Errorf("Verify: expected no changes, got %d ok, %d fixed", report.OK, report.MetadataFixed)
+	}
+
+	report = VerifyWithOptions(boffin, &VerifyOptions{FixMetadata: true}, nil)
+	if report.OK != 2 || report.MetadataFixed != 1 {
+		t.Errorf("Verify: expected 2 ok and 1 fixed, got %d ok, %d fixed", report.OK, report.MetadataFixed)
+	}
+	file := boffin.GetFileByPath("touched.ext")
+	if len(file.History) != 1 {
+		t.Fatalf("touched.ext: expected latest event updated in place, got %v", file.History)
+	}
+	if !file.Time().Equal(touched) || file.Checksum() != checksum {
+		t.Errorf("touched.ext: expected time %v and same checksum, got %v", touched, file.History[0])
+	}
+	if untouched := boffin.GetFileByPath("untouched.ext"); len(untouched.History) != 1 {
+		t.Errorf("untouched.ext: expected no new events, got %v", untouched.History)
+	}
+}
+
+func TestVerifyStale(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "fresh.ext"), "fresh")
+	writeTestFile(t, filepath.Join(dir, "stale.ext"), "stale")
+	writeTestFile(t, filepath.Join(dir, "unverified.ext"), "unverified")
+
+	dbDir := ConstuctDbPath(dir)
+	boffin, err := InitDbDir(dbDir, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// not recorded unless asked to
+	Verify(boffin, nil)
+	if verified := boffin.GetFileByPath("fresh.ext").LastVerified; verified != nil {
+		t.Errorf("Verify: expected nothing recorded, got %v", verified)
+	}
+
+	before := time.Now()
+	report := VerifyWithOptions(boffin, &VerifyOptions{RecordVerified: true, Patterns: []string{"fresh.ext", "stale.ext"}}, nil)
+	if report.OK != 2 {
+		t.Fatalf("Verify: expected 2 ok, got %d", report.OK)
+	}
+	verified := boffin.GetFileByPath("fresh.ext").LastVerified
+	if verified == nil || verified.Before(before) {
+		t.Fatalf("fresh.ext: expected to be verified after %v, got %v", before, verified)
+	}
+	old := time.Now().Add(-48 * time.Hour).UTC()
+	boffin.GetFileByPath("stale.ext").LastVerified = &old
+
+	// verification times must survive saving to the journal
+	if err = boffin.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloaded, err := LoadBoffin(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := reloaded.GetFileByPath("fresh.ext").LastVerified; actual == nil || !actual.Equal(*verified) {
+		t.Errorf("fresh.ext: expected %v after reload, got %v", verified, actual)
+	}
+	if actual := reloaded.GetFileByPath("stale.ext").LastVerified; actual == nil || !actual.Equal(old) {
+		t.Errorf("stale.ext: expected %v after reload, got %v", old, actual)
+	}
+
+	paths := []string{}
+	report = VerifyWithOptions(reloaded, &VerifyOptions{StaleBefore: time.Now().Add(-24 * time.Hour)}, func(result *VerifyResult) {
+		paths = append(paths, result.File.Path())
+	})
+	sort.Strings(paths)
+	if diff := cmp.Diff([]string{"stale.ext", "unverified.ext"}, paths); diff != "" {
+		t.Errorf("Verify:\n%s", diff)
+	}
+}
+
+func TestVerifyProgress(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%02d.ext", i)), fmt.Sprintf("contents %02d", i))
+	}
+	writeTestFile(t, filepath.Join(dir, "other.txt"), "other")
+
+	boffin, err := InitDbDir(ConstuctDbPath(dir), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Update(boffin, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	progress := []VerifyProgress{}
+	VerifyWithOptions(boffin, &VerifyOptions{
+		Workers:  4,
+		Patterns: []string{"*.ext"},
+		Progress: func(p VerifyProgress) {
+			progress = append(progress, p)
+		},
+	}, nil)
+
+	if len(progress) != 11 {
+		t.Fatalf("Progress: expected 11 calls, got %d", len(progress))
+	}
+	for i, p := range progress[:10] {
+		if p.Checked != i+1 || p.Total != 10 || p.TotalBytes != 110 || p.Done || p.Current == "" {
+			t.Errorf("Progress: unexpected progress %+v", p)
+		}
+	}
+	last := progress[10]
+	if !last.Done || last.Checked != 10 || last.Bytes != last.TotalBytes {
+		t.Errorf("Progress: expected all files done, got %+v", last)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2020 Milutin Jovanvović
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// walkReaders is the number of directories read at the same time by
+// parallelWalk. Reading is mostly waiting on the file system, so this can be
+// well above the number of CPUs, which helps a lot on network file systems.
+const walkReaders = 16
+
+// dirListing holds entries of a directory, read ahead of the walk reaching it.
+type dirListing struct {
+	done  chan struct{}
+	names []string
+	infos []os.FileInfo // nil where lstat failed
+	errs  []error       // lstat errors, by entry
+	err   error         // error reading the directory itself
+}
+
+// parallelWalk is the same as filepath.Walk, with walkFn called in the same
+// order, but directories are read and their entries stat-ed concurrently,
+// ahead of the walk. Once a directory is reached, all its subdirectories are
+// queued for reading; at most walkReaders are read at a time, and readers stop
+// once the walk is done. Directories skipped by walkFn may still be read, but
+// their subdirectories are not.
+func parallelWalk(root string, walkFn filepath.WalkFunc) error {
+	w := &parallelWalker{
+		readers: make(chan struct{}, walkReaders),
+		stop:    make(chan struct{}),
+	}
+	defer close(w.stop)
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = w.walk(root, info, w.read(root), walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+type parallelWalker struct {
+	readers chan struct{}
+	stop    chan struct{}
+}
+
+// read starts reading the directory, once a reader is available.
+func (w *parallelWalker) read(dir string) *dirListing {
+	return w.readAll([]string{dir})[0]
+}
+
+// readAll starts reading the directories in order. A single goroutine waits
+// for readers, so that a directory with many subdirectories does not start a
+// goroutine for each of them at once.
+func (w *parallelWalker) readAll(dirs []string) []*dirListing {
+	listings := make([]*dirListing, len(dirs))
+	for i := range listings {
+		listings[i] = &dirListing{done: make(chan struct{})}
+	}
+	go func() {
+		for i, dir := range dirs {
+			select {
+			case w.readers <- struct{}{}:
+			case <-w.stop:
+				return
+			}
+			go func(dir string, listing *dirListing) {
+				defer func() { <-w.readers }()
+				listing.read(dir)
+				close(listing.done)
+			}(dir, listings[i])
+		}
+	}()
+	return listings
+}
+
+func (l *dirListing) read(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		l.err = err
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		l.err = err
+		return
+	}
+	sort.Strings(names)
+	l.names = names
+	l.infos = make([]os.FileInfo, len(names))
+	l.errs = make([]error, len(names))
+	for i, name := range names {
+		l.infos[i], l.errs[i] = os.Lstat(filepath.Join(dir, name))
+	}
+}
+
+// walk mirrors the walk in filepath.Walk.
+func (w *parallelWalker) walk(path string, info os.FileInfo, listing *dirListing, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(path, info, nil)
+	}
+
+	<-listing.done
+	err := walkFn(path, info, listing.err)
+	if listing.err != nil || err != nil {
+		return err
+	}
+
+	subdirs := []string{}
+	for i, name := range listing.names {
+		if listing.errs[i] == nil && listing.infos[i].IsDir() {
+			subdirs = append(subdirs, filepath.Join(path, name))
+		}
+	}
+	subListings := w.readAll(subdirs)
+
+	next := 0
+	for i, name := range listing.names {
+		filename := filepath.Join(path, name)
+		if listing.errs[i] != nil {
+			if err := walkFn(filename, listing.infos[i], listing.errs[i]); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		var sub *dirListing
+		if listing.infos[i].IsDir() {
+			sub = subListings[next]
+			next++
+		}
+		err = w.walk(filename, listing.infos[i], sub, walkFn)
+		if err != nil {
+			if !listing.infos[i].IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
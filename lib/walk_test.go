@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func makeTestTree(t testing.TB, dir string, depth, width int) {
+	for i := 0; i < width; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%d.ext", i)), "contents")
+		if depth > 0 {
+			makeTestTree(t, filepath.Join(dir, fmt.Sprintf("dir%d", i)), depth-1, width)
+		}
+	}
+}
+
+func TestParallelWalk(t *testing.T) {
+	dir := t.TempDir()
+	makeTestTree(t, dir, 3, 3)
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	visits := func(walker func(string, filepath.WalkFunc) error) []string {
+		visited := []string{}
+		err := walker(dir, func(path string, info os.FileInfo, err error) error {
+			visited = append(visited, fmt.Sprintf("%s %v %v", path, info != nil && info.IsDir(), err))
+			if info != nil && info.IsDir() && info.Name() == "dir1" {
+				return filepath.SkipDir
+			}
+			if info != nil && info.Name() == "file2.ext" && filepath.Base(filepath.Dir(path)) == "dir2" {
+				// skips the rest of the directory
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return visited
+	}
+
+	expected := visits(filepath.Walk)
+	if diff := cmp.Diff(expected, visits(parallelWalk)); diff != "" {
+		t.Errorf("parallelWalk:\n%s", diff)
+	}
+
+	// errors returned by walkFn stop the walk
+	stop := fmt.Errorf("stop")
+	visited := 0
+	err := parallelWalk(dir, func(path string, info os.FileInfo, err error) error {
+		visited++
+		if visited == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || visited != 10 {
+		t.Errorf("parallelWalk: expected to stop after 10 visits, got %d, %v", visited, err)
+	}
+
+	// missing root is reported to walkFn
+	err = parallelWalk(filepath.Join(dir, "missing"), func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	if !os.IsNotExist(err) {
+		t.Errorf("parallelWalk: expected not exist error, got %v", err)
+	}
+}
+
+func benchmarkWalk(b *testing.B, walker func(string, filepath.WalkFunc) error) {
+	dir := b.TempDir()
+	makeTestTree(b, dir, 3, 6)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := walker(dir, func(path string, info os.FileInfo, err error) error {
+			return err
+		})
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	benchmarkWalk(b, filepath.Walk)
+}
+
+func BenchmarkParallelWalk(b *testing.B) {
+	benchmarkWalk(b, parallelWalk)
+}